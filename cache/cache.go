@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	ContentType  string
+	ETag         string
+	LastModified string
+	Body         []byte
+	expires      time.Time
+}
+
+// Matches reports whether the upstream validators describe the same representation as the entry.
+func (entry *Entry) Matches(etag, lastModified string) bool {
+	if entry.ETag != "" && etag != "" {
+		return entry.ETag == etag
+	}
+	return entry.LastModified != "" && entry.LastModified == lastModified
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+// Cache is a size bounded LRU cache, entries expire after a fixed TTL.
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	size    int
+	items   map[string]*list.Element
+	order   *list.List
+}
+
+func New(ttl time.Duration, maxSize int) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *Cache) Get(key string) *Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*item).entry
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return entry
+}
+
+func (c *Cache) Set(key string, entry *Entry) {
+	if len(entry.Body) > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+	entry.expires = time.Now().Add(c.ttl)
+	c.items[key] = c.order.PushFront(&item{key, entry})
+	c.size += len(entry.Body)
+
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+}
+
+// DeleteFunc removes the entries whose key matches, it returns the number of removed entries.
+func (c *Cache) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for key, element := range c.items {
+		if match(key) {
+			c.remove(element)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *Cache) remove(element *list.Element) {
+	it := c.order.Remove(element).(*item)
+	delete(c.items, it.key)
+	c.size -= len(it.entry.Body)
+}
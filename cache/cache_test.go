@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheGetSet(t *testing.T) {
+	c := New(time.Minute, 1024)
+	c.Set("a", &Entry{Body: []byte("a")})
+
+	if entry := c.Get("a"); entry == nil || string(entry.Body) != "a" {
+		t.Errorf("Expected entry a, Got: %v", entry)
+	}
+	if entry := c.Get("b"); entry != nil {
+		t.Errorf("Expected no entry, Got: %v", entry)
+	}
+
+	c.Delete("a")
+	if entry := c.Get("a"); entry != nil {
+		t.Errorf("Expected no entry after delete, Got: %v", entry)
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	c := New(-time.Second, 1024)
+	c.Set("a", &Entry{Body: []byte("a")})
+
+	if entry := c.Get("a"); entry != nil {
+		t.Errorf("Expected expired entry to be removed, Got: %v", entry)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected empty cache, Got: %d entries", c.Len())
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := New(time.Minute, 4)
+	c.Set("a", &Entry{Body: []byte("aa")})
+	c.Set("b", &Entry{Body: []byte("bb")})
+	c.Get("a")
+	c.Set("c", &Entry{Body: []byte("cc")})
+
+	if c.Get("b") != nil {
+		t.Errorf("Expected least recently used entry to be evicted")
+	}
+	if c.Get("a") == nil || c.Get("c") == nil {
+		t.Errorf("Expected recently used entries to be kept")
+	}
+
+	c.Set("d", &Entry{Body: []byte("ddddd")})
+	if c.Get("d") != nil {
+		t.Errorf("Expected oversized entry to be ignored")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Expected empty cache after purge, Got: %d entries", c.Len())
+	}
+}
+
+func TestEntryMatches(t *testing.T) {
+	entry := &Entry{ETag: `"1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+
+	if !entry.Matches(`"1"`, "") {
+		t.Errorf("Expected matching ETag")
+	}
+	if entry.Matches(`"2"`, "Mon, 02 Jan 2006 15:04:05 GMT") {
+		t.Errorf("Expected ETag mismatch to take precedence")
+	}
+	if !entry.Matches("", "Mon, 02 Jan 2006 15:04:05 GMT") {
+		t.Errorf("Expected matching Last-Modified")
+	}
+	if (&Entry{}).Matches("", "") {
+		t.Errorf("Expected entry without validators to never match")
+	}
+}
+
+func TestCacheDeleteFunc(t *testing.T) {
+	c := New(time.Minute, 1024)
+	c.Set("a1", &Entry{Body: []byte("a")})
+	c.Set("a2", &Entry{Body: []byte("a")})
+	c.Set("b1", &Entry{Body: []byte("b")})
+
+	if deleted := c.DeleteFunc(func(key string) bool { return key[0] == 'a' }); deleted != 2 {
+		t.Errorf("Expected 2 deleted entries, Got: %d", deleted)
+	}
+	if c.Len() != 1 || c.Get("b1") == nil {
+		t.Errorf("Expected entry b1 to be kept, Got: %d entries", c.Len())
+	}
+}
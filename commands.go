@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/friedemannsommer/morty/config"
+	"github.com/friedemannsommer/morty/proxy"
+	"github.com/friedemannsommer/morty/sanitize"
+)
+
+// sanitizeCommand runs "morty sanitize": the document read from in is sanitized to out without any network access.
+// The sanitizer options default to the configuration of the server, cfg.
+func sanitizeCommand(args []string, cfg *config.Config, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("morty sanitize", flag.ExitOnError)
+	baseURLStr := flags.String("base-url", "", "URL of the document, the relative URLs are resolved against it (required)")
+	hmacKey := flags.String("key", os.Getenv("MORTY_KEY"), "HMAC url validation key (base64, hex or raw string) - leave blank to disable validation")
+	documentType := flags.String("type", "html", "Document type: html, css or svg")
+	textOnly := flags.Bool("textonly", cfg.TextOnly, "Remove images, fonts and background images")
+	dataAttributes := flags.Bool("dataattributes", cfg.DataAttributes, "Keep data-* attributes")
+	dropTrackingPixels := flags.Bool("droptrackingpixels", cfg.DropTrackingPixels, "Remove the tracking pixels")
+	blocklists := flags.String("blocklists", cfg.Blocklists, "Comma separated ad and tracker blocklist files")
+	iframePolicy := flags.String("iframes", cfg.IframePolicy, "Proxy iframes: none, same-origin or all")
+	structuredData := flags.String("structureddata", cfg.StructuredData, "Microdata and RDFa attributes: none, preserve or proxify")
+	targetPolicy := flags.String("targets", cfg.TargetPolicy, "Target attributes of the links: keep, self or noopener")
+	sanitizerMode := flags.String("sanitizer", cfg.SanitizerMode, "HTML sanitizer: stream, or tree to repair malformed HTML first")
+	_ = flags.Parse(args)
+
+	if *baseURLStr == "" {
+		return errors.New("-base-url is required")
+	}
+	baseURL, err := url.Parse(*baseURLStr)
+	if err != nil || !baseURL.IsAbs() {
+		return fmt.Errorf("invalid -base-url value: %s", *baseURLStr)
+	}
+	if *iframePolicy != sanitize.IframePolicyNone && *iframePolicy != sanitize.IframePolicySameOrigin && *iframePolicy != sanitize.IframePolicyAll {
+		return fmt.Errorf("invalid -iframes value: %s", *iframePolicy)
+	}
+	if *structuredData != sanitize.StructuredDataNone && *structuredData != sanitize.StructuredDataPreserve && *structuredData != sanitize.StructuredDataProxify {
+		return fmt.Errorf("invalid -structureddata value: %s", *structuredData)
+	}
+	if *targetPolicy != sanitize.TargetPolicyKeep && *targetPolicy != sanitize.TargetPolicySelf && *targetPolicy != sanitize.TargetPolicyNoopener {
+		return fmt.Errorf("invalid -targets value: %s", *targetPolicy)
+	}
+	if *sanitizerMode != proxy.SanitizerModeStream && *sanitizerMode != proxy.SanitizerModeTree {
+		return fmt.Errorf("invalid -sanitizer value: %s", *sanitizerMode)
+	}
+
+	s := &sanitize.Sanitizer{
+		BaseURL:            baseURL,
+		IframePolicy:       *iframePolicy,
+		StructuredData:     *structuredData,
+		TargetPolicy:       *targetPolicy,
+		TextOnly:           *textOnly,
+		DataAttributes:     *dataAttributes,
+		DropTrackingPixels: *dropTrackingPixels,
+		NoHeader:           true,
+	}
+	if *blocklists != "" {
+		blocklist, err := proxy.LoadBlocklist(*blocklists)
+		if err != nil {
+			return fmt.Errorf("invalid -blocklists value: %v", err)
+		}
+		s.BlockedHost = blocklist.Blocks
+	}
+	if *hmacKey != "" {
+		if s.Key, _, err = proxy.DecodeKey(*hmacKey); err != nil {
+			return fmt.Errorf("invalid -key value: %v", err)
+		}
+	}
+
+	switch *documentType {
+	case "html":
+		if *sanitizerMode == proxy.SanitizerModeTree {
+			document, err := io.ReadAll(in)
+			if err != nil {
+				return err
+			}
+			if document, err = sanitize.RepairHTML(document); err != nil {
+				return err
+			}
+			in = bytes.NewReader(document)
+		}
+		return s.HTMLDocument(out, in)
+	case "css":
+		return s.CSS(out, in)
+	case "svg":
+		return s.SVG(out, in)
+	}
+	return fmt.Errorf("invalid -type value: %s", *documentType)
+}
+
+// signCommand runs "morty sign": the morty URLs of the URLs given as arguments are written to out, one per line
+func signCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("morty sign", flag.ExitOnError)
+	hmacKey := flags.String("key", os.Getenv("MORTY_KEY"), "HMAC url validation key (base64, hex or raw string)")
+	mortyURL := flags.String("morty-url", "/", "URL of the morty instance, the signed URLs are relative to it")
+	hashOnly := flags.Bool("hash-only", false, "Print only the mortyhash parameter")
+	expires := flags.Duration("expires", 0, "Reject the signed URLs after the given duration (ie: '24h', 0 if they don't expire)")
+	textOnly := flags.Bool("textonly", false, "Add the mortytext parameter")
+	_ = flags.Parse(args)
+
+	if *hmacKey == "" {
+		return errors.New("-key or MORTY_KEY is required")
+	}
+	key, _, err := proxy.DecodeKey(*hmacKey)
+	if err != nil {
+		return fmt.Errorf("invalid -key value: %v", err)
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no URL to sign")
+	}
+
+	s := &sanitize.Sanitizer{Key: key, TextOnlyParam: *textOnly}
+	if *expires > 0 {
+		s.Expires = time.Now().Add(*expires).Unix()
+	}
+	base := strings.TrimSuffix(*mortyURL, "/") + "/"
+	for _, uri := range flags.Args() {
+		signedURL, err := proxy.ProxifyAbsoluteURL(s, uri)
+		if err != nil {
+			return fmt.Errorf("%s: %v", uri, err)
+		}
+		if *hashOnly {
+			u, _ := url.Parse(strings.TrimSpace(uri))
+			u.Fragment = ""
+			_, err = fmt.Fprintln(out, sanitize.Hash(sanitize.ExpiringURI(u.String(), s.Expires), s.Key))
+		} else {
+			_, err = fmt.Fprintln(out, base+strings.TrimPrefix(signedURL, "./"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// healthcheckCommand runs "morty healthcheck": it returns an error unless the health check endpoint answers 200,
+// so container health checks don't need curl or wget
+func healthcheckCommand(args []string) error {
+	flags := flag.NewFlagSet("morty healthcheck", flag.ExitOnError)
+	healthURL := flags.String("url", defaultHealthURL(os.Getenv("MORTY_ADDRESS")), "URL of the health check endpoint")
+	timeout := flags.Duration("timeout", 5*time.Second, "Timeout of the health check")
+	_ = flags.Parse(args)
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(*healthURL)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New("unexpected status: " + resp.Status)
+	}
+	return nil
+}
+
+// defaultHealthURL returns the URL of the health check endpoint of a server listening on listenAddress,
+// on the loopback interface
+func defaultHealthURL(listenAddress string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		host, port = "", "3000"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + proxy.HealthPath
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/friedemannsommer/morty/config"
+	"github.com/friedemannsommer/morty/proxy"
+	"github.com/friedemannsommer/morty/sanitize"
+)
+
+func TestSanitizeCommand(t *testing.T) {
+	for _, testCase := range []struct {
+		args     []string
+		input    string
+		expected string
+	}{
+		{
+			[]string{"--base-url", "https://example.com/a/"},
+			`<p><a href="b">b</a><script>alert(1)</script></p>`,
+			`<p><a href="./?mortyurl=https%3A%2F%2Fexample.com%2Fa%2Fb">b</a></p>`,
+		},
+		{
+			[]string{"-base-url", "https://example.com/", "-type", "css"},
+			`a{background:url(/x.png)}`,
+			`a{background:url("./?mortyurl=https%3A%2F%2Fexample.com%2Fx.png")}`,
+		},
+	} {
+		out := bytes.NewBuffer(nil)
+		if err := sanitizeCommand(testCase.args, config.DefaultConfig, strings.NewReader(testCase.input), out); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(out.String(), testCase.expected) {
+			t.Errorf("Expected %q, got %q", testCase.expected, out.String())
+		}
+	}
+
+	for _, args := range [][]string{nil, {"-base-url", "/relative"}, {"-base-url", "https://example.com/", "-type", "pdf"}} {
+		if err := sanitizeCommand(args, config.DefaultConfig, strings.NewReader(""), bytes.NewBuffer(nil)); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestSignCommand(t *testing.T) {
+	key := []byte("key")
+	base64Key := base64.StdEncoding.EncodeToString(key)
+	hash := sanitize.Hash("https://example.com/a?b=c", key)
+
+	out := bytes.NewBuffer(nil)
+	if err := signCommand([]string{"-key", base64Key, "-morty-url", "https://morty.example/", "https://example.com/a?b=c#d"}, out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "https://morty.example/?mortyhash=" + hash + "&mortyurl=https%3A%2F%2Fexample.com%2Fa%3Fb%3Dc#d\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	if err := signCommand([]string{"-key", base64Key, "-hash-only", "https://example.com/a?b=c#d"}, out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out.String() != hash+"\n" {
+		t.Errorf("Expected %q, got %q", hash, out.String())
+	}
+
+	for _, args := range [][]string{{"-key", "", "https://example.com/"}, {"-key", base64Key}, {"-key", base64Key, "ftp://example.com/"}} {
+		if err := signCommand(args, bytes.NewBuffer(nil)); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	server := httptest.NewServer(proxy.New())
+	defer server.Close()
+
+	if err := healthcheckCommand([]string{"-url", server.URL + proxy.HealthPath}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := healthcheckCommand([]string{"-url", server.URL + "/?mortyurl=ftp%3A%2F%2Fexample.com%2F&mortyexpires=x"}); err == nil {
+		t.Error("Expected an error for a 400 response")
+	}
+
+	for listenAddress, expected := range map[string]string{
+		"":               "http://127.0.0.1:3000/healthz",
+		":3000":          "http://127.0.0.1:3000/healthz",
+		"[::]:8080":      "http://127.0.0.1:8080/healthz",
+		"127.0.0.2:3000": "http://127.0.0.2:3000/healthz",
+	} {
+		if healthURL := defaultHealthURL(listenAddress); healthURL != expected {
+			t.Errorf("%q: expected %s, got %s", listenAddress, expected, healthURL)
+		}
+	}
+}
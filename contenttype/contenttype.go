@@ -1,6 +1,7 @@
 package contenttype
 
 import (
+	"fmt"
 	"mime"
 	"strings"
 )
@@ -68,6 +69,78 @@ func ParseContentType(contentType string) (ContentType, error) {
 
 }
 
+// ParseContentTypeHeader parses a Content-Type header like the Fetch standard: of several comma separated values
+// the last valid one is used, it keeps the charset of the previous values of the same media type.
+// The malformed parameters are ignored, and a value without subtype is invalid.
+func ParseContentTypeHeader(header string) (ContentType, error) {
+	var result ContentType
+	found := false
+	for _, value := range splitUnquoted(header, ',') {
+		contentType, err := parseLenientContentType(value)
+		if err != nil || contentType.SubType == "" || (contentType.TopLevelType == "*" && contentType.SubType == "*") {
+			continue
+		}
+		if found && contentType.TopLevelType == result.TopLevelType && contentType.SubType == result.SubType &&
+			contentType.Suffix == result.Suffix {
+			if charset, ok := result.Parameters["charset"]; ok && contentType.Parameters["charset"] == "" {
+				contentType.Parameters["charset"] = charset
+			}
+		}
+		result = contentType
+		found = true
+	}
+	if !found {
+		return result, fmt.Errorf("invalid content type: %q", header)
+	}
+	return result, nil
+}
+
+// parseLenientContentType parses a media type and its parameters one by one, skipping the invalid
+// and the duplicate parameters
+func parseLenientContentType(value string) (ContentType, error) {
+	if contentType, err := ParseContentType(value); err == nil {
+		return contentType, nil
+	}
+	parts := splitUnquoted(value, ';')
+	contentType, err := ParseContentType(parts[0])
+	if err != nil {
+		return contentType, err
+	}
+	for _, part := range parts[1:] {
+		_, params, err := mime.ParseMediaType("x/x;" + part)
+		if err != nil {
+			continue
+		}
+		for k, v := range params {
+			if _, ok := contentType.Parameters[k]; !ok {
+				contentType.Parameters[k] = v
+			}
+		}
+	}
+	return contentType, nil
+}
+
+// splitUnquoted splits s around the separators outside of the quoted strings
+func splitUnquoted(s string, separator byte) []string {
+	var values []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == separator:
+			values = append(values, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(values, s[start:])
+}
+
 type Filter func(contentType ContentType) bool
 
 func NewFilterContains(partialMimeType string) Filter {
@@ -96,3 +169,34 @@ func NewFilterOr(contentTypeFilterList []Filter) Filter {
 		return false
 	}
 }
+
+// ParseFilter returns the filter of a comma separated list of patterns like "image/*, text/html, application/*+xml".
+// "*" matches any type, subtype or suffix, a subtype "*" without suffix matches any suffix.
+// The parameters of the content types are ignored.
+func ParseFilter(patterns string) (Filter, error) {
+	var filters []Filter
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		splittedPattern := strings.SplitN(pattern, "/", 2)
+		if len(splittedPattern) != 2 || strings.ContainsAny(pattern, " \t;") {
+			return nil, fmt.Errorf("invalid content type pattern %q", pattern)
+		}
+		topLevelType, subType, suffix := splittedPattern[0], splittedPattern[1], ""
+		if i := strings.IndexByte(subType, '+'); i >= 0 {
+			subType, suffix = subType[:i], subType[i+1:]
+			if suffix == "" {
+				return nil, fmt.Errorf("invalid content type pattern %q", pattern)
+			}
+		} else if subType == "*" {
+			suffix = "*"
+		}
+		if topLevelType == "" || subType == "" {
+			return nil, fmt.Errorf("invalid content type pattern %q", pattern)
+		}
+		filters = append(filters, NewFilterEquals(topLevelType, subType, suffix))
+	}
+	return NewFilterOr(filters), nil
+}
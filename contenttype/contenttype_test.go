@@ -148,6 +148,29 @@ var filterTestCases = []FilterTestCase{
 			{"application", "xhtml", "xml", MapEmpty},
 		},
 	},
+	{
+		"parsed image/*, Text/HTML, application/*+xml",
+		mustParseFilter("image/*, Text/HTML,, application/*+xml"),
+		[]ContentType{
+			{"image", "png", "", MapEmpty},
+			{"image", "svg", "xml", MapEmpty},
+			{"text", "html", "", map[string]string{"charset": "utf-8"}},
+			{"application", "atom", "xml", MapEmpty},
+		},
+		[]ContentType{
+			{"text", "css", "", MapEmpty},
+			{"text", "html", "xml", MapEmpty},
+			{"application", "atom", "", MapEmpty},
+		},
+	},
+}
+
+func mustParseFilter(patterns string) Filter {
+	filter, err := ParseFilter(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return filter
 }
 
 type FilterParametersTestCase struct {
@@ -265,3 +288,39 @@ func TestFilterParameters(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFilterError(t *testing.T) {
+	for _, patterns := range []string{"image", "image/", "/png", "text/html; charset=utf-8", "application/xml+", "text/html, video"} {
+		if _, err := ParseFilter(patterns); err == nil {
+			t.Errorf(`Expecting error for "%s"`, patterns)
+		}
+	}
+}
+
+func TestParseContentTypeHeader(t *testing.T) {
+	for _, testCase := range []struct {
+		Input          string
+		ExpectedOutput ContentType
+	}{
+		{"text/html", ContentType{"text", "html", "", MapEmpty}},
+		{"text/plain, text/html", ContentType{"text", "html", "", MapEmpty}},
+		{"text/html; charset=gbk, text/html", ContentType{"text", "html", "", map[string]string{"charset": "gbk"}}},
+		{"text/plain; charset=gbk, text/html", ContentType{"text", "html", "", MapEmpty}},
+		{"text/html, */*", ContentType{"text", "html", "", MapEmpty}},
+		{"text/html, text", ContentType{"text", "html", "", MapEmpty}},
+		{`text/html; charset="a,b"`, ContentType{"text", "html", "", map[string]string{"charset": "a,b"}}},
+		{"text/html; charset=utf-8; charset=iso-8859-1", ContentType{"text", "html", "", map[string]string{"charset": "utf-8"}}},
+		{"text/html; charset; charset=utf-8; =x;", ContentType{"text", "html", "", map[string]string{"charset": "utf-8"}}},
+		{"Text/HTML;Charset=UTF-8", ContentType{"text", "html", "", map[string]string{"charset": "UTF-8"}}},
+	} {
+		contentType, err := ParseContentTypeHeader(testCase.Input)
+		if err != nil || !contentType.Equals(testCase.ExpectedOutput) {
+			t.Errorf(`Unexpecting result for "%s": "%s" %v`, testCase.Input, contentType.String(), err)
+		}
+	}
+	for _, header := range []string{"", "text", "text/", "*/*", ", ;"} {
+		if _, err := ParseContentTypeHeader(header); err == nil {
+			t.Errorf(`Expecting error for "%s"`, header)
+		}
+	}
+}
@@ -3,6 +3,7 @@ module github.com/friedemannsommer/morty
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/valyala/fasthttp v1.33.0
 	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd
 	golang.org/x/text v0.3.7
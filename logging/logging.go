@@ -0,0 +1,176 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return "level(" + strconv.Itoa(int(level)) + ")"
+	}
+	return levelNames[level]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, errors.New("unknown log level: " + name)
+}
+
+func ValidFormat(format string) bool {
+	return format == FormatLogfmt || format == FormatJSON
+}
+
+// Logger writes one line per record: the time, the level, the message and key/value pairs.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	now    func() time.Time
+}
+
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{out: out, level: level, format: format, now: time.Now}
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.level = level
+}
+
+func (l *Logger) SetFormat(format string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.format = format
+}
+
+// Enabled reports whether the records of level are written
+func (l *Logger) Enabled(level Level) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level < l.level {
+		return
+	}
+	fields := append([]interface{}{"time", l.now().UTC().Format(time.RFC3339), "level", level.String(), "msg", msg}, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	line := bytes.NewBuffer(nil)
+	if l.format == FormatJSON {
+		writeJSON(line, fields)
+	} else {
+		writeLogfmt(line, fields)
+	}
+	line.WriteByte('\n')
+	_, _ = l.out.Write(line.Bytes())
+}
+
+func writeLogfmt(line *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(fmt.Sprint(fields[i]))
+		line.WriteByte('=')
+		value := formatValue(fields[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"\\") || strings.IndexFunc(value, isControl) != -1 {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(value)
+	}
+}
+
+func writeJSON(line *bytes.Buffer, fields []interface{}) {
+	line.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		line.Write(key)
+		line.WriteByte(':')
+		var value []byte
+		switch v := fields[i+1].(type) {
+		case bool, int, int64, uint, uint64, float64:
+			value, _ = json.Marshal(v)
+		default:
+			value, _ = json.Marshal(formatValue(v))
+		}
+		line.Write(value)
+	}
+	line.WriteByte('}')
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
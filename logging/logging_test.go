@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLogger(level Level, format string) (*Logger, *bytes.Buffer) {
+	out := bytes.NewBuffer(nil)
+	l := New(out, level, format)
+	l.now = func() time.Time { return time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, out
+}
+
+func TestLogfmt(t *testing.T) {
+	l, out := newTestLogger(LevelInfo, FormatLogfmt)
+	l.Debug("hidden")
+	l.Warn("upstream error", "status", 503, "error", errors.New("connection refused"), "host", "example.com")
+	expected := `time=2022-01-02T03:04:05Z level=warn msg="upstream error" status=503 error="connection refused" host=example.com` + "\n"
+	if out.String() != expected {
+		t.Errorf("Expected %s, Got: %s", expected, out.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, out := newTestLogger(LevelDebug, FormatJSON)
+	l.Debug("request", "method", "GET", "status", 200, "duration", time.Second, "odd")
+	expected := `{"time":"2022-01-02T03:04:05Z","level":"debug","msg":"request","method":"GET","status":200,"duration":"1s","odd":""}` + "\n"
+	if out.String() != expected {
+		t.Errorf("Expected %s, Got: %s", expected, out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("WARN"); err != nil || level != LevelWarn {
+		t.Errorf("Expected warn, Got: %v %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"net/url"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/html"
+)
+
+// policies of the AMP pages, they render as empty pages without the amp-* scripts
+const (
+	AMPPolicyKeep     = "keep"
+	AMPPolicyRedirect = "redirect"
+	AMPPolicyRewrite  = "rewrite"
+)
+
+// ampCanonicalURL returns the canonical URL of an AMP page, nil if the document is not an AMP page
+// (<html amp> or <html ⚡>) or has no <link rel="canonical"> in its head
+func ampCanonicalURL(doc []byte, base *url.URL) *url.URL {
+	z := html.NewTokenizer(bytes.NewReader(doc))
+	amp := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttrs := z.TagName()
+			switch string(tag) {
+			case "html":
+				for hasAttrs {
+					var name []byte
+					name, _, hasAttrs = z.TagAttr()
+					if string(name) == "amp" || string(name) == "⚡" {
+						amp = true
+					}
+				}
+				if !amp {
+					return nil
+				}
+			case "link":
+				if !amp {
+					continue
+				}
+				var rel, href []byte
+				for hasAttrs {
+					var name, value []byte
+					name, value, hasAttrs = z.TagAttr()
+					switch string(name) {
+					case "rel":
+						rel = append([]byte{}, value...)
+					case "href":
+						href = append([]byte{}, value...)
+					}
+				}
+				if hasRelKeyword(rel, "canonical") && len(href) > 0 {
+					canonical, err := base.Parse(string(bytes.TrimSpace(href)))
+					if err != nil || (canonical.Scheme != "http" && canonical.Scheme != "https") {
+						return nil
+					}
+					return canonical
+				}
+			case "body":
+				// the canonical link is in the head
+				return nil
+			}
+		}
+	}
+}
+
+// hasRelKeyword reports whether a rel attribute contains keyword
+func hasRelKeyword(rel []byte, keyword string) bool {
+	for _, value := range bytes.Fields(rel) {
+		if bytes.EqualFold(value, []byte(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCanonicalPage serves the canonical page of an AMP page according to the AMP policy,
+// it returns false if the AMP page must be served
+func (p *Proxy) serveCanonicalPage(ctx *fasthttp.RequestCtx, parsedURI *url.URL, doc []byte, redirectCount int) bool {
+	canonical := ampCanonicalURL(doc, parsedURI)
+	// the canonical page of an AMP only site is the page itself
+	if canonical == nil || redirectKey(canonical) == redirectKey(parsedURI) || isDowngradeRedirect(parsedURI, canonical) {
+		return false
+	}
+	p.Logger.Debug("AMP page", "canonical", canonical.String(), "policy", p.AMPPolicy)
+	if p.AMPPolicy == AMPPolicyRedirect {
+		s := p.sanitizer(parsedURI, ctx.UserValue("mortytext") != nil)
+		p.setLocation(ctx, proxifiedLocation(s, canonical))
+		// HTTP status code 302 : Found
+		ctx.SetStatusCode(302)
+		return true
+	}
+	if isRedirectCycle(ctx, parsedURI, canonical) || redirectCount >= MaxRedirectCount {
+		return false
+	}
+	p.ProcessUri(ctx, canonical.String(), redirectCount+1)
+	return true
+}
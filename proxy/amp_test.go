@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAMPCanonicalURL(t *testing.T) {
+	base, _ := url.Parse("https://example.com/amp/page")
+	for _, test := range []struct {
+		doc       string
+		canonical string
+	}{
+		{`<html amp><head><link rel="canonical" href="/page"></head></html>`, "https://example.com/page"},
+		{`<!doctype html><html ⚡ lang="en"><head><link rel="amphtml" href="/x"><link href="https://example.org/p" rel="Canonical"></head></html>`, "https://example.org/p"},
+		{`<html><head><link rel="canonical" href="/page"></head></html>`, ""},
+		{`<html amp><head></head><body><link rel="canonical" href="/page"></body></html>`, ""},
+		{`<html amp><head><link rel="canonical" href="javascript:x()"></head></html>`, ""},
+	} {
+		canonical := ampCanonicalURL([]byte(test.doc), base)
+		if (canonical == nil && test.canonical != "") || (canonical != nil && canonical.String() != test.canonical) {
+			t.Errorf("%s: expected %q, got %v", test.doc, test.canonical, canonical)
+		}
+	}
+}
+
+func TestAMPPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/amp":
+			_, _ = io.WriteString(w, `<html amp><head><link rel="canonical" href="/page"></head><body><amp-img src="a.png"></amp-img></body></html>`)
+		case "/loop":
+			_, _ = io.WriteString(w, `<html amp><head><link rel="canonical" href="/loop?x"></head><body>loop</body></html>`)
+		case "/page":
+			_, _ = io.WriteString(w, `<html><body><p>canonical page</p></body></html>`)
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		policy   string
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{AMPPolicyKeep, "/amp", 200, "", "amp-img"},
+		{AMPPolicyRewrite, "/amp", 200, "", "canonical page"},
+		{AMPPolicyRedirect, "/amp", 302, "./?mortyurl=" + url.QueryEscape(server.URL+"/page"), ""},
+		{AMPPolicyRewrite, "/loop", 200, "", "loop"},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		New(func(p *Proxy) { p.AMPPolicy = test.policy }).ProcessUri(ctx, server.URL+test.path, 0)
+		location := string(ctx.Response.Header.Peek("Location"))
+		if ctx.Response.StatusCode() != test.status || location != test.location || !strings.Contains(string(ctx.Response.Body()), test.body) {
+			t.Errorf("%s %s: expected %d %q %q, got %d %q %s", test.policy, test.path, test.status, test.location, test.body, ctx.Response.StatusCode(), location, ctx.Response.Body())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+// path of the URL proxification endpoint, enabled by the -admintoken flag
+const ProxifyPath = "/api/proxify"
+
+// path of the batch URL signing endpoint, enabled by the -admintoken flag
+const SignPath = "/api/sign"
+
+// maximum number of URLs of a proxification request
+const ProxifyMaxURLs = 100
+
+// maximum number of URLs of a batch signing request
+const SignMaxURLs = 1000
+
+// ProxifyRequest is the body of the proxification and batch signing requests,
+// the options are shared by all the URLs
+type ProxifyRequest struct {
+	URLs     []string `json:"urls"`
+	TextOnly bool     `json:"text_only"`
+	// unix time after which the morty URLs are rejected, 0 if they don't expire
+	Expires int64 `json:"expires"`
+}
+
+// ProxifiedURL is the morty URL of an upstream URL, or the reason why it can't be proxified
+type ProxifiedURL struct {
+	URL      string `json:"url"`
+	MortyURL string `json:"morty_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ProxifyResult struct {
+	URLs []ProxifiedURL `json:"urls"`
+}
+
+// serveProxify returns the signed morty URLs of the http and https URLs of a JSON ProxifyRequest.
+// The request must be authenticated like the purge requests.
+func (p *Proxy) serveProxify(ctx *fasthttp.RequestCtx) {
+	request, ok := p.readProxifyRequest(ctx, ProxifyMaxURLs)
+	if !ok {
+		return
+	}
+
+	s, base := p.apiSanitizer(ctx, request)
+	result := ProxifyResult{URLs: make([]ProxifiedURL, 0, len(request.URLs))}
+	for _, uri := range request.URLs {
+		proxified := ProxifiedURL{URL: uri}
+		if mortyURL, err := ProxifyAbsoluteURL(s, uri); err != nil {
+			proxified.Error = err.Error()
+		} else {
+			proxified.MortyURL = base + strings.TrimPrefix(mortyURL, "./")
+		}
+		result.URLs = append(result.URLs, proxified)
+	}
+
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(result)
+}
+
+// serveSign is the compact version of serveProxify for large batches: it returns an array of morty URLs
+// in the order of the request, the URLs which can't be proxified are empty strings.
+func (p *Proxy) serveSign(ctx *fasthttp.RequestCtx) {
+	request, ok := p.readProxifyRequest(ctx, SignMaxURLs)
+	if !ok {
+		return
+	}
+
+	s, base := p.apiSanitizer(ctx, request)
+	result := make([]string, len(request.URLs))
+	for i, uri := range request.URLs {
+		if mortyURL, err := ProxifyAbsoluteURL(s, uri); err == nil {
+			result[i] = base + strings.TrimPrefix(mortyURL, "./")
+		}
+	}
+
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(result)
+}
+
+// readProxifyRequest authenticates and parses a ProxifyRequest, the error response is sent if it returns false
+func (p *Proxy) readProxifyRequest(ctx *fasthttp.RequestCtx, maxURLs int) (*ProxifyRequest, bool) {
+	if !p.authorizeAdmin(ctx) {
+		return nil, false
+	}
+	if !ctx.IsPost() {
+		ctx.Response.Header.Set("Allow", fasthttp.MethodPost)
+		// HTTP status code 405 : Method Not Allowed
+		ctx.Error("method not allowed", 405)
+		return nil, false
+	}
+
+	request := &ProxifyRequest{}
+	if err := json.Unmarshal(ctx.PostBody(), request); err != nil {
+		// HTTP status code 400 : Bad Request
+		ctx.Error("invalid JSON: "+err.Error(), 400)
+		return nil, false
+	}
+	if request.Expires != 0 && request.Expires <= time.Now().Unix() {
+		// HTTP status code 400 : Bad Request
+		ctx.Error("expires must be a future unix time", 400)
+		return nil, false
+	}
+	if len(request.URLs) > maxURLs {
+		// HTTP status code 413 : Payload Too Large
+		ctx.Error("too many URLs", 413)
+		return nil, false
+	}
+	return request, true
+}
+
+// apiSanitizer returns the sanitizer signing the URLs of request and the base of the morty URLs:
+// they are absolute since they are used outside of the proxied pages, under the path where morty is mounted
+func (p *Proxy) apiSanitizer(ctx *fasthttp.RequestCtx, request *ProxifyRequest) (*sanitize.Sanitizer, string) {
+	s := &sanitize.Sanitizer{Key: p.Key, TextOnlyParam: request.TextOnly, Expires: request.Expires}
+	base := p.externalURL(ctx)
+	base.Path = p.mountPath(ctx)
+	return s, base.String()
+}
+
+// ProxifyAbsoluteURL returns the relative morty URL of an absolute http or https URL
+func ProxifyAbsoluteURL(s *sanitize.Sanitizer, uri string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("not an absolute http or https URL")
+	}
+	fragment := ""
+	if u.Fragment != "" {
+		fragment = "#" + u.EscapedFragment()
+	}
+	u.Fragment = ""
+	return s.ProxifiedURL(u, fragment), nil
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+func TestProxifyAPI(t *testing.T) {
+	p := New(WithKey([]byte("key")), WithAdminToken([]byte("secret"), TelemetryHostsFull))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://morty.local" + ProxifyPath)
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetBodyString(`{"urls":["https://example.com/a?b=c#d","ftp://example.com/","/relative"]}`)
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 401 {
+		t.Errorf("Expected 401 without token, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx.Response.Reset()
+	ctx.Request.Header.Set("Authorization", "Bearer secret")
+	p.RequestHandler(ctx)
+	var result ProxifyResult
+	if err := json.Unmarshal(ctx.Response.Body(), &result); err != nil {
+		t.Fatalf("Invalid response %q: %v", ctx.Response.Body(), err)
+	}
+	if len(result.URLs) != 3 || result.URLs[1].Error == "" || result.URLs[2].Error == "" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	mortyURL, err := url.Parse(result.URLs[0].MortyURL)
+	if err != nil || mortyURL.Host != "morty.local" || mortyURL.Fragment != "d" {
+		t.Fatalf("Invalid morty URL %q", result.URLs[0].MortyURL)
+	}
+	uri := mortyURL.Query().Get("mortyurl")
+	if uri != "https://example.com/a?b=c" || !sanitize.VerifyURI([]byte(uri), []byte(mortyURL.Query().Get("mortyhash")), p.Key) {
+		t.Errorf("Invalid morty URL signature %q", result.URLs[0].MortyURL)
+	}
+
+	// the fragments are escaped like the fragments of the proxified pages
+	ctx.Response.Reset()
+	ctx.Request.SetBodyString(`{"urls":["https://example.com/#a b<c"]}`)
+	p.RequestHandler(ctx)
+	result = ProxifyResult{}
+	if err := json.Unmarshal(ctx.Response.Body(), &result); err != nil || len(result.URLs) != 1 {
+		t.Fatalf("Invalid response %q: %v", ctx.Response.Body(), err)
+	}
+	if mortyURL := result.URLs[0].MortyURL; !strings.HasSuffix(mortyURL, "#a%20b%3Cc") {
+		t.Errorf("Expected an escaped fragment, got %q", mortyURL)
+	}
+
+	ctx.Response.Reset()
+	ctx.Request.SetBodyString(`{"urls":`)
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Errorf("Expected 400 for an invalid body, got %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestSignAPI(t *testing.T) {
+	p := New(WithKey([]byte("key")), WithAdminToken([]byte("secret"), TelemetryHostsFull))
+	expires := time.Now().Add(time.Hour).Unix()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://morty.local" + SignPath)
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.Set("Authorization", "Bearer secret")
+	ctx.Request.SetBodyString(fmt.Sprintf(`{"urls":["https://example.com/a.png","javascript:x"],"expires":%d}`, expires))
+	p.RequestHandler(ctx)
+	var result []string
+	if err := json.Unmarshal(ctx.Response.Body(), &result); err != nil {
+		t.Fatalf("Invalid response %q: %v", ctx.Response.Body(), err)
+	}
+	if len(result) != 2 || result[0] == "" || result[1] != "" {
+		t.Fatalf("Unexpected result %q", result)
+	}
+
+	mortyURL, _ := url.Parse(result[0])
+	query := mortyURL.Query()
+	message := sanitize.ExpiringURI(query.Get("mortyurl"), expires)
+	if query.Get("mortyexpires") != strconv.FormatInt(expires, 10) ||
+		!sanitize.VerifyURI([]byte(message), []byte(query.Get("mortyhash")), p.Key) {
+		t.Errorf("Invalid expiring URL %q", result[0])
+	}
+
+	// the expiry is signed
+	ctx = &fasthttp.RequestCtx{}
+	query.Set("mortyexpires", strconv.FormatInt(expires+1, 10))
+	ctx.Request.SetRequestURI("/?" + query.Encode())
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 403 {
+		t.Errorf("Expected 403 for a modified expiry, got %d", ctx.Response.StatusCode())
+	}
+
+	expired := &sanitize.Sanitizer{Key: p.Key, Expires: time.Now().Add(-time.Hour).Unix()}
+	u, _ := url.Parse("https://example.com/")
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(strings.TrimPrefix(expired.ProxifiedURL(u, ""), "."))
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 410 {
+		t.Errorf("Expected 410 for an expired URL, got %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestProxifyAPIMounted(t *testing.T) {
+	p := New(WithKey([]byte("key")), WithAdminToken([]byte("secret"), TelemetryHostsFull))
+	mux := http.NewServeMux()
+	mux.Handle("/morty/", http.StripPrefix("/morty", p))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/morty"+ProxifyPath, strings.NewReader(`{"urls":["https://example.com/"]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result ProxifyResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil || len(result.URLs) != 1 {
+		t.Fatalf("Unexpected result %+v: %v", result, err)
+	}
+	if mortyURL := result.URLs[0].MortyURL; !strings.HasPrefix(mortyURL, server.URL+"/morty/?") {
+		t.Fatalf("Expected a morty URL under %s/morty/, got %q", server.URL, mortyURL)
+	}
+
+	resp, err = http.Get(result.URLs[0].MortyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 404 || resp.StatusCode == 403 {
+		t.Errorf("Expected the morty URL to be served, got %d", resp.StatusCode)
+	}
+}
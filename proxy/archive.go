@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+// policies of the pages whose origin is dead: a link to the archived copy is offered on the error page,
+// or the archived copy is fetched right away
+const (
+	ArchiveFallbackOffer = "offer"
+	ArchiveFallbackFetch = "fetch"
+)
+
+// isDeadOriginStatus reports whether an error status means that the page is gone or its origin is down
+func isDeadOriginStatus(statusCode int) bool {
+	return statusCode == 404 || statusCode == 410 || statusCode == 504
+}
+
+// archiveURL returns the URL of the archived copy of a page, nil if there is no archive service or if the page
+// is already an archived copy: the archive URL template contains %s, replaced by the URL of the page.
+// The URL is query escaped when %s is in the query of the template.
+func (p *Proxy) archiveURL(ctx *fasthttp.RequestCtx, u *url.URL) *url.URL {
+	if p.ArchiveURL == "" || ctx.UserValue("mortyarchive") != nil {
+		return nil
+	}
+	pageURL := u.String()
+	if query := strings.IndexByte(p.ArchiveURL, '?'); query != -1 && query < strings.Index(p.ArchiveURL, "%s") {
+		pageURL = url.QueryEscape(pageURL)
+	}
+	archived, err := url.Parse(strings.Replace(p.ArchiveURL, "%s", pageURL, 1))
+	if err != nil {
+		return nil
+	}
+	return archived
+}
+
+// archiveOffer returns the morty URL of the archived copy of the requested page, for the error pages
+func (p *Proxy) archiveOffer(ctx *fasthttp.RequestCtx, statusCode int) string {
+	requestURI, ok := ctx.UserValue("mortyurl").(string)
+	if !ok || !isDeadOriginStatus(statusCode) || p.ArchiveFallback != ArchiveFallbackOffer {
+		return ""
+	}
+	u, err := url.Parse(requestURI)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	archived := p.archiveURL(ctx, u)
+	if archived == nil {
+		return ""
+	}
+	s := &sanitize.Sanitizer{Key: p.Key, TextOnlyParam: ctx.UserValue("mortytext") != nil}
+	return s.ProxifiedURL(archived, "")
+}
+
+// fetchArchivedCopy serves the sanitized archived copy of a page whose origin is dead, labeled in the injected
+// header. It returns false if the archived copy is not fetched automatically.
+func (p *Proxy) fetchArchivedCopy(ctx *fasthttp.RequestCtx, u *url.URL, statusCode int, redirectCount int) bool {
+	if p.ArchiveFallback != ArchiveFallbackFetch || !isDeadOriginStatus(statusCode) || !ctx.IsGet() {
+		return false
+	}
+	archived := p.archiveURL(ctx, u)
+	if archived == nil {
+		return false
+	}
+	p.Logger.Debug("fetch archived copy", "url", u.String(), "archive", archived.String(), "status", statusCode)
+	ctx.SetUserValue("mortyarchive", u.String())
+	// the archive service is counted as a redirect, the redirects to its snapshots are followed
+	p.ProcessUri(ctx, archived.String(), redirectCount+1)
+	return true
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestArchiveFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gone":
+			w.WriteHeader(410)
+		case strings.HasPrefix(r.URL.Path, "/web/"):
+			// the archive service redirects to its snapshot
+			http.Redirect(w, r, "/snapshot", http.StatusFound)
+		case r.URL.Path == "/snapshot":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, `<html><body><p>archived content</p></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	archiveURL := server.URL + "/web/%s"
+	pageURL := server.URL + "/gone"
+
+	for _, test := range []struct {
+		fallback string
+		status   int
+		body     []string
+	}{
+		{ArchiveFallbackOffer, 410, []string{Catalog["en"].ArchiveOffer, "mortyurl=" + url.QueryEscape(server.URL+"/web/"+pageURL)}},
+		{ArchiveFallbackFetch, 200, []string{"archived content", Catalog["en"].Archived + " " + pageURL}},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(pageURL))
+		New(func(p *Proxy) {
+			p.ArchiveURL = archiveURL
+			p.ArchiveFallback = test.fallback
+		}).RequestHandler(ctx)
+		body := string(ctx.Response.Body())
+		if ctx.Response.StatusCode() != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.fallback, test.status, ctx.Response.StatusCode())
+		}
+		for _, expected := range test.body {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: expected %q in %s", test.fallback, expected, body)
+			}
+		}
+	}
+
+	// the archived copy of an archived copy is never requested
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+"/missing"))
+	New(func(p *Proxy) {
+		p.ArchiveURL = server.URL + "/gone?%s"
+		p.ArchiveFallback = ArchiveFallbackFetch
+	}).RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 410 {
+		t.Errorf("Expected the 410 status of the archive, got %d", ctx.Response.StatusCode())
+	}
+
+	// the archive fetch counts in the redirect limit
+	ctx = &fasthttp.RequestCtx{}
+	New(func(p *Proxy) {
+		p.ArchiveURL = archiveURL
+		p.ArchiveFallback = ArchiveFallbackFetch
+	}).ProcessUri(ctx, pageURL, MaxRedirectCount)
+	if ctx.Response.StatusCode() != 310 {
+		t.Errorf("Expected the 310 status of too many redirects, got %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestArchiveURL(t *testing.T) {
+	u, _ := url.Parse("https://example.com/a?b=1&c=2")
+	for _, test := range []struct {
+		template string
+		expected string
+	}{
+		{"https://web.archive.org/web/%s", "https://web.archive.org/web/https://example.com/a?b=1&c=2"},
+		{"https://archive.example/lookup?url=%s&format=html", "https://archive.example/lookup?url=https%3A%2F%2Fexample.com%2Fa%3Fb%3D1%26c%3D2&format=html"},
+	} {
+		p := New(func(p *Proxy) { p.ArchiveURL = test.template })
+		if archived := p.archiveURL(&fasthttp.RequestCtx{}, u); archived == nil || archived.String() != test.expected {
+			t.Errorf("%s: expected %s, got %v", test.template, test.expected, archived)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friedemannsommer/morty/logging"
+)
+
+// interval between the checks of the modification times of the blocklist files
+const BlocklistReloadInterval = time.Minute
+
+var blocklistHostRegexp = regexp.MustCompile(`^[a-z0-9_-]+(\.[a-z0-9_-]+)+$`)
+
+// Blocklist is the set of hosts of the ad and tracker blocklists, their subdomains are blocked too.
+// It is reloaded by Watch when the files change and can be used by several goroutines.
+type Blocklist struct {
+	files   []string
+	mu      sync.RWMutex
+	hosts   map[string]bool
+	modTime map[string]time.Time
+	version int
+}
+
+// LoadBlocklist parses a comma separated list of blocklist files
+func LoadBlocklist(list string) (*Blocklist, error) {
+	b := &Blocklist{}
+	for _, file := range strings.Split(list, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			b.files = append(b.files, file)
+		}
+	}
+	if len(b.files) == 0 {
+		return nil, errors.New("no blocklist")
+	}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload reads the files again, the previous hosts are kept if a file can't be read
+func (b *Blocklist) Reload() error {
+	hosts := make(map[string]bool)
+	modTime := make(map[string]time.Time)
+	for _, file := range b.files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			modTime[file] = info.ModTime()
+			err = parseBlocklist(f, hosts)
+		}
+		f.Close()
+		if err != nil {
+			return errors.New(file + ": " + err.Error())
+		}
+	}
+	b.mu.Lock()
+	b.hosts, b.modTime = hosts, modTime
+	b.version++
+	b.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the blocklist when a file is modified, until done is closed. The reloads are logged to logger.
+func (b *Blocklist) Watch(interval time.Duration, done <-chan struct{}, logger *logging.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if !b.isModified() {
+			continue
+		}
+		if err := b.Reload(); err != nil {
+			logger.Warn("failed to reload the blocklist", "error", err)
+			continue
+		}
+		logger.Info("blocklist reloaded", "hosts", b.Len())
+	}
+}
+
+// isModified reports whether the modification time of a file has changed since the last reload
+func (b *Blocklist) isModified() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, file := range b.files {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(b.modTime[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks reports whether hostname or one of its parent domains is blocked, a nil Blocklist blocks nothing
+func (b *Blocklist) Blocks(hostname string) bool {
+	if b == nil {
+		return false
+	}
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for hostname != "" {
+		if b.hosts[hostname] {
+			return true
+		}
+		i := strings.IndexByte(hostname, '.')
+		if i == -1 {
+			break
+		}
+		hostname = hostname[i+1:]
+	}
+	return false
+}
+
+// Len returns the number of blocked hosts
+func (b *Blocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.hosts)
+}
+
+// Version identifies the loaded hosts, it changes on each reload. It is empty for a nil Blocklist.
+func (b *Blocklist) Version() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return strconv.Itoa(b.version)
+}
+
+// parseBlocklist adds the hosts of a blocklist to hosts. The lines can be in the hosts format
+// ("0.0.0.0 ads.example.com"), a domain list ("ads.example.com") or the EasyList host rules
+// ("||ads.example.com^", the options are ignored). Comments, exceptions and other rules are skipped.
+func parseBlocklist(r io.Reader, hosts map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		if strings.HasPrefix(line, "||") {
+			rule := line[2:]
+			if i := strings.IndexByte(rule, '$'); i != -1 {
+				rule = rule[:i]
+			}
+			if strings.HasSuffix(rule, "^") {
+				addBlockedHost(hosts, strings.TrimSuffix(rule, "^"))
+			}
+			continue
+		}
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			if i > 0 && line[i-1] != ' ' && line[i-1] != '\t' {
+				// element hiding rule (example.com##.ad)
+				continue
+			}
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1:
+			addBlockedHost(hosts, fields[0])
+		case len(fields) > 1 && net.ParseIP(fields[0]) != nil:
+			for _, host := range fields[1:] {
+				addBlockedHost(hosts, host)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// addBlockedHost adds a host name to hosts, the invalid host names, the IP addresses and localhost are skipped
+func addBlockedHost(hosts map[string]bool, host string) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if blocklistHostRegexp.MatchString(host) && net.ParseIP(host) == nil && host != "localhost.localdomain" {
+		hosts[host] = true
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friedemannsommer/morty/logging"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	list := `# hosts format
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.net # comment
+::1 ip6-localhost
+! EasyList
+[Adblock Plus 2.0]
+||Banners.Example.org^
+||metrics.example.io^$third-party
+||example.com/ads/*
+@@||allowed.example.com^
+example.com##.ad
+plain.example.de.
+`
+	hosts := make(map[string]bool)
+	if err := parseBlocklist(strings.NewReader(list), hosts); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ads.example.com", "tracker.example.net", "banners.example.org", "metrics.example.io", "plain.example.de"}
+	if len(hosts) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+	for _, host := range expected {
+		if !hosts[host] {
+			t.Errorf("%s should be blocked, got %v", host, hosts)
+		}
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts.txt")
+	if err := os.WriteFile(file, []byte("0.0.0.0 ads.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBlocklist(" " + file + " ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		hostname string
+		blocked  bool
+	}{
+		{"ads.example.com", true},
+		{"CDN.Ads.Example.com.", true},
+		{"example.com", false},
+		{"badads.example.com", false},
+	} {
+		if b.Blocks(test.hostname) != test.blocked {
+			t.Errorf("%s: expected %v", test.hostname, test.blocked)
+		}
+	}
+
+	version := b.Version()
+	if err := os.WriteFile(file, []byte("||tracker.example.net^\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go b.Watch(time.Millisecond, done, logging.New(io.Discard, logging.LevelInfo, logging.FormatLogfmt))
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Version() == version && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	if b.Blocks("ads.example.com") || !b.Blocks("tracker.example.net") {
+		t.Error("the modified blocklist should be reloaded")
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err == nil || !b.Blocks("tracker.example.net") {
+		t.Error("the hosts should be kept if a file can't be read")
+	}
+	if _, err := LoadBlocklist(file); err == nil {
+		t.Error("a missing file should be rejected")
+	}
+	var nilBlocklist *Blocklist
+	if nilBlocklist.Blocks("ads.example.com") || nilBlocklist.Version() != "" {
+		t.Error("a nil blocklist should block nothing")
+	}
+}
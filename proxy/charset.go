@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// size of the beginning of the documents examined to determine their encoding, like the browsers
+const charsetPrefixSize = 1024
+
+var byteOrderMarks = []struct {
+	bom   []byte
+	label string
+}{
+	{[]byte{0xef, 0xbb, 0xbf}, "utf-8"},
+	{[]byte{0xfe, 0xff}, "utf-16be"},
+	{[]byte{0xff, 0xfe}, "utf-16le"},
+}
+
+var metaCharsetRegexp = regexp.MustCompile(`(?i)<meta\s[^>]*charset\s*=\s*["']?\s*([A-Za-z0-9._:\-]+)`)
+
+// decodeDocument converts a text document to UTF-8, the byte order mark is removed
+func (p *Proxy) decodeDocument(body []byte, contentType string) ([]byte, error) {
+	e, name, bomLength := p.documentEncoding(body, contentType)
+	body = body[bomLength:]
+	if name == "utf-8" {
+		return body, nil
+	}
+	return e.NewDecoder().Bytes(body)
+}
+
+// newDocumentReader converts a streamed text document to UTF-8 like decodeDocument, the encoding is determined from
+// the beginning of the document: the buffer of body must hold charsetPrefixSize bytes
+func (p *Proxy) newDocumentReader(body *bufio.Reader, contentType string) io.Reader {
+	// the error is reported by the next read
+	prefix, _ := body.Peek(charsetPrefixSize)
+	e, name, bomLength := p.documentEncoding(prefix, contentType)
+	_, _ = body.Discard(bomLength)
+	if name == "utf-8" {
+		return body
+	}
+	return e.NewDecoder().Reader(body)
+}
+
+// documentEncoding determines the encoding of a text document from the beginning of the document and its
+// Content-Type header, it returns the length of the byte order mark of the document.
+// A byte order mark overrides the charsets of the header and of the document. The charset of the header is often a
+// default of the server: the charset declared by the document is preferred when the header declares windows-1252,
+// the default charset of HTTP/1.1, or when the document can't be decoded with the charset of the header.
+func (p *Proxy) documentEncoding(body []byte, contentType string) (encoding.Encoding, string, int) {
+	prefix := body
+	if len(prefix) > charsetPrefixSize {
+		prefix = prefix[:charsetPrefixSize]
+	}
+	for _, b := range byteOrderMarks {
+		if bytes.HasPrefix(prefix, b.bom) {
+			e, name := charset.Lookup(b.label)
+			return e, name, len(b.bom)
+		}
+	}
+	e, name, certain := charset.DetermineEncoding(prefix, contentType)
+	if !certain {
+		return e, name, 0
+	}
+	metaEncoding, metaName := charset.Lookup(metaCharset(prefix))
+	if metaEncoding == nil || metaName == name {
+		return e, name, 0
+	}
+	if name == "windows-1252" || replacementCount(e, body) > replacementCount(metaEncoding, body) {
+		p.Logger.Debug("charset of the document used", "header", name, "document", metaName)
+		return metaEncoding, metaName, 0
+	}
+	return e, name, 0
+}
+
+// metaCharset returns the charset declared by a <meta> element of a HTML document, if any
+func metaCharset(prefix []byte) string {
+	m := metaCharsetRegexp.FindSubmatch(prefix)
+	if m == nil {
+		return ""
+	}
+	label := strings.ToLower(string(m[1]))
+	// a document without byte order mark is not encoded in UTF-16, see the HTML prescan algorithm
+	if strings.HasPrefix(label, "utf-16") {
+		return "utf-8"
+	}
+	return label
+}
+
+// replacementCount returns the number of characters of the body which can't be decoded with the encoding
+func replacementCount(e encoding.Encoding, body []byte) int {
+	decoded, err := e.NewDecoder().Bytes(body)
+	if err != nil {
+		return len(body)
+	}
+	return bytes.Count(decoded, []byte("\uFFFD"))
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encodeString(e encoding.Encoding, s string) []byte {
+	encoded, err := e.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+func TestDecodeDocument(t *testing.T) {
+	const japaneseDoc = `<html><head><meta charset="Shift_JIS"></head><body>日本語</body></html>`
+	const cyrillicDoc = `<html><head><meta http-equiv="Content-Type" content="text/html; charset=windows-1251"></head><body>Привет</body></html>`
+	for _, test := range []struct {
+		name        string
+		body        []byte
+		contentType string
+		expected    string
+	}{
+		{"meta", encodeString(japanese.ShiftJIS, japaneseDoc), "text/html", japaneseDoc},
+		{"invalid header charset", encodeString(japanese.ShiftJIS, japaneseDoc), "text/html; charset=utf-8", japaneseDoc},
+		{"default header charset", encodeString(charmap.Windows1251, cyrillicDoc), "text/html; charset=ISO-8859-1", cyrillicDoc},
+		{"header charset", encodeString(charmap.KOI8R, "<meta charset=windows-1251>Привет"), "text/html; charset=koi8-r", "<meta charset=windows-1251>Привет"},
+		{"utf-8 bom", append([]byte{0xef, 0xbb, 0xbf}, "<p>é</p>"...), "text/html; charset=windows-1251", "<p>é</p>"},
+		{"utf-16 bom", encodeString(unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), "<p>é</p>"), "text/html", "<p>é</p>"},
+		{"utf-8", []byte("<p>é</p>"), "text/plain", "<p>é</p>"},
+	} {
+		decoded, err := New().decodeDocument(test.body, test.contentType)
+		if err != nil || string(decoded) != test.expected {
+			t.Errorf("%s: expected %q, got %q %v", test.name, test.expected, decoded, err)
+		}
+	}
+}
+
+func TestCharsetPrefix(t *testing.T) {
+	body := make([]byte, 0, 2*charsetPrefixSize)
+	for len(body) < charsetPrefixSize {
+		body = append(body, "<!-- padding -->"...)
+	}
+	body = append(body, `<meta charset="windows-1251">`...)
+	if _, name, _ := New().documentEncoding(body, "text/html"); name == "windows-1251" {
+		t.Error("a charset declared after the prefix should be ignored")
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/valyala/fasthttp"
+)
+
+// content encodings accepted from the upstream servers
+const AcceptedContentEncodings = "gzip, deflate, br"
+
+// decodeResponseBody decompresses body according to the Content-Encoding header of the response.
+// The decompressed body is limited to limit bytes, fasthttp.ErrBodyTooLarge is returned otherwise.
+func decodeResponseBody(body []byte, contentEncoding []byte, limit int) ([]byte, error) {
+	encodings := bytes.Split(contentEncoding, []byte(","))
+	// the encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		switch encoding := string(bytes.ToLower(bytes.TrimSpace(encodings[i]))); encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// "deflate" is zlib, but some servers send raw deflate
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err = ioutil.ReadAll(io.LimitReader(reader, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > limit {
+			return nil, fasthttp.ErrBodyTooLarge
+		}
+	}
+	return body, nil
+}
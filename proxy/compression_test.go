@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/valyala/fasthttp"
+)
+
+func compress(w io.WriteCloser, out *bytes.Buffer, data string) []byte {
+	_, _ = io.WriteString(w, data)
+	_ = w.Close()
+	return out.Bytes()
+}
+
+func TestDecodeResponseBody(t *testing.T) {
+	data := "<html><body>Morty</body></html>"
+	var gzipBuf, zlibBuf, flateBuf, brotliBuf, twiceBuf bytes.Buffer
+	flateWriter, _ := flate.NewWriter(&flateBuf, flate.DefaultCompression)
+	gzipped := compress(gzip.NewWriter(&gzipBuf), &gzipBuf, data)
+
+	testCases := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(data)},
+		{"identity", []byte(data)},
+		{"gzip", gzipped},
+		{"deflate", compress(zlib.NewWriter(&zlibBuf), &zlibBuf, data)},
+		{"deflate", compress(flateWriter, &flateBuf, data)},
+		{"br", compress(brotli.NewWriter(&brotliBuf), &brotliBuf, data)},
+		{"gzip, BR", compress(brotli.NewWriter(&twiceBuf), &twiceBuf, string(gzipped))},
+	}
+	for _, tc := range testCases {
+		res, err := decodeResponseBody(tc.body, []byte(tc.encoding), 1024)
+		if err != nil || string(res) != data {
+			t.Errorf("Decompression error (%q). Expected %q, got %q (%v)", tc.encoding, data, res, err)
+		}
+	}
+
+	if _, err := decodeResponseBody(gzipped, []byte("gzip"), 10); err != fasthttp.ErrBodyTooLarge {
+		t.Errorf("Decompression error. Expected a size limit error, got %v", err)
+	}
+	if _, err := decodeResponseBody([]byte(data), []byte("compress"), 1024); err == nil {
+		t.Errorf("Decompression error. Expected an unsupported encoding error")
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// policies of the EventSource requests, the WebSocket requests are always rejected
+const (
+	EventStreamsBlock = "block"
+	EventStreamsRelay = "relay"
+)
+
+// isWebSocketRequest reports whether the request asks to upgrade the connection to the WebSocket protocol
+func isWebSocketRequest(ctx *fasthttp.RequestCtx) bool {
+	for _, protocol := range bytes.Split(ctx.Request.Header.Peek("Upgrade"), []byte(",")) {
+		if bytes.EqualFold(bytes.TrimSpace(protocol), []byte("websocket")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEventStreamRequest reports whether the request is sent by an EventSource, which only accepts text/event-stream
+func isEventStreamRequest(ctx *fasthttp.RequestCtx) bool {
+	return bytes.Contains(bytes.ToLower(ctx.Request.Header.Peek("Accept")), []byte("text/event-stream"))
+}
+
+// serveStreamRejection rejects a WebSocket or EventSource request with a plain text message:
+// these requests are sent by scripts, nobody sees an error page
+func (p *Proxy) serveStreamRejection(ctx *fasthttp.RequestCtx, message string) {
+	p.Logger.Debug("stream request rejected", "url", ctx.UserValue("mortyurl"), "reason", message)
+	// HTTP status code 501 : Not Implemented, the EventSource clients don't reconnect after it
+	ctx.SetStatusCode(501)
+	ctx.SetContentType("text/plain; charset=UTF-8")
+	ctx.SetBodyString(message + "\n")
+}
+
+// relayEventStream sends an upstream event stream to the client as it is received,
+// the stream is closed after timeout so a connection is not held forever
+func (p *Proxy) relayEventStream(ctx *fasthttp.RequestCtx, statusCode int, body *streamBody, timeout time.Duration) {
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("text/event-stream; charset=UTF-8")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	// nginx buffers the responses unless it is told not to
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		timer := time.AfterFunc(timeout, body.cancel)
+		defer timer.Stop()
+		defer body.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil || w.Flush() != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					p.Logger.Debug("event stream closed", "error", err)
+				}
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestStreamRequests(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		// the stream never ends, it is closed by the event stream timeout
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	for _, header := range [][2]string{{"Upgrade", "websocket"}, {"Accept", "text/event-stream"}} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=" + server.URL)
+		ctx.Request.Header.Set(header[0], header[1])
+		New().RequestHandler(ctx)
+		if ctx.Response.StatusCode() != 501 || !strings.HasPrefix(string(ctx.Response.Header.ContentType()), "text/plain") || requested {
+			t.Errorf("%s: expected a 501 response without upstream request, got %d %s", header[0], ctx.Response.StatusCode(), ctx.Response.Header.ContentType())
+		}
+	}
+
+	p := New(func(p *Proxy) {
+		p.EventStreams = EventStreamsRelay
+		p.EventStreamTimeout = 100 * time.Millisecond
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/?mortyurl=" + server.URL)
+	ctx.Request.Header.Set("Accept", "text/event-stream")
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 200 || string(ctx.Response.Body()) != "data: 1\n\n" ||
+		!strings.HasPrefix(string(ctx.Response.Header.ContentType()), "text/event-stream") {
+		t.Errorf("Expected a relayed event stream, got %d %s: %q", ctx.Response.StatusCode(), ctx.Response.Header.ContentType(), ctx.Response.Body())
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/friedemannsommer/morty/logging"
+	"github.com/valyala/fasthttp"
+)
+
+// time during which an unreachable proxy is skipped
+const ProxyFailoverCooldown = 30 * time.Second
+
+type failoverProxy struct {
+	name string
+	dial fasthttp.DialFunc
+	// UnixNano time until which the proxy is considered unreachable, accessed atomically
+	downUntil int64
+}
+
+// FailoverDialer dials through the first reachable proxy of a list. An unreachable proxy is skipped
+// during ProxyFailoverCooldown, unless all the proxies are unreachable.
+type FailoverDialer struct {
+	proxies []*failoverProxy
+	now     func() time.Time
+	logger  *logging.Logger
+}
+
+// NewFailoverDialer parses a comma separated list of "http://[user:pass@]hostname:port" and
+// "socks5://hostname:port" proxies, the unreachable proxies are logged to logger
+func NewFailoverDialer(list string, logger *logging.Logger) (*FailoverDialer, error) {
+	d := &FailoverDialer{now: time.Now, logger: logger}
+	for _, proxy := range strings.Split(list, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		dial, err := proxyDialer(proxy)
+		if err != nil {
+			return nil, err
+		}
+		// the credentials are not logged
+		name := proxy
+		if at := strings.LastIndexByte(name, '@'); at != -1 {
+			name = name[:strings.Index(name, "://")+3] + name[at+1:]
+		}
+		d.proxies = append(d.proxies, &failoverProxy{name: name, dial: dial})
+	}
+	if len(d.proxies) == 0 {
+		return nil, errors.New("no proxy")
+	}
+	return d, nil
+}
+
+// Len returns the number of proxies
+func (d *FailoverDialer) Len() int {
+	return len(d.proxies)
+}
+
+func (d *FailoverDialer) Dial(addr string) (net.Conn, error) {
+	var err error
+	// the healthy proxies first, then the unreachable ones in case they are back
+	for _, healthy := range []bool{true, false} {
+		for _, proxy := range d.proxies {
+			if (atomic.LoadInt64(&proxy.downUntil) <= d.now().UnixNano()) != healthy {
+				continue
+			}
+			var conn net.Conn
+			conn, err = proxy.dial(addr)
+			if err == nil {
+				atomic.StoreInt64(&proxy.downUntil, 0)
+				return conn, nil
+			}
+			if !isProxyUnreachable(err) {
+				// the proxy works, the upstream server is unreachable
+				return nil, err
+			}
+			if healthy {
+				d.logger.Warn("proxy unreachable", "proxy", proxy.name, "error", err)
+			}
+			atomic.StoreInt64(&proxy.downUntil, d.now().Add(ProxyFailoverCooldown).UnixNano())
+		}
+	}
+	return nil, err
+}
+
+// isProxyUnreachable reports whether a dial error is the connection error to the proxy itself,
+// the SOCKS5 dialer wraps it in the error of the CONNECT command. The upstream host names are
+// resolved by the proxies: the resolution errors are the ones of the proxy host name.
+func isProxyUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.Is(err, fasthttp.ErrDialTimeout) || errors.As(err, &dnsErr) {
+		return true
+	}
+	for err != nil {
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) {
+			return false
+		}
+		if opErr.Op == "dial" {
+			return true
+		}
+		err = opErr.Err
+	}
+	return false
+}
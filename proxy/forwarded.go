@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// user value of the path prefix removed before the request is handled, see ServeHTTP
+const mountPrefixKey = "mortymount"
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR networks
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, errors.New("invalid IP address: " + value)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isTrustedProxy reports whether the client of the request is a trusted reverse proxy
+func (p *Proxy) isTrustedProxy(ctx *fasthttp.RequestCtx) bool {
+	ip := ctx.RemoteIP()
+	for _, network := range p.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// externalURL returns the URL of the request as seen by the clients, without its query: the scheme and the host
+// are taken from the X-Forwarded-Proto and X-Forwarded-Host headers when the request comes from a trusted proxy,
+// the path starts with the path under which morty is mounted
+func (p *Proxy) externalURL(ctx *fasthttp.RequestCtx) *url.URL {
+	path := p.mountPath(ctx) + strings.TrimPrefix(string(ctx.Path()), "/")
+	u := &url.URL{Scheme: string(ctx.URI().Scheme()), Host: string(ctx.Host()), Path: path}
+	if !p.isTrustedProxy(ctx) {
+		return u
+	}
+	// the last value is added by the trusted proxy, the previous ones may be sent by the client
+	if proto := strings.ToLower(lastHeaderValue(ctx, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	if host := lastHeaderValue(ctx, "X-Forwarded-Host"); host != "" && !strings.ContainsAny(host, "/?#@\\ ") {
+		u.Host = host
+	}
+	return u
+}
+
+// mountPath returns the path under which morty is mounted, ending with a slash: the X-Forwarded-Prefix header of a
+// trusted proxy followed by the prefix removed by http.StripPrefix before ServeHTTP
+func (p *Proxy) mountPath(ctx *fasthttp.RequestCtx) string {
+	prefix, _ := ctx.UserValue(mountPrefixKey).(string)
+	if p.isTrustedProxy(ctx) {
+		forwarded := lastHeaderValue(ctx, "X-Forwarded-Prefix")
+		if strings.HasPrefix(forwarded, "/") && !strings.HasPrefix(forwarded, "//") && !strings.ContainsAny(forwarded, "?#\\ ") {
+			prefix = strings.TrimSuffix(forwarded, "/") + prefix
+		}
+	}
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// lastHeaderValue returns the last value of a comma separated request header, which may be sent several times
+func lastHeaderValue(ctx *fasthttp.RequestCtx, name string) string {
+	var value string
+	ctx.Request.Header.VisitAll(func(key, headerValue []byte) {
+		if strings.EqualFold(string(key), name) {
+			value = string(headerValue)
+		}
+	})
+	if i := strings.LastIndexByte(value, ','); i != -1 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+// setLocation sets the Location header to a morty URL, made absolute with the external URL of the request
+func (p *Proxy) setLocation(ctx *fasthttp.RequestCtx, location string) {
+	base := p.externalURL(ctx)
+	if ref, err := url.Parse(location); err == nil && base.Host != "" && !strings.HasPrefix(location, "#") {
+		location = base.ResolveReference(ref).String()
+	}
+	ctx.Response.Header.Set("Location", location)
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestExternalURL(t *testing.T) {
+	if _, err := ParseTrustedProxies("127.0.0.1,10.0.0.0/33"); err == nil {
+		t.Error("invalid network should be rejected")
+	}
+	networks, err := ParseTrustedProxies(" 127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(func(p *Proxy) { p.TrustedProxies = networks })
+	for _, test := range []struct {
+		remoteIP string
+		proto    string
+		host     string
+		prefix   string
+		expected string
+	}{
+		{"127.0.0.1", "https", "morty.example", "", "https://morty.example/feed"},
+		{"10.1.2.3", "http, HTTPS", "", "", "https://localhost/feed"},
+		{"10.1.2.3", "https, http", "evil.example, morty.example", "", "http://morty.example/feed"},
+		{"192.168.1.1", "https", "morty.example", "/morty", "http://localhost/feed"},
+		{"127.0.0.1", "ftp", "morty.example/x", "", "http://localhost/feed"},
+		{"127.0.0.1", "https", "morty.example", "/evil, /morty/", "https://morty.example/morty/feed"},
+		{"127.0.0.1", "https", "morty.example", "//evil.example", "https://morty.example/feed"},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(test.remoteIP)}, nil)
+		ctx.Request.SetRequestURI("http://localhost/feed?mortyurl=x")
+		ctx.Request.Header.Set("X-Forwarded-Proto", test.proto)
+		if test.host != "" {
+			ctx.Request.Header.Set("X-Forwarded-Host", test.host)
+		}
+		if test.prefix != "" {
+			ctx.Request.Header.Set("X-Forwarded-Prefix", test.prefix)
+		}
+		if u := p.externalURL(ctx).String(); u != test.expected {
+			t.Errorf("%s %s %s %s: expected %s, got %s", test.remoteIP, test.proto, test.host, test.prefix, test.expected, u)
+		}
+	}
+}
+
+func TestForwardedLocation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/next")
+		w.WriteHeader(303)
+	}))
+	defer server.Close()
+
+	networks, _ := ParseTrustedProxies("127.0.0.1")
+	p := New(func(p *Proxy) { p.TrustedProxies = networks })
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, nil)
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("http://localhost/")
+	ctx.Request.Header.Add("X-Forwarded-Proto", "http")
+	ctx.Request.Header.Add("X-Forwarded-Proto", "https")
+	ctx.Request.Header.Set("X-Forwarded-Host", "morty.example")
+	p.ProcessUri(ctx, server.URL+"/form", 0)
+	location := string(ctx.Response.Header.Peek("Location"))
+	if !strings.HasPrefix(location, "https://morty.example/?mortyurl=") {
+		t.Errorf("expected an absolute Location on https://morty.example/, got %s", location)
+	}
+}
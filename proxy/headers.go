@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/friedemannsommer/morty/contenttype"
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+// content types revalidated by the browsers: their validators are relayed
+var ConditionalContentTypeFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	contenttype.NewFilterEquals("text", "css", ""),
+	contenttype.NewFilterEquals("image", "*", ""),
+	contenttype.NewFilterEquals("font", "*", ""),
+	contenttype.NewFilterEquals("application", "font-otf", ""),
+	contenttype.NewFilterEquals("application", "font-ttf", ""),
+	contenttype.NewFilterEquals("application", "font-woff", ""),
+	contenttype.NewFilterEquals("application", "vnd.ms-fontobject", ""),
+})
+
+// response headers which can be relayed for the content types of ConditionalContentTypeFilter
+var SupportedCacheHeaders = []string{
+	"Age",
+	"Cache-Control",
+	"Content-Language",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+}
+
+// response headers relayed by default by relayCacheHeaders, see the -cacheheaders flag
+var DefaultCacheHeaders = []string{
+	"Cache-Control",
+	"Expires",
+	"Last-Modified",
+	"Content-Language",
+}
+
+// prefix of the ETag of a sanitized stylesheet, see relayValidators
+var sanitizedETagPrefix = []byte(`W/"morty-`)
+
+// request headers which are sent to upstream servers in strict mode (lower case)
+var AllowedRequestHeaders = map[string]bool{
+	"accept":            true,
+	"accept-encoding":   true,
+	"connection":        true,
+	"content-length":    true,
+	"content-type":      true,
+	"host":              true,
+	"if-modified-since": true,
+	"if-none-match":     true,
+	"if-range":          true,
+	"range":             true,
+	"referer":           true,
+	"user-agent":        true,
+}
+
+const (
+	RefererPolicyNone   = "none"
+	RefererPolicyOrigin = "origin"
+	RefererPolicyFull   = "full"
+)
+
+// request headers which are never sent to upstream servers
+var SensitiveRequestHeaders = map[string]bool{
+	"authorization":       true,
+	"cookie":              true,
+	"proxy-authorization": true,
+}
+
+// Accept header sent when the client request has none, the one of the usual browsers
+const DefaultAcceptHeader = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+// maximum number of media ranges forwarded by forwardAcceptHeader
+const MaxAcceptMediaRanges = 16
+
+var acceptMediaRangeRegexp = regexp.MustCompile(`^(\*/\*|[a-z0-9][a-z0-9!#$&^_.+-]*/(\*|[a-z0-9][a-z0-9!#$&^_.+-]*))$`)
+
+var acceptQualityRegexp = regexp.MustCompile(`^q=(0(\.[0-9]{1,3})?|1(\.0{1,3})?)$`)
+
+// forwardAcceptHeader copies the media ranges of the client Accept header to the upstream request, so the
+// servers doing content negotiation send the expected representation. The parameters other than the quality
+// and the invalid media ranges are removed.
+func forwardAcceptHeader(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	var mediaRanges []string
+	for _, mediaRange := range strings.Split(string(ctx.Request.Header.Peek("Accept")), ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if !acceptMediaRangeRegexp.MatchString(mediaType) {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.ToLower(strings.TrimSpace(param))
+			if acceptQualityRegexp.MatchString(param) {
+				mediaType += ";" + param
+				break
+			}
+		}
+		mediaRanges = append(mediaRanges, mediaType)
+		if len(mediaRanges) == MaxAcceptMediaRanges {
+			break
+		}
+	}
+	if len(mediaRanges) == 0 {
+		req.Header.Set("Accept", DefaultAcceptHeader)
+		return
+	}
+	req.Header.Set("Accept", strings.Join(mediaRanges, ","))
+}
+
+// forwardReferer sends the URL of the proxified page which requested a resource as Referer, or its origin.
+// The sanitized pages send their morty URL as Referer to morty if the policy is not RefererPolicyNone.
+func forwardReferer(ctx *fasthttp.RequestCtx, req *fasthttp.Request, policy string, key []byte) {
+	referer, err := url.Parse(string(ctx.Request.Header.Referer()))
+	if err != nil || referer.Host != string(ctx.Host()) {
+		return
+	}
+	query := referer.Query()
+	pageURI := query.Get("mortyurl")
+	if pageURI == "" || (key != nil && !sanitize.VerifyURI([]byte(pageURI), []byte(query.Get("mortyhash")), key)) {
+		return
+	}
+	pageURL, err := url.Parse(pageURI)
+	if err != nil || (pageURL.Scheme != "http" && pageURL.Scheme != "https") {
+		return
+	}
+	// like the browsers, a HTTPS page doesn't send its URL to a HTTP server
+	if pageURL.Scheme == "https" && string(req.URI().Scheme()) != "https" {
+		return
+	}
+	if policy == RefererPolicyOrigin {
+		pageURL = &url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: "/"}
+	} else {
+		pageURL.User = nil
+		pageURL.Fragment = ""
+	}
+	req.Header.Set("Referer", pageURL.String())
+}
+
+// forwardRangeHeaders copies the Range header of the client request to the upstream request.
+// Only a single byte range is forwarded: multipart/byteranges responses are not allowed.
+func forwardRangeHeaders(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	rangeHeader := ctx.Request.Header.Peek("Range")
+	if !bytes.HasPrefix(rangeHeader, []byte("bytes=")) || bytes.IndexByte(rangeHeader, ',') != -1 {
+		return
+	}
+	req.Header.SetBytesV("Range", rangeHeader)
+	if ifRange := ctx.Request.Header.Peek("If-Range"); len(ifRange) > 0 {
+		req.Header.SetBytesV("If-Range", ifRange)
+	}
+}
+
+// forwardConditionalHeaders copies the validators of the client request to the upstream request.
+// The ETags of sanitized stylesheets are unwrapped, the ones of a previous morty version are dropped.
+func forwardConditionalHeaders(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	if ifNoneMatch := ctx.Request.Header.Peek("If-None-Match"); len(ifNoneMatch) > 0 {
+		var etags [][]byte
+		for _, etag := range bytes.Split(ifNoneMatch, []byte(",")) {
+			etag = bytes.TrimSpace(etag)
+			if bytes.HasPrefix(etag, sanitizedETagPrefix) {
+				version := []byte(VERSION + "-")
+				opaque := etag[len(sanitizedETagPrefix):]
+				if !bytes.HasPrefix(opaque, version) {
+					continue
+				}
+				etag = append([]byte(`W/"`), opaque[len(version):]...)
+			}
+			etags = append(etags, etag)
+		}
+		if len(etags) > 0 {
+			req.Header.SetBytesV("If-None-Match", bytes.Join(etags, []byte(", ")))
+		}
+	}
+	if ifModifiedSince := ctx.Request.Header.Peek("If-Modified-Since"); len(ifModifiedSince) > 0 {
+		req.Header.SetBytesV("If-Modified-Since", ifModifiedSince)
+	}
+}
+
+// relayValidators copies the validators of an upstream response to the client response.
+// A sanitized stylesheet depends on the morty version: its ETag is bound to the version
+// and its modification date is dropped, so the browsers don't keep an outdated stylesheet.
+func relayValidators(ctx *fasthttp.RequestCtx, etag, lastModified []byte, sanitized bool) {
+	if sanitized {
+		if opaque := bytes.Trim(bytes.TrimPrefix(etag, []byte("W/")), `"`); len(opaque) > 0 {
+			ctx.Response.Header.Set("ETag", string(sanitizedETagPrefix)+VERSION+"-"+string(opaque)+`"`)
+		}
+		return
+	}
+	if len(etag) > 0 {
+		ctx.Response.Header.SetBytesV("ETag", etag)
+	}
+	if len(lastModified) > 0 {
+		ctx.Response.Header.SetBytesV("Last-Modified", lastModified)
+	}
+}
+
+// relayCacheHeaders copies the CacheHeaders of an upstream response to the client response, so the browsers
+// cache the static assets instead of fetching them again. The modification date of a sanitized content is
+// dropped like in relayValidators.
+func (p *Proxy) relayCacheHeaders(ctx *fasthttp.RequestCtx, peek func(name string) []byte, sanitized bool) {
+	for _, name := range p.CacheHeaders {
+		if sanitized && name == "Last-Modified" {
+			continue
+		}
+		if value := peek(name); len(value) > 0 {
+			ctx.Response.Header.SetBytesV(name, value)
+		}
+	}
+}
+
+// ParseCacheHeaders parses a comma separated list of response headers, "none" disables the relay
+func ParseCacheHeaders(list string) ([]string, error) {
+	headers := []string{}
+	if strings.TrimSpace(list) == "none" {
+		return headers, nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		supported := false
+		for _, h := range SupportedCacheHeaders {
+			if strings.EqualFold(h, name) {
+				supported = true
+				name = h
+			}
+		}
+		if !supported {
+			return nil, errors.New("unsupported header: " + name)
+		}
+		headers = append(headers, name)
+	}
+	return headers, nil
+}
+
+// scrubRequestHeaders removes the credentials from an upstream request.
+// In strict mode every header which is not explicitly allowed is removed as well,
+// including the credentials of the URI which would be sent as an Authorization header.
+// It must be called right before the request is sent.
+func scrubRequestHeaders(req *fasthttp.Request, strict bool) {
+	var names [][]byte
+	req.Header.VisitAll(func(key, _ []byte) {
+		name := bytes.ToLower(key)
+		if SensitiveRequestHeaders[string(name)] || (strict && !AllowedRequestHeaders[string(name)]) {
+			names = append(names, name)
+		}
+	})
+	for _, name := range names {
+		req.Header.DelBytes(name)
+	}
+	req.Header.DelAllCookies()
+
+	if strict {
+		req.URI().SetUsername("")
+		req.URI().SetPassword("")
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"html/template"
+	"sort"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/text/language"
+)
+
+// Messages are the strings of the injected UI and of the pages served by morty
+type Messages struct {
+	Hide         string
+	BannerStart  string
+	BannerLink   string
+	BannerEnd    string
+	OriginalSite string
+	Error        string
+	VisitURL     string
+	Go           string
+	NoDirectURL  string
+	ExitTitle    string
+	ExitFollow   string
+	ExitWarning  template.HTML
+	BlockedTitle string
+	BlockedType  string
+	BlockedOpen  string
+	Footer       string
+	ViewSource   string
+	Permalink    string
+	ShareHint    string
+	ExitQRCode   string
+	HTTPTitle    string
+	HTTPWarning  string
+	HTTPContinue string
+	Homograph    string
+	Archived     string
+	ArchiveOffer string
+	FileTitle    string
+	FileType     string
+	FileSize     string
+	FileUnknown  string
+	FileDownload string
+}
+
+// Catalog contains the messages of each supported language
+var Catalog = map[string]*Messages{
+	"en": {
+		Hide:         "hide",
+		BannerStart:  "This is a",
+		BannerLink:   "proxified and sanitized",
+		BannerEnd:    "view of the page, visit",
+		OriginalSite: "original site",
+		Error:        "Error",
+		VisitURL:     "Visit url",
+		Go:           "go",
+		NoDirectURL:  "Warning! This instance does not support direct URL opening.",
+		ExitTitle:    "You are about to exit MortyProxy",
+		ExitFollow:   "Following",
+		ExitWarning:  "the content of this URL will be <b>NOT</b> sanitized.",
+		BlockedTitle: "Blocked content",
+		BlockedType:  "Morty does not serve this type of content",
+		BlockedOpen:  "Open the original resource",
+		Footer:       "Morty rewrites web pages to exclude malicious HTML tags and CSS/HTML attributes. It also replaces external resource references to prevent third-party information leaks.",
+		ViewSource:   "view on github",
+		Permalink:    "permalink",
+		ShareHint:    "Copy this link to share the sanitized page",
+		ExitQRCode:   "QR code of the URL, to open it on another device",
+		HTTPTitle:    "Insecure redirect",
+		HTTPWarning:  "The page redirects from HTTPS to HTTP: the connection between morty and the site is not encrypted.",
+		HTTPContinue: "Continue anyway",
+		Homograph:    "Warning! The domain name mixes letters of several alphabets: it may imitate another domain.",
+		Archived:     "Archived copy of",
+		ArchiveOffer: "View an archived copy of the page",
+		FileTitle:    "File download",
+		FileType:     "Type",
+		FileSize:     "Size",
+		FileUnknown:  "unknown",
+		FileDownload: "Download the file",
+	},
+	"de": {
+		Hide:         "ausblenden",
+		BannerStart:  "Dies ist eine",
+		BannerLink:   "weitergeleitete und bereinigte",
+		BannerEnd:    "Ansicht der Seite, besuche die",
+		OriginalSite: "Originalseite",
+		Error:        "Fehler",
+		VisitURL:     "URL besuchen",
+		Go:           "los",
+		NoDirectURL:  "Achtung! Diese Instanz unterstützt das direkte Öffnen von URLs nicht.",
+		ExitTitle:    "Du bist dabei, MortyProxy zu verlassen",
+		ExitFollow:   "Weiter zu",
+		ExitWarning:  "der Inhalt dieser URL wird <b>NICHT</b> bereinigt.",
+		BlockedTitle: "Blockierter Inhalt",
+		BlockedType:  "Morty liefert diese Art von Inhalt nicht aus",
+		BlockedOpen:  "Die Originalressource öffnen",
+		Footer:       "Morty schreibt Webseiten um, um schädliche HTML-Tags und CSS/HTML-Attribute zu entfernen. Außerdem ersetzt es Verweise auf externe Ressourcen, um Datenlecks an Dritte zu verhindern.",
+		ViewSource:   "auf GitHub ansehen",
+		Permalink:    "Permalink",
+		ShareHint:    "Diesen Link kopieren, um die bereinigte Seite zu teilen",
+		ExitQRCode:   "QR-Code der URL, um sie auf einem anderen Gerät zu öffnen",
+		HTTPTitle:    "Unsichere Weiterleitung",
+		HTTPWarning:  "Die Seite leitet von HTTPS auf HTTP weiter: die Verbindung zwischen Morty und der Website ist nicht verschlüsselt.",
+		HTTPContinue: "Trotzdem fortfahren",
+		Homograph:    "Achtung! Der Domainname mischt Buchstaben mehrerer Alphabete: er könnte eine andere Domain nachahmen.",
+		Archived:     "Archivierte Kopie von",
+		ArchiveOffer: "Eine archivierte Kopie der Seite ansehen",
+		FileTitle:    "Dateidownload",
+		FileType:     "Typ",
+		FileSize:     "Größe",
+		FileUnknown:  "unbekannt",
+		FileDownload: "Datei herunterladen",
+	},
+	"fr": {
+		Hide:         "masquer",
+		BannerStart:  "Ceci est une vue",
+		BannerLink:   "relayée et nettoyée",
+		BannerEnd:    "de la page, visiter le",
+		OriginalSite: "site d'origine",
+		Error:        "Erreur",
+		VisitURL:     "Visiter l'url",
+		Go:           "aller",
+		NoDirectURL:  "Attention ! Cette instance ne permet pas d'ouvrir directement une URL.",
+		ExitTitle:    "Vous êtes sur le point de quitter MortyProxy",
+		ExitFollow:   "Suivre",
+		ExitWarning:  "le contenu de cette URL ne sera <b>PAS</b> nettoyé.",
+		BlockedTitle: "Contenu bloqué",
+		BlockedType:  "Morty ne sert pas ce type de contenu",
+		BlockedOpen:  "Ouvrir la ressource d'origine",
+		Footer:       "Morty réécrit les pages web pour en exclure les balises HTML et les attributs CSS/HTML malveillants. Il remplace également les références aux ressources externes pour éviter les fuites d'informations vers des tiers.",
+		ViewSource:   "voir sur github",
+		Permalink:    "lien permanent",
+		ShareHint:    "Copier ce lien pour partager la page nettoyée",
+		ExitQRCode:   "QR code de l'URL, pour l'ouvrir sur un autre appareil",
+		HTTPTitle:    "Redirection non sécurisée",
+		HTTPWarning:  "La page redirige de HTTPS vers HTTP : la connexion entre morty et le site n'est pas chiffrée.",
+		HTTPContinue: "Continuer quand même",
+		Homograph:    "Attention ! Le nom de domaine mélange des lettres de plusieurs alphabets : il peut imiter un autre domaine.",
+		Archived:     "Copie archivée de",
+		ArchiveOffer: "Voir une copie archivée de la page",
+		FileTitle:    "Téléchargement de fichier",
+		FileType:     "Type",
+		FileSize:     "Taille",
+		FileUnknown:  "inconnue",
+		FileDownload: "Télécharger le fichier",
+	},
+}
+
+// DefaultLanguage is the language of the pages of a Proxy created by New
+const DefaultLanguage = "en"
+
+// languageMatcher negotiates the language of the pages with the languages of the Catalog
+type languageMatcher struct {
+	// languages of the catalog, the default language first
+	languages []string
+	matcher   language.Matcher
+}
+
+// matchers of the languages of the Catalog by default language
+var languageMatchers = map[string]*languageMatcher{}
+
+func init() {
+	for lang := range Catalog {
+		languageMatchers[lang] = newLanguageMatcher(lang)
+	}
+}
+
+// newLanguageMatcher returns the matcher which uses lang when none of the accepted languages of the client
+// is in the catalog
+func newLanguageMatcher(lang string) *languageMatcher {
+	languages := []string{lang}
+	for l := range Catalog {
+		if l != lang {
+			languages = append(languages, l)
+		}
+	}
+	sort.Strings(languages[1:])
+	tags := make([]language.Tag, len(languages))
+	for i, l := range languages {
+		tags[i] = language.Make(l)
+	}
+	return &languageMatcher{languages: languages, matcher: language.NewMatcher(tags)}
+}
+
+// requestLanguage negotiates the UI language with the Accept-Language header
+func (p *Proxy) requestLanguage(ctx *fasthttp.RequestCtx) string {
+	m, ok := languageMatchers[p.Language]
+	if !ok {
+		m = languageMatchers[DefaultLanguage]
+	}
+	acceptLanguage := ctx.Request.Header.Peek("Accept-Language")
+	if len(acceptLanguage) == 0 {
+		return m.languages[0]
+	}
+	_, index := language.MatchStrings(m.matcher, string(acceptLanguage))
+	return m.languages[index]
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+var requestLanguageTestData = []struct {
+	AcceptLanguage string
+	Lang           string
+}{
+	{"", "en"},
+	{"de-DE,de;q=0.9,en;q=0.8", "de"},
+	{"fr-CA", "fr"},
+	{"ja,fr;q=0.5", "fr"},
+	{"ja", "en"},
+	{"en-US,en;q=0.9", "en"},
+}
+
+func TestRequestLanguage(t *testing.T) {
+	for _, testCase := range requestLanguageTestData {
+		ctx := &fasthttp.RequestCtx{}
+		if testCase.AcceptLanguage != "" {
+			ctx.Request.Header.Set("Accept-Language", testCase.AcceptLanguage)
+		}
+		lang := New().requestLanguage(ctx)
+		if lang != testCase.Lang {
+			t.Errorf(`Language error. Accept-Language: "%s", Expected: "%s", Got: "%s"`, testCase.AcceptLanguage, testCase.Lang, lang)
+		}
+	}
+}
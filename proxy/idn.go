@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/friedemannsommer/morty/sanitize"
+	"golang.org/x/net/idna"
+)
+
+// Cyrillic and Greek letters looking like Latin letters
+const latinLookalikes = "аеорсухіјѕԁһӏԛԝαοικνρτυχ"
+
+// displayURL returns the URL shown to the users: the internationalized host names are shown in Unicode.
+// It reports whether the host name may imitate another one.
+func displayURL(u *url.URL) (string, bool) {
+	u = sanitize.PunycodeHost(u)
+	unicodeHostname, err := idna.Display.ToUnicode(u.Hostname())
+	if err != nil || unicodeHostname == u.Hostname() {
+		return u.String(), false
+	}
+	unicodeHost := unicodeHostname
+	if port := u.Port(); port != "" {
+		unicodeHost += ":" + port
+	}
+	// url.URL.String escapes the non ASCII host names: only the host is replaced
+	prefix := u.Scheme + "://"
+	if u.User != nil {
+		prefix += u.User.String() + "@"
+	}
+	displayed := u.String()
+	if strings.HasPrefix(displayed, prefix+u.Host) {
+		displayed = prefix + unicodeHost + strings.TrimPrefix(displayed, prefix+u.Host)
+	}
+	return displayed, isHomograph(unicodeHostname)
+}
+
+// isHomograph reports whether a label of a Unicode host name mixes Latin, Cyrillic and Greek letters,
+// or is only made of Cyrillic and Greek letters looking like Latin letters
+func isHomograph(hostname string) bool {
+	for _, label := range strings.Split(hostname, ".") {
+		var latin, cyrillic, greek, lookalikes int
+		for _, r := range label {
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				latin++
+			case unicode.Is(unicode.Cyrillic, r):
+				cyrillic++
+			case unicode.Is(unicode.Greek, r):
+				greek++
+			default:
+				continue
+			}
+			if strings.ContainsRune(latinLookalikes, r) {
+				lookalikes++
+			}
+		}
+		scripts := 0
+		for _, count := range []int{latin, cyrillic, greek} {
+			if count > 0 {
+				scripts++
+			}
+		}
+		if scripts > 1 || (latin == 0 && lookalikes > 0 && lookalikes == cyrillic+greek) {
+			return true
+		}
+	}
+	return false
+}
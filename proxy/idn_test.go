@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDisplayURL(t *testing.T) {
+	for _, test := range []struct {
+		uri       string
+		displayed string
+		homograph bool
+	}{
+		{"https://example.com/a%20b", "https://example.com/a%20b", false},
+		{"https://xn--bcher-kva.example:8080/?q=%26", "https://bücher.example:8080/?q=%26", false},
+		{"https://user@bücher.example/", "https://user@bücher.example/", false},
+		{"https://xn--80ak6aa92e.com/", "https://аррӏе.com/", true},
+		{"https://xn--pypl-53dc.com/", "https://pаypаl.com/", true},
+		{"https://xn--e1afmkfd.xn--p1ai/", "https://пример.рф/", false},
+	} {
+		u, _ := url.Parse(test.uri)
+		displayed, homograph := displayURL(u)
+		if displayed != test.displayed || homograph != test.homograph {
+			t.Errorf("%s: expected %s %t, got %s %t", test.uri, test.displayed, test.homograph, displayed, homograph)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/friedemannsommer/morty/contenttype"
+)
+
+const (
+	ImagePolicyNone     = "none"
+	ImagePolicyStrip    = "strip"
+	ImagePolicyReencode = "reencode"
+)
+
+// largest image decoded by the re-encoding, in pixels
+const ImageMaxPixels = 50 * 1000 * 1000
+
+// images processed by the image policy: the other formats are served as is
+var ProcessedImageContentTypeFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	contenttype.NewFilterEquals("image", "gif", ""),
+	contenttype.NewFilterEquals("image", "png", ""),
+	contenttype.NewFilterEquals("image", "jpeg", ""),
+	contenttype.NewFilterEquals("image", "pjpeg", ""),
+	contenttype.NewFilterEquals("image", "webp", ""),
+})
+
+// PNG chunks kept by the metadata stripping, the text, EXIF, ICC profile and time chunks are removed
+var PNGSafeChunks = map[string]bool{
+	"IHDR": true,
+	"PLTE": true,
+	"IDAT": true,
+	"IEND": true,
+	"tRNS": true,
+	"gAMA": true,
+	"cHRM": true,
+	"sRGB": true,
+	"sBIT": true,
+	"bKGD": true,
+	"pHYs": true,
+	// APNG
+	"acTL": true,
+	"fcTL": true,
+	"fdAT": true,
+}
+
+// WebP chunks removed by the metadata stripping
+var WebPMetadataChunks = map[string]bool{
+	"EXIF": true,
+	"XMP ": true,
+	"ICCP": true,
+}
+
+var errInvalidImage = errors.New("invalid image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// processImage strips the metadata of an image or re-encodes it, depending on policy.
+// Re-encoded images are downscaled to fit in maxSize x maxSize pixels (0 keeps their size).
+// WebP images can't be decoded: their metadata is stripped in both modes.
+func processImage(policy string, maxSize int, contentType contenttype.ContentType, img []byte) ([]byte, error) {
+	if policy == ImagePolicyReencode && contentType.SubType != "webp" {
+		return reencodeImage(img, maxSize)
+	}
+	switch contentType.SubType {
+	case "jpeg", "pjpeg":
+		return stripJPEGMetadata(img)
+	case "png":
+		return stripPNGMetadata(img)
+	case "gif":
+		return stripGIFMetadata(img)
+	case "webp":
+		return stripWebPMetadata(img)
+	}
+	return img, nil
+}
+
+// isProcessedImage reports whether the image policy applies to contentType
+func (p *Proxy) isProcessedImage(contentType contenttype.ContentType) bool {
+	return p.ImagePolicy != "" && p.ImagePolicy != ImagePolicyNone && ProcessedImageContentTypeFilter(contentType)
+}
+
+// stripJPEGMetadata removes the APP1-APP13 and APP15 segments (EXIF, XMP, ICC profiles, IPTC) and the comments.
+// APP0 (JFIF) and APP14 (Adobe color transform) are required to decode the image.
+func stripJPEGMetadata(img []byte) ([]byte, error) {
+	if len(img) < 2 || img[0] != 0xff || img[1] != 0xd8 {
+		return nil, errInvalidImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(img)))
+	out.Write(img[:2])
+	for i := 2; ; {
+		if i+4 > len(img) || img[i] != 0xff {
+			return nil, errInvalidImage
+		}
+		marker := img[i+1]
+		if marker == 0xda {
+			// start of scan: the compressed data and the end of image follow
+			out.Write(img[i:])
+			return out.Bytes(), nil
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(img[i+2:]))
+		if end > len(img) {
+			return nil, errInvalidImage
+		}
+		if !(marker >= 0xe1 && marker <= 0xef && marker != 0xee) && marker != 0xfe {
+			out.Write(img[i:end])
+		}
+		i = end
+	}
+}
+
+// stripPNGMetadata removes the chunks which are not in PNGSafeChunks
+func stripPNGMetadata(img []byte) ([]byte, error) {
+	if !bytes.HasPrefix(img, pngSignature) {
+		return nil, errInvalidImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(img)))
+	out.Write(pngSignature)
+	for i := len(pngSignature); i < len(img); {
+		if i+8 > len(img) {
+			return nil, errInvalidImage
+		}
+		// length, type, data and CRC
+		end := i + 12 + int(binary.BigEndian.Uint32(img[i:]))
+		if end > len(img) || end < i {
+			return nil, errInvalidImage
+		}
+		chunkType := string(img[i+4 : i+8])
+		if PNGSafeChunks[chunkType] {
+			out.Write(img[i:end])
+		}
+		if chunkType == "IEND" {
+			break
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// stripGIFMetadata removes the comments and the application extensions except the animation loop count
+func stripGIFMetadata(img []byte) ([]byte, error) {
+	if len(img) < 13 || !bytes.HasPrefix(img, []byte("GIF8")) {
+		return nil, errInvalidImage
+	}
+	i := 13
+	if img[10]&0x80 != 0 {
+		// global color table
+		i += 3 << (uint(img[10]&0x07) + 1)
+	}
+	if i > len(img) {
+		return nil, errInvalidImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(img)))
+	out.Write(img[:i])
+	for i < len(img) {
+		start := i
+		keep := true
+		switch img[i] {
+		case 0x3b:
+			// trailer
+			out.WriteByte(0x3b)
+			return out.Bytes(), nil
+		case 0x21:
+			if i+2 > len(img) {
+				return nil, errInvalidImage
+			}
+			switch img[i+1] {
+			case 0xfe:
+				keep = false
+			case 0xff:
+				keep = i+14 <= len(img) && (bytes.Equal(img[i+3:i+14], []byte("NETSCAPE2.0")) || bytes.Equal(img[i+3:i+14], []byte("ANIMEXTS1.0")))
+			}
+			i += 2
+		case 0x2c:
+			// image descriptor, local color table and LZW minimum code size
+			if i+10 > len(img) {
+				return nil, errInvalidImage
+			}
+			flags := img[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (uint(flags&0x07) + 1)
+			}
+			i++
+		default:
+			return nil, errInvalidImage
+		}
+		// data sub-blocks
+		for {
+			if i >= len(img) {
+				return nil, errInvalidImage
+			}
+			size := int(img[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+		if i > len(img) {
+			return nil, errInvalidImage
+		}
+		if keep {
+			out.Write(img[start:i])
+		}
+	}
+	return nil, errInvalidImage
+}
+
+// stripWebPMetadata removes the EXIF, XMP and ICC profile chunks and their flags in the VP8X chunk
+func stripWebPMetadata(img []byte) ([]byte, error) {
+	if len(img) < 12 || !bytes.Equal(img[:4], []byte("RIFF")) || !bytes.Equal(img[8:12], []byte("WEBP")) {
+		return nil, errInvalidImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(img)))
+	out.Write(img[:12])
+	for i := 12; i < len(img); {
+		if i+8 > len(img) {
+			return nil, errInvalidImage
+		}
+		size := int(binary.LittleEndian.Uint32(img[i+4:]))
+		// the chunks are padded to an even size
+		end := i + 8 + size + size&1
+		if end > len(img) || end < i {
+			return nil, errInvalidImage
+		}
+		chunkType := string(img[i : i+4])
+		if chunkType == "VP8X" && size > 0 {
+			chunk := append([]byte(nil), img[i:end]...)
+			// ICC profile, EXIF and XMP flags
+			chunk[8] &^= 0x20 | 0x08 | 0x04
+			out.Write(chunk)
+		} else if !WebPMetadataChunks[chunkType] {
+			out.Write(img[i:end])
+		}
+		i = end
+	}
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
+
+// reencodeImage decodes and encodes an image in the same format, only the pixels are kept
+func reencodeImage(img []byte, maxSize int) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > ImageMaxPixels {
+		return nil, errors.New("image too large")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(img)))
+	if format == "gif" {
+		anim, err := gif.DecodeAll(bytes.NewReader(img))
+		if err != nil {
+			return nil, err
+		}
+		if len(anim.Image) > 1 {
+			// animations are not downscaled
+			err = gif.EncodeAll(out, anim)
+			return out.Bytes(), err
+		}
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	decoded = downscaleImage(decoded, maxSize)
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(out, decoded, &jpeg.Options{Quality: 90})
+	case "png":
+		err = png.Encode(out, decoded)
+	case "gif":
+		err = gif.Encode(out, decoded, nil)
+	default:
+		err = errInvalidImage
+	}
+	return out.Bytes(), err
+}
+
+// downscaleImage resizes img to fit in maxSize x maxSize pixels, each pixel is the average of its source area
+func downscaleImage(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxSize <= 0 || (width <= maxSize && height <= maxSize) {
+		return img
+	}
+	dstWidth, dstHeight := maxSize, maxSize
+	if width > height {
+		dstHeight = max(1, height*maxSize/width)
+	} else {
+		dstWidth = max(1, width*maxSize/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/dstHeight, bounds.Min.Y+(y+1)*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/dstWidth, bounds.Min.X+(x+1)*width/dstWidth
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/friedemannsommer/morty/contenttype"
+)
+
+const imageSecret = "GPS 48.8584 2.2945"
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 12), 128, 255})
+		}
+	}
+	return img
+}
+
+func pngChunk(chunkType, data string) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func testImages(t *testing.T) map[string][]byte {
+	var pngBuf, jpegBuf, gifBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegBuf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifBuf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// metadata after the PNG header chunk
+	pngImg := pngBuf.Bytes()
+	pngWithText := append(append(append([]byte(nil), pngImg[:33]...), pngChunk("tEXt", "Comment\x00"+imageSecret)...), pngImg[33:]...)
+
+	// EXIF segment after the start of image
+	exif := []byte{0xff, 0xe1, 0, byte(2 + len(imageSecret))}
+	jpegWithExif := append(append(append([]byte{0xff, 0xd8}, exif...), imageSecret...), jpegBuf.Bytes()[2:]...)
+
+	// comment extension before the trailer
+	gifImg := gifBuf.Bytes()
+	comment := append(append([]byte{0x21, 0xfe, byte(len(imageSecret))}, imageSecret...), 0)
+	gifWithComment := append(append(append([]byte(nil), gifImg[:len(gifImg)-1]...), comment...), 0x3b)
+
+	// EXIF chunk after a VP8L chunk, the image data is not decoded
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x02\x00\x00\x00\x2f\x00EXIF\x12\x00\x00\x00" + imageSecret)
+	binary.LittleEndian.PutUint32(webp[4:], uint32(len(webp)-8))
+
+	return map[string][]byte{
+		"png":  pngWithText,
+		"jpeg": jpegWithExif,
+		"gif":  gifWithComment,
+		"webp": webp,
+	}
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	for subType, img := range testImages(t) {
+		if !bytes.Contains(img, []byte(imageSecret)) {
+			t.Fatalf("%s: invalid test image", subType)
+		}
+		res, err := processImage(ImagePolicyStrip, 0, contenttype.ContentType{TopLevelType: "image", SubType: subType}, img)
+		if err != nil {
+			t.Errorf("%s: metadata stripping error: %v", subType, err)
+			continue
+		}
+		if bytes.Contains(res, []byte(imageSecret)) {
+			t.Errorf("%s: the metadata have not been removed", subType)
+		}
+		if subType == "webp" {
+			if size := binary.LittleEndian.Uint32(res[4:]); int(size) != len(res)-8 {
+				t.Errorf("webp: invalid RIFF size %d", size)
+			}
+			continue
+		}
+		if _, _, err := image.Decode(bytes.NewReader(res)); err != nil {
+			t.Errorf("%s: the stripped image can't be decoded: %v", subType, err)
+		}
+	}
+}
+
+func TestReencodeImage(t *testing.T) {
+	for subType, img := range testImages(t) {
+		if subType == "webp" {
+			continue
+		}
+		res, err := processImage(ImagePolicyReencode, 10, contenttype.ContentType{TopLevelType: "image", SubType: subType}, img)
+		if err != nil {
+			t.Errorf("%s: re-encoding error: %v", subType, err)
+			continue
+		}
+		if bytes.Contains(res, []byte(imageSecret)) {
+			t.Errorf("%s: the metadata have not been removed", subType)
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(res))
+		if err != nil || format != subType || config.Width != 10 || config.Height != 5 {
+			t.Errorf("%s: expected a 10x5 %s image, got a %dx%d %s image (%v)", subType, subType, config.Width, config.Height, format, err)
+		}
+	}
+
+	if _, err := processImage(ImagePolicyReencode, 0, contenttype.ContentType{TopLevelType: "image", SubType: "png"}, []byte("not an image")); err == nil {
+		t.Errorf("Re-encoding error. Expected an error for an invalid image")
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// formats of the HMAC key
+const (
+	KeyFormatHex    = "hex"
+	KeyFormatBase64 = "base64"
+	KeyFormatRaw    = "raw"
+)
+
+// DecodeKey decodes the HMAC key and returns its format. The format can be given by a "hex:", "base64:" or "raw:"
+// prefix, otherwise an even number of hexadecimal digits is hex, a valid base64 string is base64 and anything else
+// is used as is: searx and SearXNG deployments configure the key in these encodings.
+func DecodeKey(key string) ([]byte, string, error) {
+	for _, format := range []string{KeyFormatHex, KeyFormatBase64, KeyFormatRaw} {
+		if strings.HasPrefix(key, format+":") {
+			decoded, err := decodeKeyFormat(strings.TrimPrefix(key, format+":"), format)
+			return decoded, format, err
+		}
+	}
+	for _, format := range []string{KeyFormatHex, KeyFormatBase64} {
+		if decoded, err := decodeKeyFormat(key, format); err == nil {
+			return decoded, format, nil
+		}
+	}
+	return []byte(key), KeyFormatRaw, nil
+}
+
+func decodeKeyFormat(key, format string) ([]byte, error) {
+	switch format {
+	case KeyFormatHex:
+		return hex.DecodeString(key)
+	case KeyFormatBase64:
+		return base64.StdEncoding.DecodeString(key)
+	}
+	return []byte(key), nil
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeKey(t *testing.T) {
+	for _, test := range []struct {
+		key    string
+		format string
+		output []byte
+	}{
+		{"6d6f727479", KeyFormatHex, []byte("morty")},
+		{"bW9ydHk=", KeyFormatBase64, []byte("morty")},
+		{"morty key", KeyFormatRaw, []byte("morty key")},
+		{"base64:abcd", KeyFormatBase64, []byte{0x69, 0xb7, 0x1d}},
+		{"raw:abcd", KeyFormatRaw, []byte("abcd")},
+		{"abcd", KeyFormatHex, []byte{0xab, 0xcd}},
+	} {
+		output, format, err := DecodeKey(test.key)
+		if err != nil || format != test.format || !bytes.Equal(output, test.output) {
+			t.Errorf("%s: expected %s %q, got %s %q %v", test.key, test.format, test.output, format, output, err)
+		}
+	}
+	if _, _, err := DecodeKey("hex:morty"); err == nil {
+		t.Error("invalid hex key should be rejected")
+	}
+}
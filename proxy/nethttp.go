@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ServeHTTP makes Proxy a net/http Handler, so morty can be mounted into an existing net/http server.
+// The request is converted to a fasthttp request and handled by RequestHandler, the streamed responses
+// are flushed while they are written. The proxified URLs are relative: morty can be mounted under a path
+// ending with a slash, with http.StripPrefix. The absolute morty URLs start with the removed prefix.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, fasthttp.DefaultMaxRequestBodySize))
+	if err != nil {
+		// HTTP status code 413 : Payload Too Large
+		http.Error(w, "request body too large", 413)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(scheme + "://" + r.Host + r.URL.RequestURI())
+	for name, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.SetBody(body)
+
+	var remoteAddr net.Addr
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		portNumber, _ := strconv.Atoi(port)
+		remoteAddr = &net.TCPAddr{IP: net.ParseIP(host), Port: portNumber}
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(req, remoteAddr, nil)
+	// the prefix removed by http.StripPrefix, RequestURI is the path requested by the client
+	if original, err := url.ParseRequestURI(r.RequestURI); err == nil && strings.HasSuffix(original.Path, r.URL.Path) {
+		ctx.SetUserValue(mountPrefixKey, strings.TrimSuffix(original.Path, r.URL.Path))
+	}
+	p.RequestHandler(ctx)
+	writeResponse(w, r.Method, &ctx.Response)
+}
+
+// writeResponse sends a fasthttp response to a net/http client, the body is not sent to a HEAD request
+func writeResponse(w http.ResponseWriter, method string, resp *fasthttp.Response) {
+	resp.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case fasthttp.HeaderContentLength, fasthttp.HeaderConnection:
+			// set by net/http
+		default:
+			w.Header().Add(string(key), string(value))
+		}
+	})
+	if !resp.IsBodyStream() {
+		w.Header().Set(fasthttp.HeaderContentLength, strconv.Itoa(len(resp.Body())))
+	}
+	w.WriteHeader(resp.StatusCode())
+	if method == fasthttp.MethodHead {
+		// the stream writer of a streamed body is stopped, it releases the upstream connection
+		resp.ResetBody()
+		return
+	}
+
+	var out io.Writer = w
+	if flusher, ok := w.(http.Flusher); ok && resp.IsBodyStream() {
+		out = &flushWriter{w, flusher}
+	}
+	_ = resp.BodyWriteTo(out)
+}
+
+// flushWriter sends each write of a streamed response to the client
+type flushWriter struct {
+	io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	n, err := f.Writer.Write(b)
+	f.flusher.Flush()
+	return n, err
+}
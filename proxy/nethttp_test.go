@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestServeHTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/next" {
+			_, _ = io.WriteString(w, `<p>next page</p>`)
+			return
+		}
+		_, _ = io.WriteString(w, `<p>hello</p><script>alert(1)</script><a href="/next">next</a>`)
+	}))
+	defer upstream.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/morty/", http.StripPrefix("/morty", New(func(p *Proxy) { p.NoHeader = true })))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	pageURL, _ := url.Parse(server.URL + "/morty/?mortyurl=" + url.QueryEscape(upstream.URL))
+	resp, err := http.Get(pageURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "<p>hello</p>") || strings.Contains(string(body), "alert") {
+		t.Errorf("Unexpected body %q", body)
+	}
+
+	// the proxified links are resolved under the prefix
+	match := regexp.MustCompile(`href="([^"]*)">next`).FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("Expected a proxified link in %q", body)
+	}
+	link, err := pageURL.Parse(html.UnescapeString(string(match[1])))
+	if err != nil || !strings.HasPrefix(link.Path, "/morty/") {
+		t.Fatalf("Expected a link under /morty/, got %q", match[1])
+	}
+	resp, err = http.Get(link.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(string(body), "<p>next page</p>") {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = http.Head(pageURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || len(body) != 0 {
+		t.Errorf("Unexpected HEAD response %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(server.URL + "/morty/?mortyurl=" + url.QueryEscape("ftp://example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 200 {
+		t.Errorf("Expected an error for an invalid URL, got %d", resp.StatusCode)
+	}
+}
+
+func TestWriteResponseHead(t *testing.T) {
+	resp := &fasthttp.Response{}
+	stopped := make(chan struct{})
+	resp.SetBodyStreamWriter(func(w *bufio.Writer) {
+		// an endless stream, like an event stream, ends when nobody reads it
+		for {
+			if _, err := w.WriteString("data: x\n\n"); err != nil || w.Flush() != nil {
+				close(stopped)
+				return
+			}
+		}
+	})
+
+	out := httptest.NewRecorder()
+	writeResponse(out, fasthttp.MethodHead, resp)
+	if out.Code != 200 || out.Body.Len() != 0 {
+		t.Errorf("Unexpected HEAD response %d %q", out.Code, out.Body.String())
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("The stream writer of a HEAD response should be stopped")
+	}
+}
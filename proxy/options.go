@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/friedemannsommer/morty/cache"
+	"github.com/friedemannsommer/morty/logging"
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+// timeout of the upstream requests of a Proxy created by New
+const DefaultRequestTimeout = 5 * time.Second
+
+// duration of the relayed event streams of a Proxy created by New
+const DefaultEventStreamTimeout = time.Minute
+
+// Option configures a Proxy created by New, the exported fields of the Proxy can be set by any function
+type Option func(p *Proxy)
+
+// New returns a Proxy with its own upstream clients and the default settings of morty,
+// the options are applied in order. Several proxies can be used in the same process.
+// It panics on the programming errors reported by WithHooks and WithLanguage.
+func New(opts ...Option) *Proxy {
+	favicon, _ := base64.StdEncoding.DecodeString(faviconBase64)
+	p := &Proxy{
+		RequestTimeout:     DefaultRequestTimeout,
+		EventStreamTimeout: DefaultEventStreamTimeout,
+		Client:             NewClient(),
+		CacheHeaders:       append([]string(nil), DefaultCacheHeaders...),
+		HeaderTheme:        DefaultHeaderTheme,
+		Favicon:            favicon,
+		FaviconContentType: "image/png",
+		Templates:          DefaultTemplates(),
+		Language:           DefaultLanguage,
+		Logger:             logging.New(os.Stderr, logging.LevelInfo, logging.FormatLogfmt),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.StreamClient == nil {
+		p.StreamClient = newStreamClient(p.Client)
+	}
+	return p
+}
+
+// WithKey signs the proxified URLs with the HMAC key, the requests with an invalid hash are rejected
+func WithKey(key []byte) Option {
+	return func(p *Proxy) {
+		p.Key = key
+	}
+}
+
+// WithRequestTimeout sets the timeout of the upstream requests, DefaultRequestTimeout by default
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.RequestTimeout = timeout
+	}
+}
+
+// WithClient sets the upstream client. Unless StreamClient is set, the large responses are streamed with
+// the dialer and the TLS configuration of the client, which are read when the connections are opened.
+func WithClient(client *fasthttp.Client) Option {
+	return func(p *Proxy) {
+		p.Client = client
+	}
+}
+
+// WithAdminToken enables the admin endpoints: cache purge, statistics and URL proxification.
+// The endpoints stay disabled if the token is empty.
+func WithAdminToken(token []byte, telemetryHosts string) Option {
+	return func(p *Proxy) {
+		if len(token) == 0 {
+			return
+		}
+		p.AdminToken = token
+		p.Stats = NewStats(telemetryHosts)
+	}
+}
+
+// WithHooks adds custom rules to the sanitizers: each hook is added as an element, attribute and URL hook
+// according to the sanitize.ElementHook, sanitize.AttributeHook and sanitize.URLHook interfaces it implements.
+// New panics if a hook implements none of them.
+func WithHooks(hooks ...interface{}) Option {
+	return func(p *Proxy) {
+		for _, hook := range hooks {
+			added := false
+			if h, ok := hook.(sanitize.ElementHook); ok {
+				p.ElementHooks, added = append(p.ElementHooks, h), true
+			}
+			if h, ok := hook.(sanitize.AttributeHook); ok {
+				p.AttributeHooks, added = append(p.AttributeHooks, h), true
+			}
+			if h, ok := hook.(sanitize.URLHook); ok {
+				p.URLHooks, added = append(p.URLHooks, h), true
+			}
+			if !added {
+				panic(fmt.Sprintf("morty: %T is not a sanitizer hook", hook))
+			}
+		}
+	}
+}
+
+// WithHTMLCache caches the sanitized HTML documents for ttl
+func WithHTMLCache(ttl time.Duration) Option {
+	return func(p *Proxy) {
+		p.HTMLCache = cache.New(ttl, HTMLCacheMaxSize)
+	}
+}
+
+// WithLogger sets the logger of the requests and of the errors
+func WithLogger(logger *logging.Logger) Option {
+	return func(p *Proxy) {
+		p.Logger = logger
+	}
+}
+
+// WithTemplates replaces the built-in templates, see LoadTemplates
+func WithTemplates(templates *Templates) Option {
+	return func(p *Proxy) {
+		p.Templates = templates
+	}
+}
+
+// WithLanguage sets the language of the pages when none of the accepted languages of the client is in the Catalog.
+// New panics if the language is not in the Catalog.
+func WithLanguage(lang string) Option {
+	return func(p *Proxy) {
+		if _, ok := Catalog[lang]; !ok {
+			panic("morty: unsupported language: " + lang)
+		}
+		p.Language = lang
+	}
+}
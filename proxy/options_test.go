@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+func TestNew(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<div data-id="1"><img src="/a.png"></div>`)
+	}))
+	defer server.Close()
+
+	// differently configured proxies don't share their settings
+	plain := New(func(p *Proxy) { p.NoHeader = true })
+	custom := New(func(p *Proxy) {
+		p.NoHeader = true
+		p.TextOnly = true
+		p.DataAttributes = true
+		p.CacheHeaders = nil
+	})
+	if plain.Client == custom.Client || plain.StreamClient == custom.StreamClient || len(plain.CacheHeaders) == 0 {
+		t.Fatal("The proxies share their clients or settings")
+	}
+
+	// the parallel subtests end before the server is closed
+	t.Run("parallel", func(t *testing.T) {
+		for _, testCase := range []struct {
+			p        *Proxy
+			expected string
+		}{
+			{plain, `<div><img src="./?mortyurl=`},
+			{custom, `<div data-id="1"></div>`},
+		} {
+			testCase := testCase
+			t.Run("", func(t *testing.T) {
+				t.Parallel()
+				ctx := &fasthttp.RequestCtx{}
+				testCase.p.ProcessUri(ctx, server.URL, 0)
+				if !strings.HasPrefix(string(ctx.Response.Body()), testCase.expected) {
+					t.Errorf("Expected %q, got %q", testCase.expected, ctx.Response.Body())
+				}
+			})
+		}
+	})
+}
+
+func TestWithHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<p class="ad">ad</p><a href="https://www.youtube.com/watch?v=1">video</a>`)
+	}))
+	defer server.Close()
+
+	p := New(func(p *Proxy) { p.NoHeader = true }, WithHooks(
+		sanitize.ElementHookFunc(func(tag string, attrs []sanitize.Attribute) ([]sanitize.Attribute, bool) {
+			return attrs, !(tag == "p" && len(attrs) == 1 && attrs[0].Value == "ad")
+		}),
+		sanitize.URLHookFunc(func(u *url.URL) *url.URL {
+			if u.Host == "www.youtube.com" {
+				u.Host = "invidious.example.com"
+			}
+			return u
+		}),
+	))
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL))
+	p.RequestHandler(ctx)
+	body := string(ctx.Response.Body())
+	if strings.Contains(body, "ad</p>") || !strings.Contains(body, url.QueryEscape("https://invidious.example.com/watch")) {
+		t.Errorf("Expected the hooks to be applied, got %s", body)
+	}
+}
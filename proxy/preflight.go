@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/friedemannsommer/morty/contenttype"
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+// file extensions of the URLs which are likely attachments: the HEAD preflight is only sent for them
+var attachmentExtensions = map[string]bool{
+	".7z":   true,
+	".apk":  true,
+	".bin":  true,
+	".bz2":  true,
+	".csv":  true,
+	".deb":  true,
+	".dmg":  true,
+	".doc":  true,
+	".docx": true,
+	".epub": true,
+	".exe":  true,
+	".flac": true,
+	".gz":   true,
+	".iso":  true,
+	".mkv":  true,
+	".mp3":  true,
+	".mp4":  true,
+	".msi":  true,
+	".odg":  true,
+	".odp":  true,
+	".ods":  true,
+	".odt":  true,
+	".pdf":  true,
+	".ppt":  true,
+	".pptx": true,
+	".rar":  true,
+	".rpm":  true,
+	".tar":  true,
+	".tgz":  true,
+	".wav":  true,
+	".xls":  true,
+	".xlsx": true,
+	".xz":   true,
+	".zip":  true,
+}
+
+// isLikelyAttachment reports whether the extension of the URL path is the one of a downloaded file
+func isLikelyAttachment(u *url.URL) bool {
+	return attachmentExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// preflightRequest sends a HEAD request to learn the type and size of the upstream resource
+// before its body is downloaded. It returns false if a response has already been served,
+// and whether the resource is too large to be buffered and must be streamed.
+// The attachments larger than AttachmentMaxSize are rejected, the other ones are downloaded after the
+// download page showing their size, unless the download has been confirmed by the "mortydownload" parameter.
+func (p *Proxy) preflightRequest(ctx *fasthttp.RequestCtx, requestURIStr string, parsedURI *url.URL) (bool, bool) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	if !p.KeepAlive {
+		req.SetConnectionClose()
+	}
+
+	p.Logger.Debug("upstream request", "method", "HEAD", "url", requestURIStr)
+
+	req.SetRequestURI(requestURIStr)
+	req.Header.SetMethod(fasthttp.MethodHead)
+	req.Header.SetUserAgentBytes(UserAgent)
+	forwardAcceptHeader(ctx, req)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	scrubRequestHeaders(req, p.StrictHeaders)
+
+	// any failure here is left to the GET request to report
+	if err := p.Client.DoTimeout(req, resp, p.RequestTimeout); err != nil || resp.StatusCode() != 200 {
+		return true, false
+	}
+
+	contentType, err := contenttype.ParseContentTypeHeader(string(resp.Header.ContentType()))
+	if err != nil {
+		return true, false
+	}
+	if StreamingContentTypeFilter(contentType) {
+		// HTTP status code 501 : Not Implemented
+		p.serveMainPage(ctx, 501, errors.New("streaming content is not supported "+parsedURI.String()))
+		return false, false
+	}
+	if !AllowedContentTypeFilter(contentType) && !(p.ProxyMedia && AllowedContentTypeMediaFilter(contentType)) &&
+		!p.isAttachment(contentType) {
+		p.serveBlockedPage(ctx, parsedURI, contentType)
+		return false, false
+	}
+
+	// the length is -1 if it is unknown
+	size := int64(resp.Header.ContentLength())
+	if p.isAttachment(contentType) {
+		if p.AttachmentMaxSize > 0 && size > p.AttachmentMaxSize {
+			// HTTP status code 413 : Payload Too Large
+			p.serveMainPage(ctx, 413, fmt.Errorf("file too large: %s (%s)", formatSize(size), parsedURI.String()))
+			return false, false
+		}
+		if ctx.UserValue("mortydownload") == nil {
+			p.serveDownloadPage(ctx, parsedURI, contentType, size)
+			return false, false
+		}
+	}
+
+	// the sanitized documents are never streamed, except the HTML documents, the stylesheets and the subtitles
+	return true, size > int64(p.Client.MaxResponseBodySize) && (p.isStreamedHTML(contentType) || isStreamedDocument(contentType) ||
+		(!SanitizedContentTypeFilter(contentType) && !p.isProcessedImage(contentType)))
+}
+
+// serveDownloadPage shows the type and the size of an attachment before it is downloaded
+func (p *Proxy) serveDownloadPage(ctx *fasthttp.RequestCtx, uri *url.URL, contentType contenttype.ContentType, size int64) {
+	ctx.SetContentType("text/html; charset=UTF-8")
+	msg := Catalog[p.requestLanguage(ctx)]
+	s := &sanitize.Sanitizer{Key: p.Key}
+	param := HTMLDownloadPageParam{
+		PageParam:   p.pageParam(),
+		ContentType: contentType.TopLevelType + "/" + contentType.SubType,
+		Size:        msg.FileUnknown,
+		DownloadURL: s.ProxifiedURL(uri, "") + "&mortydownload=1",
+		Msg:         msg,
+	}
+	if size >= 0 {
+		param.Size = formatSize(size)
+	}
+	param.URL, param.Homograph = displayURL(uri)
+	if err := p.Templates.DownloadPage.Execute(ctx, param); err != nil {
+		p.Logger.Error("failed to render the download page", "error", err)
+	}
+}
+
+// formatSize returns a number of bytes in B, KiB, MiB or GiB
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, unit := float64(size)/1024, "KiB"
+	for _, next := range []string{"MiB", "GiB"} {
+		if value < 1024 {
+			break
+		}
+		value, unit = value/1024, next
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestPreflightRequest(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/file.zip":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Length", "2048")
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(make([]byte, 2048))
+			}
+		case "/app.exe":
+			w.Header().Set("Content-Type", "application/x-msdownload")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><body><p>page</p></body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		path          string
+		download      bool
+		maxSize       int64
+		status        int
+		body          []string
+		head, get     int
+		contentLength int
+	}{
+		// the pages are not preflighted
+		{"/page", false, 0, 200, []string{"page"}, 0, 1, -1},
+		{"/file.zip", false, 0, 200, []string{Catalog["en"].FileDownload, "2.0 KiB", "mortydownload=1"}, 1, 0, -1},
+		{"/file.zip", true, 0, 200, nil, 1, 1, 2048},
+		{"/file.zip", false, 1024, 413, nil, 1, 0, -1},
+		{"/app.exe", false, 0, 403, nil, 1, 0, -1},
+	} {
+		requests = map[string]int{}
+		ctx := &fasthttp.RequestCtx{}
+		uri := "/?mortyurl=" + url.QueryEscape(server.URL+test.path)
+		if test.download {
+			uri += "&mortydownload=1"
+		}
+		ctx.Request.SetRequestURI(uri)
+		New(func(p *Proxy) {
+			p.HeadPreflight = true
+			p.AttachmentMaxSize = test.maxSize
+		}).RequestHandler(ctx)
+
+		if ctx.Response.StatusCode() != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, ctx.Response.StatusCode())
+		}
+		body := string(ctx.Response.Body())
+		for _, expected := range test.body {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: expected %q in %s", test.path, expected, body)
+			}
+		}
+		if test.contentLength != -1 && len(ctx.Response.Body()) != test.contentLength {
+			t.Errorf("%s: expected a %d bytes body, got %d", test.path, test.contentLength, len(ctx.Response.Body()))
+		}
+		mu.Lock()
+		if requests["HEAD "+test.path] != test.head || requests["GET "+test.path] != test.get {
+			t.Errorf("%s: expected %d HEAD and %d GET requests, got %v", test.path, test.head, test.get, requests)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, test := range []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{10 << 20, "10.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	} {
+		if got := formatSize(test.size); got != test.expected {
+			t.Errorf("formatSize(%d): expected %q, got %q", test.size, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,1564 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/friedemannsommer/morty/cache"
+	"github.com/friedemannsommer/morty/contenttype"
+	"github.com/friedemannsommer/morty/logging"
+	"github.com/friedemannsommer/morty/qrcode"
+	"github.com/friedemannsommer/morty/sanitize"
+)
+
+const (
+	DefaultSchemeHTTPS      = "https"
+	DefaultSchemeHTTP       = "http"
+	DefaultSchemeHTTPSFirst = "https-first"
+)
+
+const (
+	SanitizerModeStream = "stream"
+	SanitizerModeTree   = "tree"
+)
+
+const VERSION = "v0.2.1"
+
+// methods which can be forwarded to upstream servers, GET is always allowed
+var SupportedMethods = []string{
+	fasthttp.MethodGet,
+	fasthttp.MethodHead,
+	fasthttp.MethodPost,
+	fasthttp.MethodPut,
+	fasthttp.MethodPatch,
+	fasthttp.MethodDelete,
+}
+
+const MaxRedirectCount = 5
+
+const HTMLCacheMaxSize = 64 * 1024 * 1024 // 64M
+
+// pixels per module of the QR code on the exit page
+const QRCodeScale = 4
+
+var UserAgent = []byte("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:96.0) Gecko/20100101 Firefox/96.0")
+
+// NewClient returns the default upstream client of a Proxy
+func NewClient() *fasthttp.Client {
+	return &fasthttp.Client{
+		MaxResponseBodySize: 10 * 1024 * 1024, // 10M
+		ReadBufferSize:      16 * 1024,        // 16K
+	}
+}
+
+var AllowedContentTypeFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	// html
+	contenttype.NewFilterEquals("text", "html", ""),
+	contenttype.NewFilterEquals("application", "xhtml", "xml"),
+	// css
+	contenttype.NewFilterEquals("text", "css", ""),
+	// feeds
+	contenttype.NewFilterEquals("application", "rss", "xml"),
+	contenttype.NewFilterEquals("application", "atom", "xml"),
+	// images
+	sanitize.ImageContentTypeFilter,
+	// fonts
+	contenttype.NewFilterEquals("application", "font-otf", ""),
+	contenttype.NewFilterEquals("application", "font-ttf", ""),
+	contenttype.NewFilterEquals("application", "font-woff", ""),
+	contenttype.NewFilterEquals("application", "vnd.ms-fontobject", ""),
+	contenttype.NewFilterEquals("font", "otf", ""),
+	contenttype.NewFilterEquals("font", "ttf", ""),
+	contenttype.NewFilterEquals("font", "woff", ""),
+	contenttype.NewFilterEquals("font", "woff2", ""),
+})
+
+var AllowedContentTypeMediaFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	// audio
+	contenttype.NewFilterEquals("audio", "aac", ""),
+	contenttype.NewFilterEquals("audio", "flac", ""),
+	contenttype.NewFilterEquals("audio", "mp4", ""),
+	contenttype.NewFilterEquals("audio", "mpeg", ""),
+	contenttype.NewFilterEquals("audio", "ogg", ""),
+	contenttype.NewFilterEquals("audio", "wav", ""),
+	contenttype.NewFilterEquals("audio", "webm", ""),
+	// video
+	contenttype.NewFilterEquals("video", "mp4", ""),
+	contenttype.NewFilterEquals("video", "ogg", ""),
+	contenttype.NewFilterEquals("video", "webm", ""),
+	// subtitles
+	contenttype.NewFilterEquals("text", "vtt", ""),
+})
+
+var AllowedContentTypeAttachmentFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	// texts
+	contenttype.NewFilterEquals("text", "csv", ""),
+	contenttype.NewFilterEquals("text", "tab-separated-values", ""),
+	contenttype.NewFilterEquals("text", "plain", ""),
+	// API
+	contenttype.NewFilterEquals("application", "json", ""),
+	// Documents
+	contenttype.NewFilterEquals("application", "x-latex", ""),
+	contenttype.NewFilterEquals("application", "pdf", ""),
+	contenttype.NewFilterEquals("application", "vnd.oasis.opendocument.text", ""),
+	contenttype.NewFilterEquals("application", "vnd.oasis.opendocument.spreadsheet", ""),
+	contenttype.NewFilterEquals("application", "vnd.oasis.opendocument.presentation", ""),
+	contenttype.NewFilterEquals("application", "vnd.oasis.opendocument.graphics", ""),
+	// Compressed archives
+	contenttype.NewFilterEquals("application", "zip", ""),
+	contenttype.NewFilterEquals("application", "gzip", ""),
+	contenttype.NewFilterEquals("application", "x-compressed", ""),
+	contenttype.NewFilterEquals("application", "x-gtar", ""),
+	contenttype.NewFilterEquals("application", "x-compress", ""),
+	// Generic binary
+	contenttype.NewFilterEquals("application", "octet-stream", ""),
+})
+
+// content types which never end, they can't be buffered
+var StreamingContentTypeFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	contenttype.NewFilterEquals("multipart", "x-mixed-replace", ""),
+	contenttype.NewFilterEquals("text", "event-stream", ""),
+})
+
+var AllowedContentTypeParameters = map[string]bool{
+	"charset": true,
+}
+
+type Proxy struct {
+	Key            []byte
+	RequestTimeout time.Duration
+	FollowRedirect bool
+	ProxyMedia     bool
+	HeadPreflight  bool
+	IframePolicy   string
+	StructuredData string
+	TargetPolicy   string
+	HTMLCache      *cache.Cache
+	DefaultScheme  string
+	SearchURL      string
+	StrictHeaders  bool
+	SanitizerMode  string
+	TextOnly       bool
+	NoHeader       bool
+	Minify         bool
+	ImagePolicy    string
+	ImageMaxSize   int
+	// nil allows every method
+	AllowedMethods map[string]bool
+	// empty disables the purge endpoint
+	AdminToken []byte
+	// reuse the upstream connections
+	KeepAlive bool
+	// nil disables the statistics endpoint
+	Stats *Stats
+	// the .onion hosts are fetched through the Tor proxy instead of showing the exit page
+	Onion bool
+	// Referer sent for the resources of a page: none, origin or full
+	RefererPolicy string
+	// keep the data-* attributes
+	DataAttributes bool
+	// <link rel> values allowed in addition to the built-in ones
+	LinkRels map[string]bool
+	// query parameters ignored to detect the links to the current page, the built-in ones if nil
+	TrackingParams map[string]bool
+	// response headers relayed for images, fonts and stylesheets
+	CacheHeaders []string
+	// URI schemes of the links kept as direct links (ie: mailto), nil if none
+	DirectSchemes map[string]bool
+	// reverse proxies whose X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix headers are used for the absolute morty URLs
+	TrustedProxies []*net.IPNet
+	// followed redirects from https to http: allow, warn or block, allowed if empty
+	DowngradeRedirects string
+	// EventSource requests: block, or relay the event streams for EventStreamTimeout, blocked if empty
+	EventStreams       string
+	EventStreamTimeout time.Duration
+	// AMP pages: keep, redirect to their canonical page or rewrite them to it, kept if empty
+	AMPPolicy string
+	// URL of the archived copies of the pages, %s is replaced by the URL of the page, no archive if empty
+	ArchiveURL string
+	// pages whose origin is dead (404, 410 or timeout): offer or fetch their archived copy
+	ArchiveFallback string
+	// remove the tracking pixels: 1x1 images and the images of the trackers
+	DropTrackingPixels bool
+	// hosts of the ad and tracker blocklists, their subresources are removed, nil if none
+	Blocklist *Blocklist
+	// custom rules of the sanitizers, see sanitize.Sanitizer
+	ElementHooks   []sanitize.ElementHook
+	AttributeHooks []sanitize.AttributeHook
+	URLHooks       []sanitize.URLHook
+	// content types downloaded as attachments in addition to AllowedContentTypeAttachmentFilter, nil if none
+	AttachmentContentTypes contenttype.Filter
+	// size in bytes above which the attachments are rejected by the HEAD preflight, 0 if unlimited
+	AttachmentMaxSize int64
+	// size in bytes above which the streamed HTML documents, stylesheets and subtitles are cut, 0 if unlimited
+	DocumentMaxSize int64
+	// title of the pages, DefaultInstanceName if empty
+	InstanceName string
+	// appearance of the injected header
+	HeaderTheme HeaderTheme
+	// status code of the main page without the URL form (404 or 403), 0 serves the form
+	Headless int
+	// /favicon.ico, the built-in icon set by New, and /robots.txt, DefaultRobotsTxt if nil
+	Favicon            []byte
+	FaviconContentType string
+	RobotsTxt          []byte
+	// upstream client, the responses too large for it are downloaded by StreamClient: New sets it to a client
+	// using the dialer and the TLS configuration of Client
+	Client       *fasthttp.Client
+	StreamClient *http.Client
+	// templates of the injected header, the form fields and the pages, see DefaultTemplates and LoadTemplates
+	Templates *Templates
+	// language of the pages when none of the accepted languages of the client is in the Catalog
+	Language string
+	Logger   *logging.Logger
+}
+
+type HTMLBodyExtParam struct {
+	BaseURL string
+	// signed morty URL of the page, to share it
+	Permalink string
+	// URL of the page whose archived copy is shown, empty if the page is not an archived copy
+	ArchivedURL string
+	HasMortyKey bool
+	TextOnly    bool
+	Theme       HeaderTheme
+	Msg         *Messages
+}
+
+type HTMLFormExtParam struct {
+	BaseURL   string
+	MortyHash string
+	TextOnly  bool
+}
+
+// PageParam holds the variables of the page layout, shared by the main, exit and blocked pages
+type PageParam struct {
+	Version      string
+	InstanceName string
+	HasMortyKey  bool
+}
+
+type HTMLMainPageParam struct {
+	PageParam
+	Error string
+	// morty URL of the archived copy of the page whose origin is dead, empty if none
+	ArchiveURL string
+	Msg        *Messages
+}
+
+// HTMLErrorPageParam is the data of the error page templates
+type HTMLErrorPageParam struct {
+	PageParam
+	Status int
+	// HTTP status text
+	Reason string
+	Error  string
+	// X-Request-Id header of the request, or the fasthttp request ID
+	RequestID string
+	// mortyurl parameter of the request, with its host name in Unicode
+	URL string
+	// the host name of URL may imitate another one
+	Homograph bool
+	// morty URL of the archived copy of URL if its origin is dead, empty if none
+	ArchiveURL string
+	Msg        *Messages
+}
+
+type HTMLExitPageParam struct {
+	PageParam
+	// empty if the scheme of the URL is unsafe: the URL is only displayed
+	URL template.URL
+	// URL with its host name in Unicode, Homograph if the host name may imitate another one
+	DisplayURL string
+	Homograph  bool
+	// PNG data URI of the QR code of URL, empty if the URL is too long
+	QRCode template.URL
+	Msg    *Messages
+}
+
+type HTMLBlockedPageParam struct {
+	PageParam
+	// the host name is in Unicode
+	URL         string
+	Homograph   bool
+	ContentType string
+	// morty URL of the exit page of URL
+	ExitURL string
+	Msg     *Messages
+}
+
+// HTMLDowngradePageParam is the data of the page shown before following a redirect from https to http
+type HTMLDowngradePageParam struct {
+	PageParam
+	URL string
+	// morty URL of URL
+	NextURL string
+	Msg     *Messages
+}
+
+// HTMLDownloadPageParam is the data of the page shown before downloading an attachment
+type HTMLDownloadPageParam struct {
+	PageParam
+	// the host name is in Unicode
+	URL         string
+	Homograph   bool
+	ContentType string
+	Size        string
+	// morty URL of URL with the "mortydownload" parameter
+	DownloadURL string
+	Msg         *Messages
+}
+
+// machine-readable details of a blocked resource
+type BlockedContent struct {
+	Error       string `json:"error"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+}
+
+// head of the sanitized pages when the Referer is forwarded: they send their morty URL to morty only
+var HtmlHeadSameOriginReferrer = strings.Replace(sanitize.DefaultHead, "no-referrer", "same-origin", 1)
+
+// DefaultInstanceName is the title of the pages of morty
+const DefaultInstanceName = "MortyProxy"
+
+// MortyHtmlPageStart and MortyHtmlPageEnd surround the content of the built-in page layout
+var MortyHtmlPageStart = `<!doctype html>
+<html>
+<head>
+<title>{{.InstanceName}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1 , maximum-scale=1.0, user-scalable=1" />
+<style>
+html { height: 100%; }
+body { min-height : 100%; display: flex; flex-direction:column; font-family: 'Garamond', 'Georgia', serif; text-align: center; color: #444; background: #FAFAFA; margin: 0; padding: 0; font-size: 1.1em; }
+input { border: 1px solid #888; padding: 0.3em; color: #444; background: #FFF; font-size: 1.1em; }
+input[placeholder] { width:80%; }
+a { text-decoration: none; #2980b9; }
+h1, h2 { font-weight: 200; margin-bottom: 2rem; }
+h1 { font-size: 3em; }
+.container { flex:1; min-height: 100%; margin-bottom: 1em; }
+.footer { margin: 1em; }
+.footer p { font-size: 0.8em; }
+</style>
+</head>
+<body>
+	<div class="container">
+		<h1>{{.InstanceName}}</h1>
+`
+
+var MortyHtmlPageEnd = `
+	</div>
+	<div class="footer">
+		<p>{{.Msg.Footer}}<br />
+		<a href="https://github.com/friedemannsommer/morty">{{.Msg.ViewSource}}</a>
+		</p>
+	</div>
+</body>
+</html>`
+
+// the content of the pages rendered in the layout
+var (
+	mainPageContent = `{{if .Error}}<h2>{{.Msg.Error}}: {{.Error}}</h2>{{end}}
+{{if .ArchiveURL}}<p><a href="{{.ArchiveURL}}">{{.Msg.ArchiveOffer}}</a></p>{{end}}
+{{if .HasMortyKey}}<h3>{{.Msg.NoDirectURL}}</h3>{{else}}
+		<form action="post">
+		{{.Msg.VisitURL}}: <input placeholder="https://url.." name="mortyurl" autofocus />
+		<input type="submit" value="{{.Msg.Go}}" />
+		</form>{{end}}`
+	exitPageContent = `<h2>{{.Msg.ExitTitle}}</h2>
+<p>{{.Msg.ExitFollow}}</p><p>{{if .URL}}<a href="{{.URL}}" rel="noreferrer">{{.DisplayURL}}</a>{{else}}<code>{{.DisplayURL}}</code>{{end}}</p><p>{{.Msg.ExitWarning}}</p>
+{{if .Homograph}}<p><b>{{.Msg.Homograph}}</b></p>{{end}}
+{{if .QRCode}}<p><img src="{{.QRCode}}" alt="{{.Msg.ExitQRCode}}" title="{{.Msg.ExitQRCode}}" /></p>{{end}}`
+	blockedPageContent = `<h2>{{.Msg.BlockedTitle}}</h2>
+<p>{{.Msg.BlockedType}}: <code>{{.ContentType}}</code></p><p>{{.URL}}</p>{{if .Homograph}}<p><b>{{.Msg.Homograph}}</b></p>{{end}}
+<p><a href="{{.ExitURL}}">{{.Msg.BlockedOpen}}</a></p>`
+	downgradePageContent = `<h2>{{.Msg.HTTPTitle}}</h2>
+<p>{{.Msg.HTTPWarning}}</p><p>{{.URL}}</p><p><a href="{{.NextURL}}">{{.Msg.HTTPContinue}}</a></p>`
+	downloadPageContent = `<h2>{{.Msg.FileTitle}}</h2>
+<p>{{.URL}}</p>{{if .Homograph}}<p><b>{{.Msg.Homograph}}</b></p>{{end}}
+<p>{{.Msg.FileType}}: <code>{{.ContentType}}</code><br />{{.Msg.FileSize}}: {{.Size}}</p>
+<p><a href="{{.DownloadURL}}">{{.Msg.FileDownload}}</a></p>`
+)
+
+// built-in /favicon.ico
+const faviconBase64 = "iVBORw0KGgoAAAANSUhEUgAAABAAAAAQEAYAAABPYyMiAAAABmJLR0T///////8JWPfcAAAACXBIWXMAAABIAAAASABGyWs+AAAAF0lEQVRIx2NgGAWjYBSMglEwCkbBSAcACBAAAeaR9cIAAAAASUVORK5CYII"
+
+// path of the health check endpoint, see the healthcheck command
+const HealthPath = "/healthz"
+
+// robots.txt served when Proxy.RobotsTxt is nil
+const DefaultRobotsTxt = "User-Agent: *\nDisallow: /\n"
+
+func init() {
+	var err error
+	builtinTemplates.FormExtension, err = template.New("html_form_extension").Parse(
+		`<input type="hidden" name="mortyurl" value="{{.BaseURL}}" />{{if .MortyHash}}<input type="hidden" name="mortyhash" value="{{.MortyHash}}" />{{end}}{{if .TextOnly}}<input type="hidden" name="mortytext" value="1" />{{end}}`)
+
+	if err != nil {
+		panic(err)
+	}
+	builtinTemplates.BodyExtension, err = template.New("html_body_extension").Parse(`
+<input type="checkbox" id="mortytoggle" autocomplete="off" />
+<div id="mortyheader">
+  <form method="get">
+    <label for="mortytoggle">{{.Msg.Hide}}</label>
+    <span><a href="/">Morty Proxy</a></span>
+    <input type="url" value="{{.BaseURL}}" name="mortyurl" {{if .HasMortyKey }}readonly="true"{{end}} />
+    {{if .TextOnly}}<input type="hidden" name="mortytext" value="1" />{{end}}
+    {{.Msg.BannerStart}} <a href="https://github.com/friedemannsommer/morty">{{.Msg.BannerLink}}</a> {{.Msg.BannerEnd}} <a href="{{.BaseURL}}" rel="noreferrer">{{.Msg.OriginalSite}}</a>.
+    {{if .Permalink}}<a href="{{.Permalink}}" rel="nofollow" title="{{.Msg.ShareHint}}">{{.Msg.Permalink}}</a>{{end}}
+    {{if .ArchivedURL}}<b>{{.Msg.Archived}} {{.ArchivedURL}}</b>{{end}}
+  </form>
+</div>
+<style>
+html { scroll-padding-{{.Theme.Position}}: {{.Theme.Height}}px !important; }
+{{if eq .Theme.Position "bottom"}}body{ position: absolute !important; top: 0 !important; left: 0 !important; right: 0 !important; bottom: {{.Theme.Height}}px !important; }
+{{else}}body{ position: absolute !important; top: {{.Theme.Height}}px !important; left: 0 !important; right: 0 !important; bottom: 0 !important; }
+{{end}}#mortyheader { position: fixed; margin: 0; box-sizing: border-box; -webkit-box-sizing: border-box; {{.Theme.Position}}: 0; left: 0; right: 0; z-index: 2147483647 !important; font-size: 12px; line-height: normal; border-width: {{if eq .Theme.Position "bottom"}}2px 0px 0px 0{{else}}0px 0px 2px 0{{end}}; border-style: solid; border-color: {{.Theme.Border}}; background: {{.Theme.Background}}; padding: 4px; color: {{.Theme.Text}}; height: {{.Theme.Height}}px; }
+#mortyheader * { padding: 0; margin: 0; }
+#mortyheader p { padding: 0 0 0.7em 0; display: block; }
+#mortyheader a { color: {{.Theme.Link}}; font-weight: bold; display: inline; }
+#mortyheader label { text-align: right; cursor: pointer; position: fixed; right: 4px; {{.Theme.Position}}: 4px; display: block; color: {{.Theme.Text}}; }
+#mortyheader > form > span { font-size: 24px; font-weight: bold; margin-right: 20px; margin-left: 20px; }
+input[type=checkbox]#mortytoggle { display: none; }
+input[type=checkbox]#mortytoggle:checked ~ div { display: none; visibility: hidden; }
+#mortyheader input[type=url] { width: 50%; padding: 4px; font-size: 16px; }
+{{if .Theme.Compact}}#mortyheader { padding: 2px; font-size: 10px; white-space: nowrap; overflow: hidden; }
+#mortyheader label { {{.Theme.Position}}: 2px; }
+#mortyheader > form > span { font-size: 14px; margin-right: 10px; margin-left: 0; }
+#mortyheader input[type=url] { width: 40%; padding: 1px; font-size: 12px; }
+{{end}}</style>
+`)
+	if err != nil {
+		panic(err)
+	}
+	layout, err := template.New("html_page").Parse(MortyHtmlPageStart + `{{template "content" .}}` + MortyHtmlPageEnd)
+	if err != nil {
+		panic(err)
+	}
+	if err := parsePages(layout, builtinTemplates); err != nil {
+		panic(err)
+	}
+}
+
+// parsePages renders the content of the main, exit and blocked pages of t in layout,
+// the layout includes the content with {{template "content" .}}
+func parsePages(layout *template.Template, t *Templates) error {
+	pages := []struct {
+		Content  string
+		Template **template.Template
+	}{
+		{mainPageContent, &t.MainPage},
+		{exitPageContent, &t.ExitPage},
+		{blockedPageContent, &t.BlockedPage},
+		{downgradePageContent, &t.DowngradePage},
+		{downloadPageContent, &t.DownloadPage},
+	}
+	for _, page := range pages {
+		parsed, err := layout.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := parsed.New("content").Parse(page.Content); err != nil {
+			return err
+		}
+		*page.Template = parsed
+	}
+	return nil
+}
+
+func (p *Proxy) RequestHandler(ctx *fasthttp.RequestCtx) {
+	if p.Logger.Enabled(logging.LevelDebug) {
+		start := time.Now()
+		defer func() {
+			p.Logger.Debug("request", "method", string(ctx.Method()), "status", ctx.Response.StatusCode(), "duration", time.Since(start))
+		}()
+	}
+
+	if p.appRequestHandler(ctx) {
+		return
+	}
+
+	// the admin endpoints only exist when a token is configured
+	if len(p.AdminToken) > 0 {
+		switch string(ctx.Path()) {
+		case PurgePath:
+			p.servePurge(ctx)
+			return
+		case StatsPath:
+			if p.Stats != nil {
+				p.serveStats(ctx)
+				return
+			}
+		case ProxifyPath:
+			p.serveProxify(ctx)
+			return
+		case SignPath:
+			p.serveSign(ctx)
+			return
+		}
+	}
+
+	requestHash := popRequestParam(ctx, []byte("mortyhash"))
+	requestURI := popRequestParam(ctx, []byte("mortyurl"))
+	if requestURI != nil {
+		ctx.SetUserValue("mortyurl", string(requestURI))
+	}
+	requestExpires := popRequestParam(ctx, []byte("mortyexpires"))
+	if popRequestParam(ctx, []byte("mortytext")) != nil {
+		ctx.SetUserValue("mortytext", true)
+	}
+	if popRequestParam(ctx, []byte("mortydownload")) != nil {
+		ctx.SetUserValue("mortydownload", true)
+	}
+	getSubmission := popRequestParam(ctx, []byte("mortyget")) != nil && ctx.IsPost()
+	exit := popRequestParam(ctx, []byte("mortyexit")) != nil
+
+	if requestURI == nil {
+		p.serveMainPage(ctx, 200, nil)
+		return
+	}
+
+	if isWebSocketRequest(ctx) {
+		p.serveStreamRejection(ctx, "WebSocket connections are not supported")
+		return
+	}
+	if p.EventStreams != EventStreamsRelay && isEventStreamRequest(ctx) {
+		p.serveStreamRejection(ctx, "event streams are not supported")
+		return
+	}
+
+	method := string(ctx.Method())
+	if getSubmission {
+		method = fasthttp.MethodGet
+	}
+	if p.AllowedMethods != nil && !p.AllowedMethods[method] {
+		ctx.Response.Header.Set("Allow", allowHeader(p.AllowedMethods))
+		// HTTP status code 405 : Method Not Allowed
+		p.serveMainPage(ctx, 405, errors.New("method not allowed: "+method))
+		return
+	}
+
+	var expires int64
+	if requestExpires != nil {
+		var err error
+		if expires, err = strconv.ParseInt(string(requestExpires), 10, 64); err != nil || expires <= 0 {
+			// HTTP status code 400 : Bad Request
+			p.serveMainPage(ctx, 400, errors.New(`invalid "mortyexpires" parameter`))
+			return
+		}
+	}
+
+	if p.Key != nil {
+		if !sanitize.VerifyURI([]byte(sanitize.ExpiringURI(string(requestURI), expires)), requestHash, p.Key) {
+			// HTTP status code 403 : Forbidden
+			p.serveMainPage(ctx, 403, errors.New(`invalid "mortyhash" parameter`))
+			return
+		}
+	}
+	if expires != 0 && time.Now().Unix() > expires {
+		// HTTP status code 410 : Gone
+		p.serveMainPage(ctx, 410, errors.New("expired URL"))
+		return
+	}
+
+	// link of the blocked page to the original resource
+	if exit {
+		parsedURI, err := url.Parse(string(requestURI))
+		if err != nil {
+			// HTTP status code 400 : Bad Request
+			p.serveMainPage(ctx, 400, err)
+			return
+		}
+		p.serveExitMortyPage(ctx, parsedURI)
+		return
+	}
+
+	if isSearchTerm(string(requestURI)) {
+		if p.SearchURL == "" {
+			// HTTP status code 400 : Bad Request
+			p.serveMainPage(ctx, 400, errors.New("invalid URL: "+string(requestURI)))
+		} else {
+			searchURL := strings.Replace(p.SearchURL, "%s", url.QueryEscape(strings.TrimSpace(string(requestURI))), 1)
+			p.ProcessUri(ctx, searchURL, 0)
+		}
+		return
+	}
+
+	// the remaining parameters are the fields of a submitted GET form
+	requestURI = appendQueryString(requestURI, withoutMortyParams(ctx.URI().QueryString()))
+
+	// GET submission of a button with a formaction: see sanitizeSubmitterAttrs
+	if getSubmission {
+		if isURLEncodedRequest(ctx) {
+			requestURI = appendQueryString(requestURI, withoutMortyParams(ctx.PostBody()))
+		}
+		ctx.Request.Header.SetMethod(fasthttp.MethodGet)
+		ctx.Request.ResetBody()
+	}
+
+	p.ProcessUri(ctx, string(requestURI), 0)
+}
+
+func (p *Proxy) ProcessUri(ctx *fasthttp.RequestCtx, requestURIStr string, redirectCount int) {
+	parsedURI, err := url.Parse(requestURIStr)
+
+	if err != nil {
+		// HTTP status code 500 : Internal Server Error
+		p.serveMainPage(ctx, 500, err)
+		return
+	}
+
+	httpFallback := false
+	if parsedURI.Scheme == "" {
+		if p.DefaultScheme == DefaultSchemeHTTP {
+			requestURIStr = "http://" + requestURIStr
+		} else {
+			httpFallback = p.DefaultScheme == DefaultSchemeHTTPSFirst
+			requestURIStr = "https://" + requestURIStr
+		}
+		parsedURI, err = url.Parse(requestURIStr)
+		if err != nil {
+			p.serveMainPage(ctx, 500, err)
+			return
+		}
+	}
+
+	// the internationalized host names are sent in punycode
+	if punycodeURI := sanitize.PunycodeHost(parsedURI); punycodeURI != parsedURI {
+		parsedURI = punycodeURI
+		requestURIStr = parsedURI.String()
+	}
+
+	// Serve an intermediate page for protocols other than HTTP(S)
+	if (parsedURI.Scheme != "http" && parsedURI.Scheme != "https") || (!p.Onion && isOnionHost(parsedURI.Hostname())) {
+		p.serveExitMortyPage(ctx, parsedURI)
+		return
+	}
+
+	// an event stream never ends, it can't be buffered
+	stream := p.EventStreams == EventStreamsRelay && isEventStreamRequest(ctx)
+	if p.HeadPreflight && ctx.IsGet() && !stream && isLikelyAttachment(parsedURI) {
+		var proceed bool
+		if proceed, stream = p.preflightRequest(ctx, requestURIStr, parsedURI); !proceed {
+			return
+		}
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	if !p.KeepAlive {
+		req.SetConnectionClose()
+	}
+
+	p.Logger.Debug("upstream request", "method", string(ctx.Method()), "url", requestURIStr)
+
+	req.SetRequestURI(requestURIStr)
+	req.Header.SetUserAgentBytes(UserAgent)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethodBytes(ctx.Method())
+	if ctx.IsPost() || ctx.IsPut() || ctx.IsPatch() || ctx.IsDelete() {
+		if err := copyRequestBody(ctx, req); err != nil {
+			// HTTP status code 400 : Bad Request
+			p.serveMainPage(ctx, 400, err)
+			return
+		}
+	}
+
+	textOnlyParam := ctx.UserValue("mortytext") != nil
+	lang := p.requestLanguage(ctx)
+
+	// revalidate the cached sanitized document, if there is one
+	var cacheKey string
+	var cachedEntry *cache.Entry
+	if p.HTMLCache != nil && ctx.IsGet() {
+		cacheKey = p.htmlCacheKey(requestURIStr, textOnlyParam, lang)
+		cachedEntry = p.HTMLCache.Get(cacheKey)
+		if cachedEntry != nil {
+			if cachedEntry.ETag != "" {
+				req.Header.Set("If-None-Match", cachedEntry.ETag)
+			}
+			if cachedEntry.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cachedEntry.LastModified)
+			}
+		}
+	}
+	forwardAcceptHeader(ctx, req)
+	if p.RefererPolicy == RefererPolicyOrigin || p.RefererPolicy == RefererPolicyFull {
+		forwardReferer(ctx, req, p.RefererPolicy, p.Key)
+	}
+	if ctx.IsGet() && cachedEntry == nil {
+		forwardRangeHeaders(ctx, req)
+		forwardConditionalHeaders(ctx, req)
+	}
+	// the byte ranges refer to the uncompressed content
+	if len(req.Header.Peek("Range")) == 0 {
+		req.Header.Set("Accept-Encoding", AcceptedContentEncodings)
+	}
+
+	scrubRequestHeaders(req, p.StrictHeaders)
+
+	if stream {
+		p.streamResponse(ctx, req, parsedURI)
+		return
+	}
+
+	if p.streamsDocuments(ctx) {
+		var streamed bool
+		if streamed, err = p.fetchDocument(ctx, req, resp, parsedURI); streamed {
+			return
+		}
+	} else {
+		err = p.Client.DoTimeout(req, resp, p.RequestTimeout)
+		p.Stats.Host(parsedURI.Hostname()).Record(err != nil || resp.StatusCode() >= 400, len(resp.Body()))
+	}
+
+	if err != nil {
+		if httpFallback {
+			p.Logger.Debug("fall back to http", "error", err)
+			p.ProcessUri(ctx, "http://"+strings.TrimPrefix(requestURIStr, "https://"), redirectCount)
+			return
+		}
+		if isStreamingResponse(resp) {
+			// the response headers are available even if the body exceeded the size limit or timed out
+			// HTTP status code 501 : Not Implemented
+			p.serveMainPage(ctx, 501, errors.New("streaming content is not supported "+parsedURI.String()))
+		} else if err == fasthttp.ErrTimeout {
+			if p.fetchArchivedCopy(ctx, parsedURI, 504, redirectCount) {
+				return
+			}
+			// HTTP status code 504 : Gateway Time-Out
+			p.serveMainPage(ctx, 504, err)
+		} else if err == fasthttp.ErrBodyTooLarge && (ctx.IsGet() || ctx.IsHead()) {
+			// the request is sent again to stream the response, only the safe methods are replayed
+			p.streamResponse(ctx, req, parsedURI)
+		} else if err == fasthttp.ErrBodyTooLarge {
+			// HTTP status code 413 : Payload Too Large
+			p.serveMainPage(ctx, 413, errors.New("document too large "+parsedURI.String()))
+		} else if isTLSPolicyError(err) {
+			// HTTP status code 502 : Bad Gateway
+			p.serveMainPage(ctx, 502, tlsPolicyError(err))
+		} else {
+			// HTTP status code 500 : Internal Server Error
+			p.serveMainPage(ctx, 500, err)
+		}
+		return
+	}
+
+	if cachedEntry != nil && (resp.StatusCode() == 304 ||
+		(resp.StatusCode() == 200 && cachedEntry.Matches(string(resp.Header.Peek("ETag")), string(resp.Header.Peek("Last-Modified"))))) {
+		p.Logger.Debug("serve sanitized document from cache", "url", requestURIStr)
+		ctx.SetContentType(cachedEntry.ContentType)
+		_, _ = ctx.Write(cachedEntry.Body)
+		return
+	}
+
+	if resp.StatusCode() == 304 {
+		// the client has sent the validators: its copy is still valid,
+		// the content type is unknown so the modification date is not relayed
+		p.relayCacheHeaders(ctx, resp.Header.Peek, true)
+		ctx.SetStatusCode(304)
+		return
+	}
+
+	if !isRelayedStatus(resp.StatusCode()) {
+		switch resp.StatusCode() {
+		case 301, 302, 303, 307, 308:
+			loc := resp.Header.Peek("Location")
+			if target, err := redirectLocation(parsedURI, loc); err == nil {
+				if (p.FollowRedirect || ctx.UserValue("mortyarchive") != nil) && ctx.IsGet() {
+					// GET method: Morty follows the redirect
+					if p.DowngradeRedirects != "" && p.DowngradeRedirects != DowngradeRedirectsAllow && isDowngradeRedirect(parsedURI, target) {
+						p.serveDowngradeRedirect(ctx, target)
+					} else if isRedirectCycle(ctx, parsedURI, target) {
+						p.serveMainPage(ctx, 310, errors.New("redirect loop"))
+					} else if redirectCount < MaxRedirectCount {
+						p.Logger.Debug("follow redirect", "location", target.String())
+						p.ProcessUri(ctx, target.String(), redirectCount+1)
+					} else {
+						p.serveMainPage(ctx, 310, errors.New("too many redirects"))
+					}
+					return
+				} else {
+					// Other HTTP methods: Morty does NOT follow the redirect
+					s := p.sanitizer(parsedURI, textOnlyParam)
+					ctx.SetStatusCode(resp.StatusCode())
+					p.setLocation(ctx, proxifiedLocation(s, target))
+					p.Logger.Debug("redirect", "location", target.String())
+					return
+				}
+			}
+		}
+		if p.fetchArchivedCopy(ctx, parsedURI, resp.StatusCode(), redirectCount) {
+			return
+		}
+		errorMessage := fmt.Sprintf("invalid response: %d (%s)", resp.StatusCode(), requestURIStr)
+		p.serveMainPage(ctx, resp.StatusCode(), errors.New(errorMessage))
+		return
+	}
+
+	if isNoContentStatus(resp.StatusCode()) {
+		// nothing to sanitize
+		ctx.SetStatusCode(resp.StatusCode())
+		return
+	}
+
+	// decompression, some servers compress the body even if it was not requested
+	responseBody, err := decodeResponseBody(resp.Body(), resp.Header.Peek("Content-Encoding"), p.Client.MaxResponseBodySize)
+	if err == fasthttp.ErrBodyTooLarge {
+		// HTTP status code 413 : Payload Too Large
+		p.serveMainPage(ctx, 413, errors.New("document too large "+parsedURI.String()))
+		return
+	} else if err != nil {
+		// HTTP status code 503 : Service Unavailable
+		p.serveMainPage(ctx, 503, err)
+		return
+	}
+
+	// fasthttp defaults to text/plain when the header is missing
+	resp.Header.SetNoDefaultContentType(true)
+	// decode Content-Type header, the content type of a missing or invalid header is sniffed
+	contentType, parseError := contenttype.ParseContentTypeHeader(string(resp.Header.Peek("Content-Type")))
+	contentTypeString := contentType.String()
+	if parseError != nil {
+		contentTypeString = sniffContentType(responseBody)
+		p.Logger.Debug("sniffed content type", "content_type", contentTypeString, "url", requestURIStr)
+		contentType, parseError = contenttype.ParseContentType(contentTypeString)
+	}
+	if parseError != nil {
+		// HTTP status code 503 : Service Unavailable
+		p.serveMainPage(ctx, 503, errors.New("invalid content type"))
+		return
+	}
+
+	if StreamingContentTypeFilter(contentType) {
+		// HTTP status code 501 : Not Implemented
+		p.serveMainPage(ctx, 501, errors.New("streaming content is not supported "+parsedURI.String()))
+		return
+	}
+
+	processedImage := p.isProcessedImage(contentType)
+
+	if resp.StatusCode() == 206 && (contentType.TopLevelType == "text" || SanitizedContentTypeFilter(contentType) || processedImage) {
+		if len(ctx.Request.Header.Peek("Range")) == 0 {
+			// HTTP status code 502 : Bad Gateway, the whole document has been requested
+			p.serveMainPage(ctx, 502, errors.New("unexpected partial content "+parsedURI.String()))
+			return
+		}
+		// a part of a document can't be converted nor sanitized: download the whole document, once
+		ctx.Request.Header.Del("Range")
+		p.ProcessUri(ctx, requestURIStr, redirectCount)
+		return
+	}
+
+	// content-disposition
+	contentDispositionBytes := ctx.Request.Header.Peek("Content-Disposition")
+
+	// check content type
+	if !AllowedContentTypeFilter(contentType) && !(p.ProxyMedia && AllowedContentTypeMediaFilter(contentType)) {
+		// it is not a usual content type
+		if p.isAttachment(contentType) {
+			// force attachment for allowed content type
+			contentDispositionBytes = contentDispositionForceAttachment(contentDispositionBytes, parsedURI)
+		} else {
+			// deny access to forbidden content type
+			p.serveBlockedPage(ctx, parsedURI, contentType)
+			return
+		}
+	}
+
+	// XHTML documents are sanitized like HTML documents, then serialized as XHTML
+	xhtml := contentType.SubType == "xhtml" && contentType.Suffix == "xml"
+	if xhtml && contentType.Parameters["charset"] == "" {
+		if xmlEncoding := xmlDeclEncoding(responseBody); xmlEncoding != "" {
+			contentTypeString = "application/xhtml+xml; charset=" + xmlEncoding
+		}
+	}
+
+	// conversion to UTF-8
+	if contentType.TopLevelType == "text" || xhtml {
+		responseBody, err = p.decodeDocument(responseBody, contentTypeString)
+		if err != nil {
+			// HTTP status code 503 : Service Unavailable
+			p.serveMainPage(ctx, 503, err)
+			return
+		}
+		// update the charset or specify it
+		contentType.Parameters["charset"] = "UTF-8"
+	}
+
+	// the AMP pages are replaced by their canonical page before they are sanitized
+	if p.AMPPolicy != "" && p.AMPPolicy != AMPPolicyKeep && ctx.IsGet() && resp.StatusCode() == 200 &&
+		contentType.SubType == "html" && contentType.Suffix == "" {
+		if p.serveCanonicalPage(ctx, parsedURI, responseBody, redirectCount) {
+			return
+		}
+	}
+
+	if processedImage {
+		responseBody, err = processImage(p.ImagePolicy, p.ImageMaxSize, contentType, responseBody)
+		if err != nil {
+			// HTTP status code 503 : Service Unavailable
+			p.serveMainPage(ctx, 503, fmt.Errorf("invalid image %s: %v", parsedURI.String(), err))
+			return
+		}
+	}
+
+	isFeed := (contentType.SubType == "rss" || contentType.SubType == "atom") && contentType.Suffix == "xml"
+	if isFeed {
+		// the feed is encoded in UTF-8 by sanitizeFeed
+		contentType.Parameters["charset"] = "UTF-8"
+	}
+
+	//
+	contentType.FilterParameters(AllowedContentTypeParameters)
+
+	// set the content type
+	ctx.SetContentType(contentType.String())
+	ctx.SetStatusCode(resp.StatusCode())
+	p.relayLocation(ctx, parsedURI, resp.StatusCode(), resp.Header.Peek("Location"))
+
+	if ConditionalContentTypeFilter(contentType) {
+		relayValidators(ctx, resp.Header.Peek("ETag"), resp.Header.Peek("Last-Modified"), contentType.SubType == "css" || processedImage)
+		p.relayCacheHeaders(ctx, resp.Header.Peek, contentType.SubType == "css" || processedImage)
+	}
+
+	// output according to MIME type
+	switch {
+	case contentType.SubType == "css" && contentType.Suffix == "":
+		s := p.sanitizer(parsedURI, textOnlyParam)
+		_ = s.CSS(ctx, bytes.NewReader(responseBody))
+	case contentType.SubType == "svg" && contentType.Suffix == "xml":
+		s := p.sanitizer(parsedURI, textOnlyParam)
+		svg := bytes.NewBuffer(make([]byte, 0, len(responseBody)))
+		if err := s.SVG(svg, bytes.NewReader(responseBody)); err != nil {
+			// HTTP status code 503 : Service Unavailable
+			p.serveMainPage(ctx, 503, err)
+			return
+		}
+		_, _ = ctx.Write(svg.Bytes())
+	case contentType.SubType == "vtt" && contentType.Suffix == "":
+		s := p.sanitizer(parsedURI, textOnlyParam)
+		_ = s.VTT(ctx, bytes.NewReader(responseBody))
+	case isFeed:
+		s := p.sanitizer(parsedURI, textOnlyParam)
+		mortyURL := p.externalURL(ctx)
+		feed := bytes.NewBuffer(make([]byte, 0, len(responseBody)))
+		if err := s.Feed(feed, bytes.NewReader(responseBody), mortyURL); err != nil {
+			// HTTP status code 503 : Service Unavailable
+			p.serveMainPage(ctx, 503, err)
+			return
+		}
+		_, _ = ctx.Write(feed.Bytes())
+	case (contentType.SubType == "html" && contentType.Suffix == "") || xhtml:
+		s := p.htmlSanitizer(ctx, parsedURI)
+		var out io.Writer = ctx
+		var cacheBuffer *bytes.Buffer
+		if cacheKey != "" && resp.StatusCode() == 200 && isCacheableResponse(resp) {
+			cacheBuffer = bytes.NewBuffer(make([]byte, 0, len(responseBody)))
+			out = cacheBuffer
+		}
+		if p.SanitizerMode == SanitizerModeTree {
+			if repairedBody, err := sanitize.RepairHTML(responseBody); err == nil {
+				responseBody = repairedBody
+			} else {
+				p.Logger.Warn("failed to repair HTML", "error", err)
+			}
+		}
+		if cacheBuffer == nil && !p.Minify && !xhtml && !ctx.IsHead() {
+			// the document is sent while it is sanitized: the body is detached from the response,
+			// which is released when ProcessUri returns
+			resp.SwapBody(nil)
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				stream := &clientStream{w: w, r: bytes.NewReader(responseBody)}
+				p.logSanitizeError(s.HTMLDocument(stream, stream))
+			})
+			return
+		}
+		sanitizedOut := out
+		var sanitized *bytes.Buffer
+		if p.Minify || xhtml {
+			sanitized = bytes.NewBuffer(make([]byte, 0, len(responseBody)))
+			sanitizedOut = sanitized
+		}
+		p.logSanitizeError(s.HTMLDocument(sanitizedOut, bytes.NewReader(responseBody)))
+		if xhtml {
+			// the minified HTML is not valid XML
+			if err := renderXHTML(out, sanitized.Bytes()); err != nil {
+				// HTTP status code 503 : Service Unavailable
+				p.serveMainPage(ctx, 503, err)
+				return
+			}
+		} else if sanitized != nil {
+			sanitize.MinifyHTML(out, sanitized.Bytes())
+		}
+		if cacheBuffer != nil {
+			p.HTMLCache.Set(cacheKey, &cache.Entry{
+				ContentType:  contentType.String(),
+				ETag:         string(resp.Header.Peek("ETag")),
+				LastModified: string(resp.Header.Peek("Last-Modified")),
+				Body:         cacheBuffer.Bytes(),
+			})
+			_, _ = ctx.Write(cacheBuffer.Bytes())
+		}
+	default:
+		if contentDispositionBytes != nil {
+			ctx.Response.Header.AddBytesV("Content-Disposition", contentDispositionBytes)
+		}
+		if acceptRanges := resp.Header.Peek("Accept-Ranges"); len(acceptRanges) > 0 {
+			ctx.Response.Header.SetBytesV("Accept-Ranges", acceptRanges)
+		}
+		if resp.StatusCode() == 206 {
+			ctx.Response.Header.SetBytesV("Content-Range", resp.Header.Peek("Content-Range"))
+		}
+		_, _ = ctx.Write(responseBody)
+	}
+}
+
+// isRelayedStatus reports whether an upstream status code is relayed with the content of the response:
+// the successful status codes and 300 Multiple Choices, whose content lists the choices
+func isRelayedStatus(statusCode int) bool {
+	return (statusCode >= 200 && statusCode < 300) || statusCode == 300
+}
+
+// isNoContentStatus reports whether an upstream response of a successful status has no content
+func isNoContentStatus(statusCode int) bool {
+	// HTTP status code 204 : No Content, 205 : Reset Content
+	return statusCode == 204 || statusCode == 205
+}
+
+// relayLocation sets the proxified Location header of the created resource (201) or of the preferred choice (300)
+func (p *Proxy) relayLocation(ctx *fasthttp.RequestCtx, baseURL *url.URL, statusCode int, location []byte) {
+	if (statusCode != 201 && statusCode != 300) || len(location) == 0 {
+		return
+	}
+	s := p.sanitizer(baseURL, ctx.UserValue("mortytext") != nil)
+	if proxyUri, err := s.ProxifyURI(location); err == nil && proxyUri != "" {
+		p.setLocation(ctx, proxyUri)
+	}
+}
+
+// sanitizer returns the sanitizer of a document with the policy of the proxy
+func (p *Proxy) sanitizer(baseURL *url.URL, textOnlyParam bool) *sanitize.Sanitizer {
+	s := &sanitize.Sanitizer{
+		Key:                p.Key,
+		BaseURL:            baseURL,
+		IframePolicy:       p.IframePolicy,
+		StructuredData:     p.StructuredData,
+		TargetPolicy:       p.TargetPolicy,
+		TextOnly:           p.TextOnly || textOnlyParam,
+		TextOnlyParam:      textOnlyParam,
+		NoHeader:           p.NoHeader,
+		DataAttributes:     p.DataAttributes,
+		DropTrackingPixels: p.DropTrackingPixels,
+		LinkRels:           p.LinkRels,
+		TrackingParameters: p.TrackingParams,
+		DirectSchemes:      p.DirectSchemes,
+		ElementHooks:       p.ElementHooks,
+		AttributeHooks:     p.AttributeHooks,
+		URLHooks:           p.URLHooks,
+	}
+	if p.Blocklist != nil {
+		s.BlockedHost = p.Blocklist.Blocks
+	}
+	return s
+}
+
+// htmlSanitizer returns the sanitizer of a HTML document, the extensions are rendered by the templates
+func (p *Proxy) htmlSanitizer(ctx *fasthttp.RequestCtx, baseURL *url.URL) *sanitize.Sanitizer {
+	msg := Catalog[p.requestLanguage(ctx)]
+	s := p.sanitizer(baseURL, ctx.UserValue("mortytext") != nil)
+	// the morty URL of the page, before its <base> element changes the BaseURL
+	permalink := s.ProxifiedURL(baseURL, "")
+	archivedURL, _ := ctx.UserValue("mortyarchive").(string)
+	s.BodyExtension = func(out io.Writer, s *sanitize.Sanitizer) error {
+		return p.Templates.BodyExtension.Execute(out, HTMLBodyExtParam{
+			BaseURL:     s.BaseURL.String(),
+			Permalink:   permalink,
+			ArchivedURL: archivedURL,
+			HasMortyKey: len(s.Key) > 0,
+			TextOnly:    s.TextOnlyParam,
+			Theme:       p.HeaderTheme,
+			Msg:         msg,
+		})
+	}
+	s.FormExtension = func(out io.Writer, param sanitize.FormExtensionParam) error {
+		return p.Templates.FormExtension.Execute(out, HTMLFormExtParam(param))
+	}
+	if p.RefererPolicy != "" && p.RefererPolicy != RefererPolicyNone {
+		s.Head = HtmlHeadSameOriginReferrer
+	}
+	return s
+}
+
+// logSanitizeError logs the parse errors of a document, the sanitized output is sent anyway
+func (p *Proxy) logSanitizeError(err error) {
+	if err != nil {
+		p.Logger.Warn("failed to sanitize HTML", "error", err)
+	}
+}
+
+func isStreamingResponse(resp *fasthttp.Response) bool {
+	contentType, err := contenttype.ParseContentTypeHeader(string(resp.Header.ContentType()))
+	return err == nil && StreamingContentTypeFilter(contentType)
+}
+
+// htmlCacheKey identifies a sanitized document by the upstream URL and the sanitizer policy version,
+// so cached documents are invalidated as soon as anything affecting the sanitizer output changes.
+func (p *Proxy) htmlCacheKey(uri string, textOnlyParam bool, lang string) string {
+	policy := strings.Join([]string{
+		VERSION,
+		lang,
+		strconv.FormatBool(p.TextOnly || textOnlyParam),
+		strconv.FormatBool(textOnlyParam),
+		strconv.FormatBool(p.NoHeader),
+		strconv.FormatBool(p.Minify),
+		p.IframePolicy,
+		p.StructuredData,
+		p.TargetPolicy,
+		p.SanitizerMode,
+		strconv.FormatBool(p.DataAttributes),
+		strconv.FormatBool(p.DropTrackingPixels),
+		p.Blocklist.Version(),
+		strings.Join(sortedKeys(p.LinkRels), ","),
+		strings.Join(sortedKeys(p.TrackingParams), ","),
+		strings.Join(sortedKeys(p.DirectSchemes), ","),
+	}, "|")
+	return sanitize.Hash(policy, p.Key) + " " + uri
+}
+
+// isCacheableResponse reports whether the upstream response has validators and may be stored.
+func isCacheableResponse(resp *fasthttp.Response) bool {
+	if len(resp.Header.Peek("ETag")) == 0 && len(resp.Header.Peek("Last-Modified")) == 0 {
+		return false
+	}
+	cacheControl := bytes.ToLower(resp.Header.Peek("Cache-Control"))
+	return !bytes.Contains(cacheControl, []byte("no-store")) && !bytes.Contains(cacheControl, []byte("private"))
+}
+
+// ParseAllowedMethods parses a comma separated list of HTTP methods, GET is always allowed
+func ParseAllowedMethods(list string) (map[string]bool, error) {
+	methods := map[string]bool{fasthttp.MethodGet: true}
+	for _, method := range strings.Split(list, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			continue
+		}
+		supported := false
+		for _, m := range SupportedMethods {
+			supported = supported || m == method
+		}
+		if !supported {
+			return nil, errors.New("unsupported method: " + method)
+		}
+		methods[method] = true
+	}
+	return methods, nil
+}
+
+// ParseHeadless returns the status code of the main page in headless mode: 0 if disabled, 404 or 403
+func ParseHeadless(mode string) (int, error) {
+	switch mode {
+	case "", "false":
+		return 0, nil
+	case "true", "404":
+		return 404, nil
+	case "403":
+		return 403, nil
+	}
+	return 0, errors.New("unsupported headless mode: " + mode)
+}
+
+// schemes of the URLs which are not linked by the exit page: they run scripts or embed content
+var unsafeExitSchemes = map[string]bool{
+	"javascript": true,
+	"vbscript":   true,
+	"data":       true,
+	"blob":       true,
+}
+
+// UnsafeDirectSchemes can't be kept as direct links: they run scripts, embed content or bypass morty
+var UnsafeDirectSchemes = map[string]bool{
+	"http":       true,
+	"https":      true,
+	"javascript": true,
+	"vbscript":   true,
+	"data":       true,
+	"blob":       true,
+	"file":       true,
+}
+
+// ParseDirectSchemes parses a comma separated list of URI schemes kept as direct links, "none" disables them
+func ParseDirectSchemes(list string) (map[string]bool, error) {
+	if list == "none" {
+		return nil, nil
+	}
+	schemes := make(map[string]bool)
+	for _, scheme := range strings.Split(list, ",") {
+		scheme = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(scheme), ":"))
+		if scheme == "" {
+			continue
+		}
+		if u, err := url.Parse(scheme + ":"); err != nil || u.Scheme != scheme || UnsafeDirectSchemes[scheme] {
+			return nil, errors.New("unsupported scheme: " + scheme)
+		}
+		schemes[scheme] = true
+	}
+	return schemes, nil
+}
+
+// LoadFavicon reads a favicon file, its content type is guessed from the extension or the content
+func LoadFavicon(path string) ([]byte, string, error) {
+	favicon, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(favicon)
+	}
+	return favicon, contentType, nil
+}
+
+// allowHeader returns the value of the Allow header of a 405 response
+func allowHeader(methods map[string]bool) string {
+	var allowed []string
+	for _, method := range SupportedMethods {
+		if methods[method] {
+			allowed = append(allowed, method)
+		}
+	}
+	return strings.Join(allowed, ", ")
+}
+
+// isSearchTerm reports whether a URL entered by the user is most probably a search query:
+// the host name contains spaces or has no dot.
+func isSearchTerm(uri string) bool {
+	host := strings.TrimSpace(uri)
+	if i := strings.IndexAny(host, "/?#"); i != -1 {
+		host = host[:i]
+	}
+	if strings.ContainsAny(host, " \t\r\n") {
+		return true
+	}
+	return !strings.ContainsAny(host, ".:[") && host != "localhost"
+}
+
+// isAttachment returns true if the content type is not displayed but downloaded as an attachment
+func (p *Proxy) isAttachment(contentType contenttype.ContentType) bool {
+	return AllowedContentTypeAttachmentFilter(contentType) ||
+		(p.AttachmentContentTypes != nil && p.AttachmentContentTypes(contentType))
+}
+
+// force content-disposition to attachment
+func contentDispositionForceAttachment(contentDispositionBytes []byte, url *url.URL) []byte {
+	var contentDispositionParams map[string]string
+
+	if contentDispositionBytes != nil {
+		var err error
+		_, contentDispositionParams, err = mime.ParseMediaType(string(contentDispositionBytes))
+		if err != nil {
+			contentDispositionParams = make(map[string]string)
+		}
+	} else {
+		contentDispositionParams = make(map[string]string)
+	}
+
+	_, fileNameDefined := contentDispositionParams["filename"]
+	if !fileNameDefined {
+		// TODO : sanitize filename
+		contentDispositionParams["fileName"] = filepath.Base(url.Path)
+	}
+
+	return []byte(mime.FormatMediaType("attachment", contentDispositionParams))
+}
+
+func (p *Proxy) appRequestHandler(ctx *fasthttp.RequestCtx) bool {
+	// serve robots.txt
+	if bytes.Equal(ctx.Path(), []byte("/robots.txt")) {
+		ctx.SetContentType("text/plain")
+		if p.RobotsTxt != nil {
+			_, _ = ctx.Write(p.RobotsTxt)
+		} else {
+			_, _ = ctx.WriteString(DefaultRobotsTxt)
+		}
+		return true
+	}
+
+	// serve the health check
+	if bytes.Equal(ctx.Path(), []byte(HealthPath)) {
+		ctx.SetContentType("text/plain")
+		_, _ = ctx.WriteString("OK\n")
+		return true
+	}
+
+	// server favicon.ico
+	if bytes.Equal(ctx.Path(), []byte("/favicon.ico")) {
+		ctx.SetContentType(p.FaviconContentType)
+		_, _ = ctx.Write(p.Favicon)
+		return true
+	}
+
+	return false
+}
+
+// MortyParams are the query and form parameters read by morty, they are not sent to the upstream servers
+var MortyParams = map[string]bool{
+	"mortyhash":    true,
+	"mortyurl":     true,
+	"mortyexpires": true,
+	"mortytext":    true,
+	"mortyget":     true,
+	"mortyexit":    true,
+	// the download of an attachment has been confirmed on the download page
+	"mortydownload": true,
+}
+
+func popRequestParam(ctx *fasthttp.RequestCtx, paramName []byte) []byte {
+	// the query has priority: it contains the formaction of the submit button when the form has hidden morty fields
+	param := ctx.QueryArgs().PeekBytes(paramName)
+
+	if param == nil {
+		param = ctx.PostArgs().PeekBytes(paramName)
+	}
+	if param != nil {
+		param = append([]byte(nil), param...)
+	}
+	ctx.PostArgs().DelBytes(paramName)
+	if isMultipartRequest(ctx) {
+		if form, err := ctx.MultipartForm(); err == nil {
+			if values := form.Value[string(paramName)]; len(values) > 0 && param == nil {
+				param = []byte(values[0])
+			}
+			delete(form.Value, string(paramName))
+		}
+	}
+	ctx.QueryArgs().DelBytes(paramName)
+
+	return param
+}
+
+// appendQueryString appends the URL encoded parameters to the query of uri, before its fragment
+func appendQueryString(uri, query []byte) []byte {
+	if len(query) == 0 {
+		return uri
+	}
+	var fragment []byte
+	if i := bytes.IndexByte(uri, '#'); i != -1 {
+		uri, fragment = uri[:i:i], uri[i:]
+	}
+	if bytes.ContainsRune(uri, '?') {
+		uri = append(uri, '&')
+	} else {
+		uri = append(uri, '?')
+	}
+	return append(append(uri, query...), fragment...)
+}
+
+// withoutMortyParams removes the morty parameters from a URL encoded query,
+// the other parameters keep their order and their original encoding
+func withoutMortyParams(query []byte) []byte {
+	var filtered []byte
+	for _, param := range bytes.Split(query, []byte("&")) {
+		name := param
+		if i := bytes.IndexByte(param, '='); i != -1 {
+			name = param[:i]
+		}
+		if decodedName, err := url.QueryUnescape(string(name)); len(param) == 0 || (err == nil && MortyParams[decodedName]) {
+			continue
+		}
+		if len(filtered) > 0 {
+			filtered = append(filtered, '&')
+		}
+		filtered = append(filtered, param...)
+	}
+	return filtered
+}
+
+func isMultipartRequest(ctx *fasthttp.RequestCtx) bool {
+	return bytes.HasPrefix(bytes.ToLower(ctx.Request.Header.ContentType()), []byte("multipart/form-data"))
+}
+
+func isURLEncodedRequest(ctx *fasthttp.RequestCtx) bool {
+	return bytes.HasPrefix(bytes.ToLower(ctx.Request.Header.ContentType()), []byte("application/x-www-form-urlencoded"))
+}
+
+// copyRequestBody forwards the submitted form without the morty parameters.
+// Multipart forms are encoded again, with a new boundary.
+func copyRequestBody(ctx *fasthttp.RequestCtx, req *fasthttp.Request) error {
+	contentType := ctx.Request.Header.ContentType()
+
+	switch {
+	case isMultipartRequest(ctx):
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			return err
+		}
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		body := bytes.NewBuffer(nil)
+		if err := fasthttp.WriteMultipartForm(body, form, boundary); err != nil {
+			return err
+		}
+		req.Header.SetMultipartFormBoundary(boundary)
+		req.SetBody(body.Bytes())
+	case isURLEncodedRequest(ctx):
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBody(withoutMortyParams(ctx.PostBody()))
+	default:
+		if len(contentType) > 0 {
+			req.Header.SetContentTypeBytes(contentType)
+		}
+		req.SetBody(ctx.PostBody())
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of a lookup table in a stable order
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key, ok := range m {
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pageParam returns the variables of the page layout
+func (p *Proxy) pageParam() PageParam {
+	instanceName := p.InstanceName
+	if instanceName == "" {
+		instanceName = DefaultInstanceName
+	}
+	return PageParam{Version: VERSION, InstanceName: instanceName, HasMortyKey: p.Key != nil}
+}
+
+func (p *Proxy) serveExitMortyPage(ctx *fasthttp.RequestCtx, uri *url.URL) {
+	ctx.SetContentType("text/html")
+	ctx.SetStatusCode(403)
+	// the URL is not proxified: it is only escaped, as the user explicitly asked for it
+	uri = sanitize.PunycodeHost(uri)
+	param := HTMLExitPageParam{PageParam: p.pageParam(), Msg: Catalog[p.requestLanguage(ctx)]}
+	param.DisplayURL, param.Homograph = displayURL(uri)
+	// html/template would filter the magnet: or tel: links, the script schemes are only displayed
+	if !unsafeExitSchemes[strings.ToLower(uri.Scheme)] {
+		param.URL = template.URL(uri.String())
+		// mobile users can continue on another device
+		if code, err := qrcode.Encode([]byte(uri.String())); err == nil {
+			if png, err := code.PNG(QRCodeScale); err == nil {
+				param.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+			}
+		}
+	}
+	if err := p.Templates.ExitPage.Execute(ctx, param); err != nil {
+		p.Logger.Error("failed to render the exit page", "error", err)
+	}
+}
+
+// serveBlockedPage explains that the content type of uri is forbidden and links to its exit page.
+// The details are sent as JSON to the clients which don't accept HTML.
+func (p *Proxy) serveBlockedPage(ctx *fasthttp.RequestCtx, uri *url.URL, contentType contenttype.ContentType) {
+	mediaType := contentType.TopLevelType + "/" + contentType.SubType
+	if contentType.Suffix != "" {
+		mediaType += "+" + contentType.Suffix
+	}
+	p.Logger.Debug("forbidden content type", "content_type", mediaType, "url", uri.String())
+	// HTTP status code 403 : Forbidden
+	ctx.SetStatusCode(403)
+
+	accept := string(ctx.Request.Header.Peek("Accept"))
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+		ctx.SetContentType("application/json")
+		_ = json.NewEncoder(ctx).Encode(BlockedContent{Error: "forbidden content type", URL: uri.String(), ContentType: mediaType})
+		return
+	}
+
+	ctx.SetContentType("text/html; charset=UTF-8")
+	s := &sanitize.Sanitizer{Key: p.Key}
+	param := HTMLBlockedPageParam{
+		PageParam:   p.pageParam(),
+		ContentType: mediaType,
+		ExitURL:     s.ProxifiedURL(uri, "") + "&mortyexit=1",
+		Msg:         Catalog[p.requestLanguage(ctx)],
+	}
+	param.URL, param.Homograph = displayURL(uri)
+	if err := p.Templates.BlockedPage.Execute(ctx, param); err != nil {
+		p.Logger.Error("failed to render the blocked page", "error", err)
+	}
+}
+
+func (p *Proxy) serveMainPage(ctx *fasthttp.RequestCtx, statusCode int, err error) {
+	if p.Headless != 0 && err == nil {
+		statusCode = p.Headless
+	}
+	ctx.SetContentType("text/html; charset=UTF-8")
+	ctx.SetStatusCode(statusCode)
+	param := HTMLMainPageParam{PageParam: p.pageParam(), Msg: Catalog[p.requestLanguage(ctx)]}
+	if err != nil {
+		param.ArchiveURL = p.archiveOffer(ctx, statusCode)
+		id := requestID(ctx)
+		if statusCode >= 500 {
+			// upstream errors: timeouts, connection errors, invalid responses
+			p.Logger.Warn("request failed", "status", statusCode, "error", err, "request_id", id)
+		} else {
+			p.Logger.Debug("request rejected", "status", statusCode, "error", err, "request_id", id)
+		}
+		if errorPage := p.Templates.errorPage(statusCode); errorPage != nil {
+			errorParam := HTMLErrorPageParam{
+				PageParam:  param.PageParam,
+				Status:     statusCode,
+				Reason:     fasthttp.StatusMessage(statusCode),
+				Error:      err.Error(),
+				RequestID:  id,
+				ArchiveURL: param.ArchiveURL,
+				Msg:        param.Msg,
+			}
+			// the mortyurl parameter is removed from the query by RequestHandler
+			errorParam.URL = string(ctx.QueryArgs().Peek("mortyurl"))
+			if requestURI, ok := ctx.UserValue("mortyurl").(string); ok {
+				errorParam.URL = requestURI
+			}
+			if u, err := url.Parse(errorParam.URL); err == nil && u.Host != "" {
+				errorParam.URL, errorParam.Homograph = displayURL(u)
+			}
+			if err := errorPage.Execute(ctx, errorParam); err != nil {
+				p.Logger.Error("failed to render the error page", "error", err)
+			}
+			return
+		}
+		param.Error = err.Error()
+	}
+	if p.Headless != 0 {
+		// no form: the errors are sent as text
+		ctx.SetContentType("text/plain; charset=UTF-8")
+		ctx.SetBodyString(fasthttp.StatusMessage(statusCode) + "\n" + param.Error)
+		return
+	}
+	if err := p.Templates.MainPage.Execute(ctx, param); err != nil {
+		p.Logger.Error("failed to render the main page", "error", err)
+	}
+}
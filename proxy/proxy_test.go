@@ -0,0 +1,613 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friedemannsommer/morty/cache"
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+type StringTestCase struct {
+	Input          string
+	ExpectedOutput string
+}
+
+var searchTermTestData = []struct {
+	Input    string
+	IsSearch bool
+}{
+	{"example.com", false},
+	{"example.com/path?q=a b", false},
+	{"how to use a/b testing", true},
+	{"https://example.com/", false},
+	{"localhost/x", false},
+	{"10.0.0.1:8080", false},
+	{"[::1]/", false},
+	{"morty", true},
+	{"what is morty", true},
+	{"", true},
+}
+
+func TestIsSearchTerm(t *testing.T) {
+	for _, testCase := range searchTermTestData {
+		if isSearchTerm(testCase.Input) != testCase.IsSearch {
+			t.Errorf(`Search term error. Input: "%s", Expected: %t`, testCase.Input, testCase.IsSearch)
+		}
+	}
+}
+
+func TestMultipartFormParams(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.Header.SetContentType("multipart/form-data; boundary=xYzZY")
+	ctx.Request.SetBodyString("--xYzZY\r\n" +
+		"Content-Disposition: form-data; name=\"mortyurl\"\r\n\r\nhttp://127.0.0.1/upload\r\n" +
+		"--xYzZY\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\nhello\r\n" +
+		"--xYzZY\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\nContent-Type: text/plain\r\n\r\nfile content\r\n" +
+		"--xYzZY--\r\n")
+
+	mortyURL := popRequestParam(ctx, []byte("mortyurl"))
+	if string(mortyURL) != "http://127.0.0.1/upload" {
+		t.Errorf(`Multipart param error. Expected: "http://127.0.0.1/upload", Got: "%s"`, mortyURL)
+	}
+
+	req := &fasthttp.Request{}
+	if err := copyRequestBody(ctx, req); err != nil {
+		t.Fatalf("Failed to copy multipart body: %v", err)
+	}
+	body := string(req.Body())
+	if strings.Contains(body, "mortyurl") {
+		t.Errorf("Multipart body error. mortyurl is forwarded: %s", body)
+	}
+	if !strings.Contains(body, "hello") || !strings.Contains(body, "file content") || !strings.Contains(body, `filename="a.txt"`) {
+		t.Errorf("Multipart body error. Form fields are missing: %s", body)
+	}
+	if !bytes.HasPrefix(req.Header.ContentType(), []byte("multipart/form-data; boundary=")) {
+		t.Errorf("Multipart body error. Unexpected content type: %s", req.Header.ContentType())
+	}
+}
+
+func TestFormActionParams(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/?mortyurl=http%3A%2F%2F127.0.0.1%2Falt")
+	ctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+	ctx.Request.SetBodyString("mortyurl=http%3A%2F%2F127.0.0.1%2Fs&q=a")
+
+	mortyURL := popRequestParam(ctx, []byte("mortyurl"))
+	if string(mortyURL) != "http://127.0.0.1/alt" {
+		t.Errorf(`Form action param error. Expected: "http://127.0.0.1/alt", Got: "%s"`, mortyURL)
+	}
+	if body := string(ctx.PostArgs().QueryString()); body != "q=a" {
+		t.Errorf(`Form action body error. Expected: "q=a", Got: "%s"`, body)
+	}
+}
+
+var formTestData = []struct {
+	Target      string
+	Query       string
+	ExpectedURI string
+}{
+	{
+		"http://127.0.0.1/search",
+		"q=a&q=b&page=",
+		"http://127.0.0.1/search?q=a&q=b&page=",
+	},
+	{
+		"http://127.0.0.1/post?lang=en",
+		"",
+		"http://127.0.0.1/post?lang=en",
+	},
+	{
+		"http://127.0.0.1/page",
+		"x=1",
+		"http://127.0.0.1/page?x=1",
+	},
+	{
+		"http://127.0.0.1/search?a=b%26c#results",
+		"q=x+y&r=%26%2B%3B&s=1;2&t=%E2%82%AC&q=a",
+		"http://127.0.0.1/search?a=b%26c&q=x+y&r=%26%2B%3B&s=1;2&t=%E2%82%AC&q=a#results",
+	},
+	{
+		"http://127.0.0.1/page",
+		"x=1&mortyhash=abc&morty%75rl=x&&y",
+		"http://127.0.0.1/page?x=1&y",
+	},
+}
+
+func TestFormRouting(t *testing.T) {
+	for _, testCase := range formTestData {
+		// form submission as sent by a browser: the hidden morty field first, then the form fields
+		ctx := &fasthttp.RequestCtx{}
+		query := "mortyurl=" + url.QueryEscape(testCase.Target)
+		if testCase.Query != "" {
+			query += "&" + testCase.Query
+		}
+		ctx.Request.SetRequestURI("/?" + query)
+		requestURI := popRequestParam(ctx, []byte("mortyurl"))
+		requestURI = appendQueryString(requestURI, withoutMortyParams(ctx.URI().QueryString()))
+		if string(requestURI) != testCase.ExpectedURI {
+			t.Errorf(`Form submission error. Expected: "%s", Got: "%s"`, testCase.ExpectedURI, requestURI)
+		}
+	}
+}
+
+func TestRangeRequest(t *testing.T) {
+	var partialRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial.html" {
+			// a broken server which always answers with a part of the page
+			partialRequests++
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Range", "bytes 0-3/17")
+			w.WriteHeader(206)
+			_, _ = w.Write([]byte("<p>0"))
+			return
+		}
+		if r.URL.Path == "/page.html" {
+			w.Header().Set("Content-Type", "text/html")
+		} else {
+			w.Header().Set("Content-Type", "application/pdf")
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("<p>0123456789</p>"))
+	}))
+	defer server.Close()
+
+	p := New(func(p *Proxy) { p.NoHeader = true })
+	testCases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/file.pdf", 206, "<p>0"},
+		{"/page.html", 200, "<p>0123456789</p>"},
+		{"/partial.html", 502, "unexpected partial content"},
+	}
+	for _, tc := range testCases {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.Set("Range", "bytes=0-3")
+		p.ProcessUri(ctx, server.URL+tc.path, 0)
+
+		if ctx.Response.StatusCode() != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, ctx.Response.StatusCode())
+		}
+		if !strings.Contains(string(ctx.Response.Body()), tc.body) {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, ctx.Response.Body())
+		}
+		if tc.status == 206 && string(ctx.Response.Header.Peek("Content-Range")) != "bytes 0-3/17" {
+			t.Errorf("%s: invalid Content-Range %q", tc.path, ctx.Response.Header.Peek("Content-Range"))
+		}
+	}
+	if partialRequests != 2 {
+		t.Errorf("expected the partial page to be requested twice, got %d", partialRequests)
+	}
+}
+
+func TestConditionalRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/style.css" {
+			w.Header().Set("Content-Type", "text/css")
+		} else {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("p{color:red}"))
+	}))
+	defer server.Close()
+
+	p := New()
+	for _, path := range []string{"/image.png", "/style.css"} {
+		ctx := &fasthttp.RequestCtx{}
+		p.ProcessUri(ctx, server.URL+path, 0)
+		etag := ctx.Response.Header.Peek("ETag")
+		if ctx.Response.StatusCode() != 200 || len(etag) == 0 {
+			t.Errorf("%s: expected status 200 with an ETag, got %d %q", path, ctx.Response.StatusCode(), etag)
+			continue
+		}
+
+		ctx = &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetBytesV("If-None-Match", etag)
+		p.ProcessUri(ctx, server.URL+path, 0)
+		if ctx.Response.StatusCode() != 304 || len(ctx.Response.Body()) != 0 {
+			t.Errorf("%s: expected status 304, got %d", path, ctx.Response.StatusCode())
+		}
+	}
+}
+
+func TestMissingContentType(t *testing.T) {
+	for _, header := range [][]string{nil, {"text"}, {"text/html; charset=\"utf-8"}, {"text/plain", "text/html;;charset=utf-8"}} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// net/http sniffs the content type unless the header is explicitly empty
+			w.Header()["Content-Type"] = header
+			_, _ = io.WriteString(w, `<html><body><a href="/a">a</a><script>x()</script></body></html>`)
+		}))
+
+		ctx := &fasthttp.RequestCtx{}
+		New(func(p *Proxy) { p.NoHeader = true }).ProcessUri(ctx, server.URL, 0)
+		server.Close()
+		body := string(ctx.Response.Body())
+		if ctx.Response.StatusCode() != 200 || !strings.HasPrefix(string(ctx.Response.Header.ContentType()), "text/html") ||
+			strings.Contains(body, "script") || !strings.Contains(body, "mortyurl") {
+			t.Errorf("%q: expected a sanitized HTML document, got %d %s: %s", header, ctx.Response.StatusCode(), ctx.Response.Header.ContentType(), body)
+		}
+	}
+}
+
+func TestBlockedPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-shockwave-flash")
+		_, _ = io.WriteString(w, "FWS")
+	}))
+	defer server.Close()
+
+	p := New()
+	ctx := &fasthttp.RequestCtx{}
+	p.ProcessUri(ctx, server.URL+"/a.swf", 0)
+	body := string(ctx.Response.Body())
+	exitURL := "./?mortyurl=" + url.QueryEscape(server.URL+"/a.swf") + "&amp;mortyexit=1"
+	if ctx.Response.StatusCode() != 403 || !strings.Contains(body, "application/x-shockwave-flash") || !strings.Contains(body, exitURL) {
+		t.Errorf("Blocked page error. Got %d: %s", ctx.Response.StatusCode(), body)
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("Accept", "application/json")
+	p.ProcessUri(ctx, server.URL+"/a.swf", 0)
+	expected := `{"error":"forbidden content type","url":"` + server.URL + `/a.swf","content_type":"application/x-shockwave-flash"}` + "\n"
+	if string(ctx.Response.Body()) != expected {
+		t.Errorf("Blocked page error. Expected %s, got %s", expected, ctx.Response.Body())
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+"/a.swf") + "&mortyexit=1")
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 403 || !strings.Contains(string(ctx.Response.Body()), `href="`+server.URL+`/a.swf"`) ||
+		!strings.Contains(string(ctx.Response.Body()), `<img src="data:image/png;base64,`) {
+		t.Errorf("Exit page error. Got %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+}
+
+func TestXHTMLDocument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xhtml+xml")
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="ISO-8859-1"?>`+
+			`<html xmlns="http://www.w3.org/1999/xhtml"><head><title>caf`+"\xe9"+`</title></head><body><p>a<br/><img src="i.png"/></p><form action="/s"><input name="q"/></form></body></html>`)
+	}))
+	defer server.Close()
+
+	ctx := &fasthttp.RequestCtx{}
+	New(func(p *Proxy) { p.Minify = true }).ProcessUri(ctx, server.URL, 0)
+	if contentType := string(ctx.Response.Header.ContentType()); contentType != "application/xhtml+xml; charset=UTF-8" {
+		t.Errorf("XHTML error. Unexpected content type %s", contentType)
+	}
+	body := ctx.Response.Body()
+	if !bytes.Contains(body, []byte("café")) || !bytes.Contains(body, []byte("<br />")) {
+		t.Errorf("XHTML error. Unexpected document: %s", body)
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("XHTML error. Invalid XML: %v\n%s", err, body)
+			break
+		}
+	}
+}
+
+func TestAnchorScrollPadding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><body><a href="#section">section</a><h2 id="section">Section</h2></body></html>`)
+	}))
+	defer server.Close()
+
+	ctx := &fasthttp.RequestCtx{}
+	New().ProcessUri(ctx, server.URL, 0)
+	// the fragment targets are scrolled below the fixed header
+	body := string(ctx.Response.Body())
+	if !strings.Contains(body, "html { scroll-padding-top: 42px !important; }") ||
+		!strings.Contains(body, `href="#section"`) || !strings.Contains(body, `id="section"`) {
+		t.Errorf("Anchor error. Unexpected document: %s", body)
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	if _, err := ParseAllowedMethods("get, post,TRACE"); err == nil {
+		t.Error("TRACE should be rejected")
+	}
+	methods, err := ParseAllowedMethods("HEAD, delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allow := allowHeader(methods); allow != "GET, HEAD, DELETE" {
+		t.Errorf("Allow header error. Expected GET, HEAD, DELETE, got %s", allow)
+	}
+
+	var method, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, body = r.Method, string(b)
+		w.Header().Set("Content-Type", "text/plain")
+	}))
+	defer server.Close()
+
+	p := New(func(p *Proxy) { p.AllowedMethods = methods })
+	for _, test := range []struct {
+		method string
+		status int
+	}{
+		{"DELETE", 200},
+		{"PUT", 405},
+		{"PATCH", 405},
+	} {
+		method, body = "", ""
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(test.method)
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+"/r"))
+		ctx.Request.SetBodyString("id=1")
+		p.RequestHandler(ctx)
+		if ctx.Response.StatusCode() != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.method, test.status, ctx.Response.StatusCode())
+		}
+		if test.status == 405 && (method != "" || string(ctx.Response.Header.Peek("Allow")) != "GET, HEAD, DELETE") {
+			t.Errorf("%s: request forwarded or invalid Allow header %q", test.method, ctx.Response.Header.Peek("Allow"))
+		}
+		if test.status == 200 && (method != test.method || body != "id=1") {
+			t.Errorf("%s: expected the request body to be forwarded, got %s %q", test.method, method, body)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	p := New(WithAdminToken([]byte("secret"), TelemetryHostsFull), func(p *Proxy) { p.HTMLCache = cache.New(time.Minute, 1024) })
+	for _, uri := range []string{"https://example.com/a", "https://www.example.com/b", "https://example.org/c"} {
+		p.HTMLCache.Set(p.htmlCacheKey(uri, false, "en"), &cache.Entry{Body: []byte("x")})
+	}
+
+	testCases := []struct {
+		token  string
+		method string
+		body   string
+		status int
+		purged string
+		left   int
+	}{
+		{"wrong", "POST", "", 401, "", 3},
+		{"secret", "GET", "", 405, "", 3},
+		{"secret", "POST", "url=https%3A%2F%2Fexample.org%2F", 200, `{"purged":1}`, 2},
+		{"secret", "POST", "host=example.com", 200, `{"purged":2}`, 0},
+	}
+	for _, tc := range testCases {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(tc.method)
+		ctx.Request.Header.Set("Authorization", "Bearer "+tc.token)
+		ctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+		ctx.Request.SetRequestURI(PurgePath)
+		ctx.Request.SetBodyString(tc.body)
+		p.RequestHandler(ctx)
+		if ctx.Response.StatusCode() != tc.status || p.HTMLCache.Len() != tc.left {
+			t.Errorf("%s %s: expected status %d and %d entries, got %d and %d", tc.method, tc.body, tc.status, tc.left, ctx.Response.StatusCode(), p.HTMLCache.Len())
+		}
+		if tc.purged != "" && strings.TrimSpace(string(ctx.Response.Body())) != tc.purged {
+			t.Errorf("%s: expected %s, got %s", tc.body, tc.purged, ctx.Response.Body())
+		}
+	}
+}
+
+func TestEmptyAdminToken(t *testing.T) {
+	for _, p := range []*Proxy{
+		New(WithAdminToken(nil, TelemetryHostsFull)),
+		New(func(p *Proxy) { p.Stats = NewStats(TelemetryHostsFull) }),
+	} {
+		for _, path := range []string{PurgePath, StatsPath, ProxifyPath, SignPath} {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod("POST")
+			ctx.Request.Header.Set("Authorization", "Bearer ")
+			ctx.Request.SetRequestURI(path)
+			p.RequestHandler(ctx)
+			if strings.HasPrefix(string(ctx.Response.Header.ContentType()), "application/json") {
+				t.Errorf("%s: the admin endpoint should not be exposed without a token", path)
+			}
+			if p.authorizeAdmin(ctx) {
+				t.Errorf("%s: an empty bearer token should be rejected", path)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(404)
+		}
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+
+	p := New(WithAdminToken([]byte("secret"), TelemetryHostsFull))
+	for _, path := range []string{"/a", "/b", "/missing"} {
+		ctx := &fasthttp.RequestCtx{}
+		p.ProcessUri(ctx, server.URL+path, 0)
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(StatsPath)
+	p.RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 401 {
+		t.Errorf("Expected 401 without token, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(StatsPath)
+	ctx.Request.Header.Set("Authorization", "Bearer secret")
+	p.RequestHandler(ctx)
+	var result StatsResult
+	if err := json.Unmarshal(ctx.Response.Body(), &result); err != nil {
+		t.Fatal(err)
+	}
+	expected := HostStats{Requests: 3, Errors: 1, Bytes: 15}
+	if len(result.Hosts) != 1 || result.Hosts["127.0.0.1"] != expected {
+		t.Errorf("Expected %+v for 127.0.0.1, got %+v", expected, result.Hosts)
+	}
+
+	stats := NewStats(TelemetryHostsNone)
+	stats.Host("example.com").Record(false, 10)
+	stats.Host("example.org").Record(true, 0)
+	if all := stats.Snapshot().Hosts; len(all) != 1 || all[StatsAllHosts] != (HostStats{Requests: 2, Errors: 1, Bytes: 10}) {
+		t.Errorf("Expected the requests to be counted under %s, got %+v", StatsAllHosts, all)
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><head><base href="https://example.com/"></head><body><p>text</p></body></html>`)
+	}))
+	defer server.Close()
+
+	p := New(WithKey([]byte("key")))
+	ctx := &fasthttp.RequestCtx{}
+	p.ProcessUri(ctx, server.URL+"/page?a=b", 0)
+	// the permalink is the morty URL of the page, not of its <base>
+	permalink := `href="./?mortyhash=` + sanitize.Hash(server.URL+"/page?a=b", p.Key) +
+		`&amp;mortyurl=` + url.QueryEscape(server.URL+"/page?a=b") + `" rel="nofollow"`
+	if body := string(ctx.Response.Body()); !strings.Contains(body, permalink) {
+		t.Errorf("Expected the permalink %s, got %s", permalink, body)
+	}
+}
+
+func TestHeadless(t *testing.T) {
+	if _, err := ParseHeadless("200"); err == nil {
+		t.Error("200 should be rejected")
+	}
+	status, err := ParseHeadless("403")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(func(p *Proxy) { p.Headless = status })
+	for _, test := range []struct {
+		uri    string
+		status int
+	}{
+		{"/", 403},
+		{"/?mortyurl=" + url.QueryEscape("https://example.com/") + "&mortyexpires=x", 400},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(test.uri)
+		p.RequestHandler(ctx)
+		body := string(ctx.Response.Body())
+		if ctx.Response.StatusCode() != test.status || strings.Contains(body, "<form") {
+			t.Errorf("%s: expected status %d without form, got %d: %s", test.uri, test.status, ctx.Response.StatusCode(), body)
+		}
+	}
+}
+
+func TestAppFiles(t *testing.T) {
+	dir := t.TempDir()
+	faviconPath := filepath.Join(dir, "favicon.svg")
+	if err := os.WriteFile(faviconPath, []byte("<svg/>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	favicon, contentType, err := LoadFavicon(faviconPath)
+	if err != nil || contentType != "image/svg+xml" {
+		t.Fatalf("LoadFavicon error: %q %v", contentType, err)
+	}
+
+	for _, test := range []struct {
+		proxy       *Proxy
+		path        string
+		contentType string
+		body        string
+	}{
+		{New(), "/robots.txt", "text/plain", DefaultRobotsTxt},
+		{New(), "/favicon.ico", "image/png", string(New().Favicon)},
+		{New(func(p *Proxy) { p.RobotsTxt = []byte("Allow: /$\n") }), "/robots.txt", "text/plain", "Allow: /$\n"},
+		{New(func(p *Proxy) { p.Favicon, p.FaviconContentType = favicon, contentType }), "/favicon.ico", "image/svg+xml", "<svg/>"},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(test.path)
+		test.proxy.RequestHandler(ctx)
+		if string(ctx.Response.Header.ContentType()) != test.contentType || string(ctx.Response.Body()) != test.body {
+			t.Errorf("%s: expected %s %q, got %s %q", test.path, test.contentType, test.body, ctx.Response.Header.ContentType(), ctx.Response.Body())
+		}
+	}
+}
+
+func TestDirectSchemes(t *testing.T) {
+	for _, list := range []string{"mailto,https", "javascript", "ma il"} {
+		if _, err := ParseDirectSchemes(list); err == nil {
+			t.Errorf("%q should be rejected", list)
+		}
+	}
+	schemes, err := ParseDirectSchemes(" MAILTO:, tel,")
+	if err != nil || len(schemes) != 2 || !schemes["mailto"] || !schemes["tel"] {
+		t.Errorf("unexpected schemes %v: %v", schemes, err)
+	}
+	if schemes, _ := ParseDirectSchemes("none"); schemes != nil {
+		t.Errorf("none should disable the direct links, got %v", schemes)
+	}
+}
+
+func TestSuccessStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/created":
+			w.Header().Set("Location", "/items/1")
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(201)
+			_, _ = io.WriteString(w, "<p>created</p><script>alert(1)</script>")
+		case "/accepted":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(202)
+			_, _ = io.WriteString(w, "queued")
+		case "/empty":
+			w.WriteHeader(204)
+		case "/choices":
+			w.Header().Set("Location", "/en")
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(300)
+			_, _ = io.WriteString(w, `<ul><li><a href="/en">en</a></li><li><a href="/de" onclick="x()">de</a></li></ul>`)
+		}
+	}))
+	defer server.Close()
+
+	p := New()
+	for _, test := range []struct {
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"/created", 201, "<p>created</p>", "./?mortyurl=" + url.QueryEscape(server.URL+"/items/1")},
+		{"/accepted", 202, "queued", ""},
+		{"/empty", 204, "", ""},
+		{"/choices", 300, `<a href="./?mortyurl=` + url.QueryEscape(server.URL+"/de") + `">de</a>`, "./?mortyurl=" + url.QueryEscape(server.URL+"/en")},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+test.path))
+		p.RequestHandler(ctx)
+		body := string(ctx.Response.Body())
+		if ctx.Response.StatusCode() != test.status || !strings.Contains(body, test.body) || strings.Contains(body, "alert") {
+			t.Errorf("%s: expected status %d and %q, got %d: %s", test.path, test.status, test.body, ctx.Response.StatusCode(), body)
+		}
+		if location := string(ctx.Response.Header.Peek("Location")); location != test.location {
+			t.Errorf("%s: expected Location %q, got %q", test.path, test.location, location)
+		}
+	}
+}
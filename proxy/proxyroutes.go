@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+)
+
+const ProxyRouteDirect = "direct"
+
+// ProxyRoute sends the connections to the hosts matching Pattern through Dial
+type ProxyRoute struct {
+	// "example.com" matches the host, "*.example.com" its subdomains and "*" every host
+	Pattern string
+	Dial    fasthttp.DialFunc
+}
+
+// ParseProxyRoutes parses a comma separated list of pattern=egress rules, the egress is "direct",
+// "http://[user:pass@]hostname:port" or "socks5://hostname:port"
+func ParseProxyRoutes(list string, direct fasthttp.DialFunc) ([]ProxyRoute, error) {
+	var routes []ProxyRoute
+	for _, rule := range strings.Split(list, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		eq := strings.IndexByte(rule, '=')
+		if eq <= 0 {
+			return nil, errors.New("invalid proxy route: " + rule)
+		}
+		pattern := strings.ToLower(strings.TrimSpace(rule[:eq]))
+		egress := strings.TrimSpace(rule[eq+1:])
+		if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return nil, errors.New("invalid proxy route pattern: " + pattern)
+		}
+
+		dial := direct
+		if egress != ProxyRouteDirect {
+			var err error
+			if dial, err = proxyDialer(egress); err != nil {
+				return nil, err
+			}
+		}
+		routes = append(routes, ProxyRoute{Pattern: pattern, Dial: dial})
+	}
+	return routes, nil
+}
+
+// proxyDialer returns the dialer of a "http://[user:pass@]hostname:port" or "socks5://hostname:port" proxy
+func proxyDialer(proxy string) (fasthttp.DialFunc, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, errors.New("invalid proxy: " + proxy)
+	}
+	switch proxyURL.Scheme {
+	case "http":
+		return fasthttpproxy.FasthttpHTTPDialer(strings.TrimPrefix(proxy, "http://")), nil
+	case "socks5":
+		return fasthttpproxy.FasthttpSocksDialer(proxy), nil
+	}
+	return nil, errors.New("unsupported proxy scheme: " + proxy)
+}
+
+// RouteDialer dials through the first route matching the host, or through fallback
+func RouteDialer(routes []ProxyRoute, fallback fasthttp.DialFunc) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		for _, route := range routes {
+			if matchHostPattern(route.Pattern, host) {
+				return route.Dial(addr)
+			}
+		}
+		return fallback(addr)
+	}
+}
+
+// isOnionHost reports whether host is a Tor onion service
+func isOnionHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".onion")
+}
+
+func matchHostPattern(pattern, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
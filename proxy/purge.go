@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// path of the cache purge endpoint, enabled by the -admintoken flag
+const PurgePath = "/cache/purge"
+
+type PurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// servePurge removes the documents of the HTML cache: all of them, the ones whose URL starts with the
+// "url" parameter or the ones of the "host" parameter and its subdomains.
+// The request must be a POST request authenticated by an "Authorization: Bearer <admin token>" header.
+func (p *Proxy) servePurge(ctx *fasthttp.RequestCtx) {
+	if !p.authorizeAdmin(ctx) {
+		return
+	}
+	if !ctx.IsPost() {
+		ctx.Response.Header.Set("Allow", fasthttp.MethodPost)
+		// HTTP status code 405 : Method Not Allowed
+		ctx.Error("method not allowed", 405)
+		return
+	}
+
+	prefix := string(ctx.PostArgs().Peek("url"))
+	host := strings.ToLower(string(ctx.PostArgs().Peek("host")))
+
+	result := PurgeResult{}
+	if p.HTMLCache != nil {
+		if prefix == "" && host == "" {
+			result.Purged = p.HTMLCache.Len()
+			p.HTMLCache.Purge()
+		} else {
+			result.Purged = p.HTMLCache.DeleteFunc(func(key string) bool {
+				return purgeMatches(key, prefix, host)
+			})
+		}
+	}
+	p.Logger.Info("cache purged", "purged", result.Purged)
+
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(result)
+}
+
+// authorizeAdmin checks the "Authorization: Bearer <admin token>" header of the admin endpoints,
+// the 401 response is sent if it doesn't match. An empty token matches nothing.
+func (p *Proxy) authorizeAdmin(ctx *fasthttp.RequestCtx) bool {
+	token := ctx.Request.Header.Peek("Authorization")
+	if len(p.AdminToken) == 0 || !strings.HasPrefix(string(token), "Bearer ") ||
+		subtle.ConstantTimeCompare(token[len("Bearer "):], p.AdminToken) != 1 {
+		ctx.Response.Header.Set("WWW-Authenticate", "Bearer")
+		// HTTP status code 401 : Unauthorized
+		ctx.Error("unauthorized", 401)
+		return false
+	}
+	return true
+}
+
+// purgeMatches reports whether the URL of a cache key starts with prefix and belongs to host
+func purgeMatches(key, prefix, host string) bool {
+	// the key is the hash of the sanitization policy followed by the URL, see htmlCacheKey
+	uri := key[strings.IndexByte(key, ' ')+1:]
+	if !strings.HasPrefix(uri, prefix) {
+		return false
+	}
+	if host == "" {
+		return true
+	}
+	parsedURI, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	hostname := strings.ToLower(parsedURI.Hostname())
+	return hostname == host || strings.HasSuffix(hostname, "."+host)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+
+	"github.com/friedemannsommer/morty/sanitize"
+	"github.com/valyala/fasthttp"
+)
+
+// policies of the followed redirects from a https URL to a http URL
+const (
+	DowngradeRedirectsAllow = "allow"
+	DowngradeRedirectsWarn  = "warn"
+	DowngradeRedirectsBlock = "block"
+)
+
+// redirectLocation resolves the Location header of a redirect from u, like RFC 7231 section 7.1.2:
+// a relative location is resolved against u and u's fragment is kept if the location has none
+func redirectLocation(u *url.URL, location []byte) (*url.URL, error) {
+	if len(bytes.TrimSpace(location)) == 0 {
+		return nil, errors.New("missing Location header")
+	}
+	target, err := u.Parse(string(bytes.TrimSpace(location)))
+	if err != nil {
+		return nil, err
+	}
+	if target.Fragment == "" {
+		target.Fragment = u.Fragment
+	}
+	return target, nil
+}
+
+// proxifiedLocation returns the morty URL of a redirect target, followed by the fragment of the target
+func proxifiedLocation(s *sanitize.Sanitizer, target *url.URL) string {
+	withoutFragment := *target
+	withoutFragment.Fragment = ""
+	if target.Fragment == "" {
+		return s.ProxifiedURL(&withoutFragment, "")
+	}
+	return s.ProxifiedURL(&withoutFragment, "#"+target.Fragment)
+}
+
+// isDowngradeRedirect reports whether the redirect from u to target switches from https to http
+func isDowngradeRedirect(u, target *url.URL) bool {
+	return u.Scheme == "https" && target.Scheme == "http"
+}
+
+// isRedirectCycle records the URLs of the followed redirects of a request and reports whether the redirect
+// from u to target goes back to one of them
+func isRedirectCycle(ctx *fasthttp.RequestCtx, u, target *url.URL) bool {
+	visited, _ := ctx.UserValue("mortyredirects").(map[string]bool)
+	if visited == nil {
+		visited = make(map[string]bool)
+		ctx.SetUserValue("mortyredirects", visited)
+	}
+	visited[redirectKey(u)] = true
+	return visited[redirectKey(target)]
+}
+
+// redirectKey identifies a URL of a redirect chain, the fragment is not sent to the servers
+func redirectKey(u *url.URL) string {
+	withoutFragment := *u
+	withoutFragment.Fragment = ""
+	return withoutFragment.String()
+}
+
+// serveDowngradeRedirect refuses to follow a redirect from https to http, or asks the user to follow it
+func (p *Proxy) serveDowngradeRedirect(ctx *fasthttp.RequestCtx, target *url.URL) {
+	p.Logger.Debug("downgrade redirect", "location", target.String(), "policy", p.DowngradeRedirects)
+	if p.DowngradeRedirects == DowngradeRedirectsBlock {
+		// HTTP status code 502 : Bad Gateway
+		p.serveMainPage(ctx, 502, errors.New("redirect from https to http refused: "+target.String()))
+		return
+	}
+
+	ctx.SetContentType("text/html; charset=UTF-8")
+	s := &sanitize.Sanitizer{Key: p.Key, TextOnlyParam: ctx.UserValue("mortytext") != nil}
+	param := HTMLDowngradePageParam{
+		PageParam: p.pageParam(),
+		URL:       target.String(),
+		NextURL:   proxifiedLocation(s, target),
+		Msg:       Catalog[p.requestLanguage(ctx)],
+	}
+	if err := p.Templates.DowngradePage.Execute(ctx, param); err != nil {
+		p.Logger.Error("failed to render the downgrade page", "error", err)
+	}
+}
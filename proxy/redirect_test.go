@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDowngradeRedirect(t *testing.T) {
+	u, _ := url.Parse("https://example.com/a")
+	for location, expected := range map[string]bool{
+		"http://example.com/b":  true,
+		"HTTP://example.com/b":  true,
+		"https://example.com/b": false,
+		"/b":                    false,
+	} {
+		target, _ := redirectLocation(u, []byte(location))
+		if isDowngradeRedirect(u, target) != expected {
+			t.Errorf("%s: expected %t", location, expected)
+		}
+	}
+
+	for _, test := range []struct {
+		policy string
+		status int
+		body   string
+	}{
+		{DowngradeRedirectsWarn, 200, `<a href="./?mortyurl=http%3A%2F%2Fexample.com%2Fb">`},
+		{DowngradeRedirectsBlock, 502, "redirect from https to http refused"},
+	} {
+		p := New(func(p *Proxy) { p.DowngradeRedirects = test.policy })
+		ctx := &fasthttp.RequestCtx{}
+		target, _ := url.Parse("http://example.com/b")
+		p.serveDowngradeRedirect(ctx, target)
+		if ctx.Response.StatusCode() != test.status || !strings.Contains(string(ctx.Response.Body()), test.body) {
+			t.Errorf("%s: expected status %d and %q, got %d: %s", test.policy, test.status, test.body, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+	}
+}
+
+func TestRedirectCycle(t *testing.T) {
+	var requests int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, server.URL+"/b", 302)
+		case "/b":
+			http.Redirect(w, r, server.URL+"/a#top", 302)
+		case "/c":
+			http.Redirect(w, r, server.URL+"/d", 302)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	p := New(func(p *Proxy) { p.FollowRedirect = true })
+	for _, test := range []struct {
+		path     string
+		status   int
+		requests int
+	}{
+		{"/a", 310, 2},
+		{"/c", 200, 2},
+	} {
+		requests = 0
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+test.path))
+		p.RequestHandler(ctx)
+		if ctx.Response.StatusCode() != test.status || requests != test.requests {
+			t.Errorf("%s: expected status %d after %d requests, got %d after %d", test.path, test.status, test.requests, ctx.Response.StatusCode(), requests)
+		}
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	u, _ := url.Parse("https://example.com/a/b?c=d#top")
+	for location, expected := range map[string]string{
+		"/login":                "https://example.com/login#top",
+		"next.html":             "https://example.com/a/next.html#top",
+		" ?e=f ":                "https://example.com/a/b?e=f#top",
+		"//cdn.example.com/x#y": "https://cdn.example.com/x#y",
+		"http://example.org/":   "http://example.org/#top",
+	} {
+		target, err := redirectLocation(u, []byte(location))
+		if err != nil || target.String() != expected {
+			t.Errorf("%q: expected %s, got %v %v", location, expected, target, err)
+		}
+	}
+	if _, err := redirectLocation(u, nil); err == nil {
+		t.Error("missing Location should be rejected")
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a/b":
+			w.Header().Set("Location", "next.html")
+			w.WriteHeader(302)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	for _, followRedirect := range []bool{true, false} {
+		p := New(func(p *Proxy) { p.FollowRedirect = followRedirect })
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+"/a/b"))
+		p.RequestHandler(ctx)
+		if followRedirect && string(ctx.Response.Body()) != "/a/next.html" {
+			t.Errorf("expected the redirect to /a/next.html to be followed, got %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+		expected := "./?mortyurl=" + url.QueryEscape(server.URL+"/a/next.html")
+		if location := string(ctx.Response.Header.Peek("Location")); !followRedirect && location != expected {
+			t.Errorf("expected Location %s, got %s", expected, location)
+		}
+	}
+}
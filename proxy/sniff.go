@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+
+	"golang.org/x/net/html/charset"
+)
+
+// XML documents recognized by their root element
+var SniffedXMLContentTypes = map[string]string{
+	"feed": "application/atom+xml",
+	"rss":  "application/rss+xml",
+	"svg":  "image/svg+xml",
+}
+
+// sniffContentType guesses the content type of a response without Content-Type header
+// from its first 512 bytes. The charset is left to the charset detection of the documents.
+func sniffContentType(body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(body))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	if mediaType == "text/xml" {
+		if root := xmlRootElement(body); SniffedXMLContentTypes[root] != "" {
+			return SniffedXMLContentTypes[root]
+		}
+	}
+	return mediaType
+}
+
+// xmlRootElement returns the local name of the first element of an XML document
+func xmlRootElement(body []byte) string {
+	if len(body) > 512 {
+		body = body[:512]
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
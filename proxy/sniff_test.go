@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"testing"
+)
+
+var sniffTestData = []*StringTestCase{
+	{"<!DOCTYPE html><html><body>x</body></html>", "text/html"},
+	{`<?xml version="1.0"?><rss version="2.0"></rss>`, "application/rss+xml"},
+	{`<?xml version="1.0"?><!-- c --><feed xmlns="http://www.w3.org/2005/Atom"></feed>`, "application/atom+xml"},
+	{`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, "image/svg+xml"},
+	{`<?xml version="1.0"?><note></note>`, "text/xml"},
+	{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+	{"plain text", "text/plain"},
+	{"\x00\x01\x02\x03", "application/octet-stream"},
+}
+
+func TestSniffContentType(t *testing.T) {
+	for _, testCase := range sniffTestData {
+		if res := sniffContentType([]byte(testCase.Input)); res != testCase.ExpectedOutput {
+			t.Errorf(`Content type sniffing error. Input: %q, Expected: "%s", Got: "%s"`, testCase.Input, testCase.ExpectedOutput, res)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// path of the statistics endpoint, enabled by the -admintoken flag
+const StatsPath = "/stats"
+
+// maximum number of recorded hosts, the next hosts are recorded under StatsOtherHosts
+const StatsMaxHosts = 10000
+
+const (
+	// label of the requests when the host labels are disabled (-telemetryhosts none)
+	StatsAllHosts = "all"
+	// label of the hosts recorded after StatsMaxHosts
+	StatsOtherHosts = "other"
+)
+
+// HostStats counts the upstream requests of a host: the errors are the transport errors and the
+// responses with a 4xx or 5xx status, the bytes are the received response bodies
+type HostStats struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+type StatsResult struct {
+	Since time.Time            `json:"since"`
+	Hosts map[string]HostStats `json:"hosts"`
+}
+
+// Stats records the HostStats of the upstream hosts, labeled like in telemetry
+type Stats struct {
+	mu        sync.Mutex
+	hostsMode string
+	since     time.Time
+	hosts     map[string]*HostStats
+}
+
+func NewStats(hostsMode string) *Stats {
+	return &Stats{hostsMode: hostsMode, since: time.Now(), hosts: make(map[string]*HostStats)}
+}
+
+// Host returns the counters of host, or nil if the statistics are disabled
+func (s *Stats) Host(host string) *HostStats {
+	if s == nil {
+		return nil
+	}
+	label := telemetryHost(host, s.hostsMode)
+	if label == "" {
+		label = StatsAllHosts
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostStats := s.hosts[label]
+	if hostStats == nil {
+		if len(s.hosts) >= StatsMaxHosts {
+			label = StatsOtherHosts
+			hostStats = s.hosts[label]
+		}
+		if hostStats == nil {
+			hostStats = &HostStats{}
+			s.hosts[label] = hostStats
+		}
+	}
+	return hostStats
+}
+
+// Snapshot returns a copy of the counters
+func (s *Stats) Snapshot() StatsResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := StatsResult{Since: s.since, Hosts: make(map[string]HostStats, len(s.hosts))}
+	for label, hostStats := range s.hosts {
+		result.Hosts[label] = HostStats{
+			Requests: atomic.LoadUint64(&hostStats.Requests),
+			Errors:   atomic.LoadUint64(&hostStats.Errors),
+			Bytes:    atomic.LoadUint64(&hostStats.Bytes),
+		}
+	}
+	return result
+}
+
+// Record counts a request, failed is true for transport errors and error status codes
+func (h *HostStats) Record(failed bool, size int) {
+	if h == nil {
+		return
+	}
+	atomic.AddUint64(&h.Requests, 1)
+	if failed {
+		atomic.AddUint64(&h.Errors, 1)
+	}
+	h.AddBytes(size)
+}
+
+func (h *HostStats) AddBytes(size int) {
+	if h == nil || size <= 0 {
+		return
+	}
+	atomic.AddUint64(&h.Bytes, uint64(size))
+}
+
+// statsReader counts the bytes of a streamed response body
+type statsReader struct {
+	io.Reader
+	stats *HostStats
+}
+
+func (r *statsReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.stats.AddBytes(n)
+	return n, err
+}
+
+// serveStats sends the statistics as JSON, the request must be authenticated like the purge requests
+func (p *Proxy) serveStats(ctx *fasthttp.RequestCtx) {
+	if !p.authorizeAdmin(ctx) {
+		return
+	}
+	if !ctx.IsGet() {
+		ctx.Response.Header.Set("Allow", fasthttp.MethodGet)
+		// HTTP status code 405 : Method Not Allowed
+		ctx.Error("method not allowed", 405)
+		return
+	}
+
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(p.Stats.Snapshot())
+}
@@ -0,0 +1,455 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/friedemannsommer/morty/contenttype"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/html/charset"
+)
+
+// newStreamClient returns the client downloading the responses which are too large for client, with the same
+// dialer (proxy, IPv6) and TLS configuration. The fasthttp client always reads the whole body, the net/http
+// client streams it. The fields of client are read when a connection is opened: they can be set after New.
+func newStreamClient(client *fasthttp.Client) *http.Client {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if client.Dial != nil {
+			return client.Dial(addr)
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: dial,
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				config := &tls.Config{}
+				if client.TLSConfig != nil {
+					config = client.TLSConfig.Clone()
+				}
+				if config.ServerName == "" {
+					config.ServerName, _, _ = net.SplitHostPort(addr)
+				}
+				tlsConn := tls.Client(conn, config)
+				// the handshake is canceled with the request
+				handshake := make(chan error, 1)
+				go func() {
+					handshake <- tlsConn.Handshake()
+				}()
+				select {
+				case err = <-handshake:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+				if err != nil {
+					_ = conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+			DisableCompression: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// content types which are sanitized: they are never streamed as they are received
+var SanitizedContentTypeFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	contenttype.NewFilterEquals("text", "html", ""),
+	contenttype.NewFilterEquals("application", "xhtml", "xml"),
+	contenttype.NewFilterEquals("text", "css", ""),
+	contenttype.NewFilterEquals("application", "rss", "xml"),
+	contenttype.NewFilterEquals("application", "atom", "xml"),
+	contenttype.NewFilterEquals("text", "vtt", ""),
+	contenttype.NewFilterEquals("image", "svg", "xml"),
+})
+
+// streamBody reads the upstream response, it is closed by fasthttp once the body has been sent
+type streamBody struct {
+	*bufio.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+	idle   *idleReader
+}
+
+func (b *streamBody) Close() error {
+	defer b.cancel()
+	return b.body.Close()
+}
+
+// idleReader cancels the upstream request when no data has been transferred for timeout
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 && r.timeout > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// disable stops the idle timeout, the event streams can be idle
+func (r *idleReader) disable() {
+	r.timeout = 0
+	r.timer.Stop()
+}
+
+// clientStream stops the sanitizer of a streamed document when the client is gone: once a write to the client
+// has failed, the reads of the document fail too
+type clientStream struct {
+	w   io.Writer
+	r   io.Reader
+	err error
+}
+
+func (c *clientStream) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(b)
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Flush sends the written data to the client, the sanitizers flush their output after the <head> element
+func (c *clientStream) Flush() error {
+	if f, ok := c.w.(interface{ Flush() error }); ok && c.err == nil {
+		c.err = f.Flush()
+	}
+	return c.err
+}
+
+func (c *clientStream) Read(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// isStreamedHTML reports whether a HTML document can be sanitized while it is downloaded:
+// the tree sanitizer, the minification and the XHTML serialization need the whole document
+func (p *Proxy) isStreamedHTML(contentType contenttype.ContentType) bool {
+	return contentType.SubType == "html" && contentType.Suffix == "" && p.SanitizerMode != SanitizerModeTree && !p.Minify
+}
+
+// isStreamedDocument reports whether a document is sanitized while it is downloaded: the stylesheets and
+// the subtitles are sanitized rule by rule
+func isStreamedDocument(contentType contenttype.ContentType) bool {
+	return (contentType.SubType == "css" || contentType.SubType == "vtt") && contentType.Suffix == ""
+}
+
+// maxSizeReader fails with fasthttp.ErrBodyTooLarge once more than n bytes have been read
+type maxSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *maxSizeReader) Read(b []byte) (int, error) {
+	if r.n < 0 {
+		return 0, fasthttp.ErrBodyTooLarge
+	}
+	// one more byte is read to tell a document of n bytes from a larger one
+	if int64(len(b)) > r.n+1 {
+		b = b[:r.n+1]
+	}
+	n, err := r.r.Read(b)
+	r.n -= int64(n)
+	if r.n < 0 {
+		return n - 1, fasthttp.ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// limitDocument limits a streamed document to DocumentMaxSize bytes, the sanitized document is cut then
+func (p *Proxy) limitDocument(r io.Reader) io.Reader {
+	if p.DocumentMaxSize <= 0 {
+		return r
+	}
+	return &maxSizeReader{r, p.DocumentMaxSize}
+}
+
+// streamHTML sanitizes a HTML document while it is downloaded, the body is closed once the document has been sent
+func (p *Proxy) streamHTML(ctx *fasthttp.RequestCtx, parsedURI *url.URL, contentTypeString string, body io.ReadCloser) {
+	s := p.htmlSanitizer(ctx, parsedURI)
+	ctx.SetContentType("text/html; charset=UTF-8")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer body.Close()
+		// the encoding is detected from the first 1024 bytes, they are awaited by the stream writer
+		r, err := charset.NewReader(p.limitDocument(body), contentTypeString)
+		if err != nil {
+			p.Logger.Warn("failed to read HTML", "url", parsedURI.String(), "error", err)
+			return
+		}
+		stream := &clientStream{w: w, r: r}
+		p.logSanitizeError(s.HTMLDocument(stream, stream))
+	})
+}
+
+// streamDocument sanitizes a stylesheet or subtitles while they are downloaded, the body is closed once
+// the document has been sent
+func (p *Proxy) streamDocument(ctx *fasthttp.RequestCtx, parsedURI *url.URL, contentType contenttype.ContentType, body *streamBody) {
+	r := p.limitDocument(p.newDocumentReader(body.Reader, contentType.String()))
+	s := p.sanitizer(parsedURI, ctx.UserValue("mortytext") != nil)
+	contentType.Parameters["charset"] = "UTF-8"
+	contentType.FilterParameters(AllowedContentTypeParameters)
+	ctx.SetContentType(contentType.String())
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer body.Close()
+		stream := &clientStream{w: w, r: r}
+		var err error
+		if contentType.SubType == "css" {
+			err = s.CSS(stream, stream)
+		} else {
+			err = s.VTT(stream, stream)
+		}
+		if err != nil {
+			p.Logger.Warn("failed to sanitize the document", "url", parsedURI.String(), "error", err)
+		}
+	})
+}
+
+// streamResponse sends req with the StreamClient and streams the response body to the client without size limit.
+// Images, fonts, media and attachments are streamed, HTML documents, stylesheets and subtitles are sanitized while
+// they are streamed, the other sanitized documents and the processed images are always buffered.
+func (p *Proxy) streamResponse(ctx *fasthttp.RequestCtx, req *fasthttp.Request, parsedURI *url.URL) {
+	p.Logger.Debug("stream", "url", parsedURI.String())
+
+	resp, body, err := p.sendStream(req, parsedURI)
+	if err != nil {
+		if err == fasthttp.ErrTimeout {
+			// HTTP status code 504 : Gateway Time-Out
+			p.serveMainPage(ctx, 504, err)
+		} else if isTLSPolicyError(err) {
+			// HTTP status code 502 : Bad Gateway
+			p.serveMainPage(ctx, 502, tlsPolicyError(err))
+		} else {
+			// HTTP status code 500 : Internal Server Error
+			p.serveMainPage(ctx, 500, err)
+		}
+		return
+	}
+
+	if resp.StatusCode == 304 {
+		_ = body.Close()
+		p.relayCacheHeaders(ctx, func(name string) []byte { return []byte(resp.Header.Get(name)) }, true)
+		ctx.SetStatusCode(304)
+		return
+	}
+	if !isRelayedStatus(resp.StatusCode) {
+		_ = body.Close()
+		p.serveMainPage(ctx, resp.StatusCode, errors.New("invalid response status: "+resp.Status))
+		return
+	}
+	if isNoContentStatus(resp.StatusCode) {
+		_ = body.Close()
+		ctx.SetStatusCode(resp.StatusCode)
+		return
+	}
+
+	// the last valid Content-Type header is used, the content type of a missing or invalid header is sniffed
+	contentType, err := contenttype.ParseContentTypeHeader(strings.Join(resp.Header.Values("Content-Type"), ","))
+	if err != nil {
+		// the error is reported by the body stream
+		sniff, _ := body.Peek(512)
+		contentType, err = contenttype.ParseContentType(sniffContentType(sniff))
+	}
+	var contentDisposition []byte
+	switch {
+	case err != nil:
+		_ = body.Close()
+		// HTTP status code 503 : Service Unavailable
+		p.serveMainPage(ctx, 503, errors.New("invalid content type"))
+		return
+	case p.EventStreams == EventStreamsRelay && resp.StatusCode == 200 &&
+		contentType.TopLevelType == "text" && contentType.SubType == "event-stream":
+		body.idle.disable()
+		p.relayEventStream(ctx, resp.StatusCode, body, p.EventStreamTimeout)
+		return
+	case StreamingContentTypeFilter(contentType):
+		_ = body.Close()
+		// HTTP status code 501 : Not Implemented
+		p.serveMainPage(ctx, 501, errors.New("streaming content is not supported "+parsedURI.String()))
+		return
+	case resp.StatusCode != 206 && p.isStreamedHTML(contentType) && resp.Header.Get("Content-Encoding") == "":
+		ctx.SetStatusCode(resp.StatusCode)
+		p.relayLocation(ctx, parsedURI, resp.StatusCode, []byte(resp.Header.Get("Location")))
+		p.streamHTML(ctx, parsedURI, contentType.String(), body)
+		return
+	case resp.StatusCode != 206 && isStreamedDocument(contentType) && resp.Header.Get("Content-Encoding") == "":
+		if ConditionalContentTypeFilter(contentType) {
+			relayValidators(ctx, []byte(resp.Header.Get("ETag")), []byte(resp.Header.Get("Last-Modified")), contentType.SubType == "css")
+			p.relayCacheHeaders(ctx, func(name string) []byte { return []byte(resp.Header.Get(name)) }, contentType.SubType == "css")
+		}
+		ctx.SetStatusCode(resp.StatusCode)
+		p.streamDocument(ctx, parsedURI, contentType, body)
+		return
+	case SanitizedContentTypeFilter(contentType) || p.isProcessedImage(contentType):
+		_ = body.Close()
+		// HTTP status code 413 : Payload Too Large
+		p.serveMainPage(ctx, 413, errors.New("document too large "+parsedURI.String()))
+		return
+	case AllowedContentTypeFilter(contentType) || (p.ProxyMedia && AllowedContentTypeMediaFilter(contentType)):
+	case p.isAttachment(contentType):
+		var upstreamDisposition []byte
+		if value := resp.Header.Get("Content-Disposition"); value != "" {
+			upstreamDisposition = []byte(value)
+		}
+		contentDisposition = contentDispositionForceAttachment(upstreamDisposition, parsedURI)
+	default:
+		_ = body.Close()
+		p.serveBlockedPage(ctx, parsedURI, contentType)
+		return
+	}
+
+	contentType.FilterParameters(AllowedContentTypeParameters)
+	ctx.SetContentType(contentType.String())
+	if ConditionalContentTypeFilter(contentType) {
+		relayValidators(ctx, []byte(resp.Header.Get("ETag")), []byte(resp.Header.Get("Last-Modified")), false)
+		p.relayCacheHeaders(ctx, func(name string) []byte { return []byte(resp.Header.Get(name)) }, false)
+	}
+	if contentDisposition != nil {
+		ctx.Response.Header.SetBytesV("Content-Disposition", contentDisposition)
+	}
+	if acceptRanges := resp.Header.Get("Accept-Ranges"); acceptRanges != "" {
+		ctx.Response.Header.Set("Accept-Ranges", acceptRanges)
+	}
+	// some servers compress the body even if it was not requested, it is decoded by the client
+	if contentEncoding := resp.Header.Get("Content-Encoding"); contentEncoding != "" {
+		ctx.Response.Header.Set("Content-Encoding", contentEncoding)
+	}
+	ctx.SetStatusCode(resp.StatusCode)
+	p.relayLocation(ctx, parsedURI, resp.StatusCode, []byte(resp.Header.Get("Location")))
+	if resp.StatusCode == 206 {
+		ctx.Response.Header.Set("Content-Range", resp.Header.Get("Content-Range"))
+	}
+	// the size is -1 if the upstream response is chunked
+	ctx.SetBodyStream(body, int(resp.ContentLength))
+}
+
+// sendStream sends req with the StreamClient, the body of the response must be closed. The request is canceled
+// if the response headers are not received within RequestTimeout, or if the body is idle for RequestTimeout:
+// fasthttp.ErrTimeout is returned then.
+func (p *Proxy) sendStream(req *fasthttp.Request, parsedURI *url.URL) (*http.Response, *streamBody, error) {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	streamReq, err := http.NewRequestWithContext(streamCtx, string(req.Header.Method()), req.URI().String(), bytes.NewReader(req.Body()))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		streamReq.Header.Add(string(key), string(value))
+	})
+	// the body is streamed as is: it should not be compressed, the Content-Encoding of the response is relayed
+	streamReq.Header.Set("Accept-Encoding", "identity")
+
+	timer := time.AfterFunc(p.RequestTimeout, cancel)
+	resp, err := p.StreamClient.Do(streamReq)
+	stats := p.Stats.Host(parsedURI.Hostname())
+	stats.Record(err != nil || resp.StatusCode >= 400, 0)
+	if err != nil {
+		timer.Stop()
+		cancel()
+		if errors.Is(err, context.Canceled) {
+			err = fasthttp.ErrTimeout
+		}
+		return nil, nil, err
+	}
+	idle := &idleReader{resp.Body, timer, p.RequestTimeout}
+	var bodyReader io.Reader = idle
+	if stats != nil {
+		bodyReader = &statsReader{idle, stats}
+	}
+	// the buffer is used to sniff the content type and the encoding
+	body := &streamBody{bufio.NewReaderSize(bodyReader, charsetPrefixSize), resp.Body, func() {
+		timer.Stop()
+		cancel()
+	}, idle}
+	return resp, body, nil
+}
+
+// isDocumentRequest reports whether the client navigates to a page, the response is likely a HTML document
+func isDocumentRequest(ctx *fasthttp.RequestCtx) bool {
+	switch string(ctx.Request.Header.Peek("Sec-Fetch-Dest")) {
+	case "document", "iframe", "frame":
+		return true
+	case "":
+		return bytes.HasPrefix(ctx.Request.Header.Peek("Accept"), []byte("text/html"))
+	}
+	return false
+}
+
+// streamsDocuments reports whether the HTML documents of the GET requests are downloaded with the StreamClient:
+// they are sanitized while they are downloaded, unless they are cached or rewritten as a whole
+func (p *Proxy) streamsDocuments(ctx *fasthttp.RequestCtx) bool {
+	return ctx.IsGet() && p.HTMLCache == nil && p.SanitizerMode != SanitizerModeTree && !p.Minify &&
+		(p.AMPPolicy == "" || p.AMPPolicy == AMPPolicyKeep) && isDocumentRequest(ctx)
+}
+
+// fetchDocument sends req with the StreamClient: a HTML document is sanitized while it is downloaded, it returns
+// true then. The other responses are read into resp like with Client, their body is limited to
+// the MaxResponseBodySize of Client.
+func (p *Proxy) fetchDocument(ctx *fasthttp.RequestCtx, req *fasthttp.Request, resp *fasthttp.Response, parsedURI *url.URL) (bool, error) {
+	streamResp, body, err := p.sendStream(req, parsedURI)
+	if err != nil {
+		return false, err
+	}
+	contentType, err := contenttype.ParseContentTypeHeader(strings.Join(streamResp.Header.Values("Content-Type"), ","))
+	if err == nil && streamResp.StatusCode == 200 && p.isStreamedHTML(contentType) && streamResp.Header.Get("Content-Encoding") == "" {
+		ctx.SetStatusCode(streamResp.StatusCode)
+		p.streamHTML(ctx, parsedURI, contentType.String(), body)
+		return true, nil
+	}
+	defer body.Close()
+
+	resp.SetStatusCode(streamResp.StatusCode)
+	for name, values := range streamResp.Header {
+		// the length is the one of the read body
+		if name == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			resp.Header.Add(name, value)
+		}
+	}
+	if err == nil && StreamingContentTypeFilter(contentType) {
+		// the body never ends, the response is rejected without it
+		return false, nil
+	}
+	limit := p.Client.MaxResponseBodySize
+	var r io.Reader = body
+	if limit > 0 {
+		r = io.LimitReader(body, int64(limit)+1)
+	}
+	if _, err := io.Copy(resp.BodyWriter(), r); err != nil {
+		if errors.Is(err, context.Canceled) {
+			err = fasthttp.ErrTimeout
+		}
+		return false, err
+	}
+	if limit > 0 && len(resp.Body()) > limit {
+		return false, fasthttp.ErrBodyTooLarge
+	}
+	return false, nil
+}
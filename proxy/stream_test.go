@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/friedemannsommer/morty/contenttype"
+	"github.com/valyala/fasthttp"
+)
+
+func TestStreamResponse(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 1<<16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encoding := r.Header.Get("Accept-Encoding"); encoding != "identity" {
+			t.Errorf("%s: expected Accept-Encoding identity, got %q", r.URL.Path, encoding)
+		}
+		switch r.URL.Path {
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/compressed.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Encoding", "gzip")
+		case "/archive.zip":
+			w.Header().Set("Content-Type", "application/zip")
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+		case "/image.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+		case "/book.epub":
+			w.Header().Set("Content-Type", "application/epub+zip")
+		default:
+			w.Header().Set("Content-Type", "application/x-unknown")
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	attachmentFilter, _ := contenttype.ParseFilter("application/epub+zip")
+	p := New(func(p *Proxy) { p.AttachmentContentTypes = attachmentFilter })
+	testCases := []struct {
+		path        string
+		status      int
+		disposition string
+		encoding    string
+	}{
+		{"/image.png", 200, "", ""},
+		{"/compressed.png", 200, "", "gzip"},
+		{"/archive.zip", 200, "attachment; filename=archive.zip", ""},
+		{"/style.css", 200, "", ""},
+		{"/image.svg", 413, "", ""},
+		{"/book.epub", 200, "attachment; filename=book.epub", ""},
+		{"/unknown", 403, "", ""},
+	}
+	for _, tc := range testCases {
+		ctx := &fasthttp.RequestCtx{}
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI(server.URL + tc.path)
+		parsedURI, _ := url.Parse(server.URL + tc.path)
+
+		p.streamResponse(ctx, req, parsedURI)
+		fasthttp.ReleaseRequest(req)
+
+		if ctx.Response.StatusCode() != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, ctx.Response.StatusCode())
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if !bytes.Equal(ctx.Response.Body(), body) {
+			t.Errorf("%s: body mismatch (%d bytes)", tc.path, len(ctx.Response.Body()))
+		}
+		if disposition := string(ctx.Response.Header.Peek("Content-Disposition")); disposition != tc.disposition {
+			t.Errorf("%s: expected Content-Disposition %q, got %q", tc.path, tc.disposition, disposition)
+		}
+		if encoding := string(ctx.Response.Header.Peek("Content-Encoding")); encoding != tc.encoding {
+			t.Errorf("%s: expected Content-Encoding %q, got %q", tc.path, tc.encoding, encoding)
+		}
+	}
+}
+
+func TestStreamHTML(t *testing.T) {
+	padding := strings.Repeat("<p>text</p>", 1<<12)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		_, _ = io.WriteString(w, "<html><head><title>caf\xe9</title></head><body><script>alert(1)</script>"+padding+"</body></html>")
+	}))
+	defer server.Close()
+
+	p := New()
+	ctx := &fasthttp.RequestCtx{}
+	req := fasthttp.AcquireRequest()
+	req.SetRequestURI(server.URL + "/page.html")
+	parsedURI, _ := url.Parse(server.URL + "/page.html")
+	p.streamResponse(ctx, req, parsedURI)
+	fasthttp.ReleaseRequest(req)
+
+	body := string(ctx.Response.Body())
+	if ctx.Response.StatusCode() != 200 || !strings.Contains(body, "<title>café</title>") ||
+		strings.Contains(body, "alert") || !strings.Contains(body, padding) || !strings.Contains(body, "mortyheader") {
+		t.Errorf("HTML stream error. Got %d: %.200s", ctx.Response.StatusCode(), body)
+	}
+}
+
+func TestStreamDocument(t *testing.T) {
+	rule := "a { background: url(/a.png) }\n"
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+		}
+		w.Header().Set("Content-Type", "text/css")
+		_, _ = io.WriteString(w, strings.Repeat(rule, 1<<12))
+	}))
+	defer server.Close()
+	expected := strings.Repeat(`a { background: url("./?mortyurl=`+url.QueryEscape(server.URL+"/a.png")+`") }`+"\n", 1<<12)
+
+	for _, maxSize := range []int64{0, int64(len(rule) * 3000)} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+"/style.css"))
+		New(func(p *Proxy) {
+			p.Client.MaxResponseBodySize = 1024
+			p.DocumentMaxSize = maxSize
+		}).RequestHandler(ctx)
+		body := string(ctx.Response.Body())
+		valid := body == expected
+		if maxSize != 0 {
+			// a document larger than DocumentMaxSize is cut after a complete rule
+			valid = len(body) < len(expected) && strings.HasPrefix(expected, body) && strings.HasSuffix(body, "}")
+		}
+		if ctx.Response.StatusCode() != 200 || !valid {
+			t.Errorf("CSS stream error (max size %d). Got %d: %.200s", maxSize, ctx.Response.StatusCode(), body)
+		}
+	}
+
+	// a POST request is never sent twice
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+"/style.css"))
+	New(func(p *Proxy) { p.Client.MaxResponseBodySize = 1024 }).RequestHandler(ctx)
+	if ctx.Response.StatusCode() != 413 || atomic.LoadInt32(&posts) != 1 {
+		t.Errorf("Expected a 413 status after a single POST request, got %d after %d requests", ctx.Response.StatusCode(), posts)
+	}
+}
+
+func TestFetchDocument(t *testing.T) {
+	release := make(chan struct{})
+	stopped := make(chan bool, 1)
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gets, 1)
+		w.Header().Set("Content-Type", "text/html")
+		// the encoding is determined from the first 1024 bytes
+		_, _ = io.WriteString(w, "<html><head><title>page</title><!--"+strings.Repeat("-", 1024)+"--></head>")
+		w.(http.Flusher).Flush()
+		switch r.URL.Path {
+		case "/page":
+			<-release
+			_, _ = io.WriteString(w, "<body><script>alert(1)</script>"+strings.Repeat("<p>text</p>", 1<<12)+"</body></html>")
+		case "/idle":
+			<-r.Context().Done()
+		case "/endless":
+			<-release
+			for i := 0; i < 1<<20; i++ {
+				if _, err := io.WriteString(w, "<p>text</p>"); err != nil {
+					stopped <- true
+					return
+				}
+			}
+			stopped <- false
+		}
+	}))
+	defer server.Close()
+
+	request := func(path string) (*fasthttp.RequestCtx, *io.PipeReader) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.Set("Sec-Fetch-Dest", "document")
+		ctx.Request.SetRequestURI("/?mortyurl=" + url.QueryEscape(server.URL+path))
+		New(func(p *Proxy) {
+			p.Client.MaxResponseBodySize = 1024
+			p.RequestTimeout = 500 * time.Millisecond
+		}).RequestHandler(ctx)
+		r, w := io.Pipe()
+		go func() {
+			_ = w.CloseWithError(ctx.Response.BodyWriteTo(w))
+		}()
+		return ctx, r
+	}
+	readHead := func(r io.Reader) string {
+		head := make([]byte, 0, 4096)
+		for !strings.Contains(string(head), "</head>") {
+			n, err := r.Read(head[len(head):cap(head)])
+			head = head[:len(head)+n]
+			if err != nil {
+				break
+			}
+		}
+		return string(head)
+	}
+
+	// the beginning of the page is sent before the upstream server has sent the whole document
+	ctx, r := request("/page")
+	head := readHead(r)
+	close(release)
+	rest, _ := io.ReadAll(r)
+	body := head + string(rest)
+	if ctx.Response.StatusCode() != 200 || !strings.Contains(head, "<title>page</title>") || strings.Contains(body, "alert") ||
+		!strings.Contains(body, strings.Repeat("<p>text</p>", 1<<12)) || atomic.LoadInt32(&gets) != 1 {
+		t.Errorf("Document stream error after %d requests. Got %d: %.200s", gets, ctx.Response.StatusCode(), body)
+	}
+
+	// an idle upstream server is disconnected after RequestTimeout
+	start := time.Now()
+	_, r = request("/idle")
+	if body, _ := io.ReadAll(r); !strings.Contains(string(body), "<title>page</title>") || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the idle document to be cut after the request timeout, got %.200s after %s", body, time.Since(start))
+	}
+
+	// the upstream download stops when the client is gone
+	_, r = request("/endless")
+	readHead(r)
+	_ = r.Close()
+	select {
+	case ok := <-stopped:
+		if !ok {
+			t.Error("Expected the upstream download to stop when the client is gone")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the upstream download to stop when the client is gone")
+	}
+}
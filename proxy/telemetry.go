@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// labels of the upstream hosts in telemetry
+const (
+	TelemetryHostsNone   = "none"
+	TelemetryHostsBucket = "bucket"
+	TelemetryHostsHash   = "hash"
+	TelemetryHostsFull   = "full"
+)
+
+const TelemetryHostBuckets = 64
+
+// telemetrySalt is generated on startup, hashed host names can't be compared across restarts or instances.
+var telemetrySalt = make([]byte, 32)
+
+func init() {
+	if _, err := rand.Read(telemetrySalt); err != nil {
+		panic(err)
+	}
+}
+
+func ValidTelemetryHostsMode(mode string) bool {
+	switch mode {
+	case TelemetryHostsNone, TelemetryHostsBucket, TelemetryHostsHash, TelemetryHostsFull:
+		return true
+	}
+	return false
+}
+
+// telemetryHost returns the label under which an upstream host is recorded in telemetry,
+// or an empty string if per-host labels are disabled.
+func telemetryHost(host string, mode string) string {
+	host = strings.ToLower(host)
+	switch mode {
+	case TelemetryHostsFull:
+		return host
+	case TelemetryHostsHash:
+		return hex.EncodeToString(telemetryHostSum(host)[:6])
+	case TelemetryHostsBucket:
+		return fmt.Sprintf("bucket-%02d", binary.BigEndian.Uint64(telemetryHostSum(host))%TelemetryHostBuckets)
+	}
+	return ""
+}
+
+func telemetryHostSum(host string) []byte {
+	mac := hmac.New(sha256.New, telemetrySalt)
+	mac.Write([]byte(host))
+	return mac.Sum(nil)
+}
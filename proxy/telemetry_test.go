@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTelemetryHost(t *testing.T) {
+	if label := telemetryHost("example.com", TelemetryHostsNone); label != "" {
+		t.Errorf(`Telemetry host error. Expected: "", Got: "%s"`, label)
+	}
+	if label := telemetryHost("Example.com", TelemetryHostsFull); label != "example.com" {
+		t.Errorf(`Telemetry host error. Expected: "example.com", Got: "%s"`, label)
+	}
+
+	hashed := telemetryHost("example.com", TelemetryHostsHash)
+	if len(hashed) != 12 || strings.Contains(hashed, "example") {
+		t.Errorf(`Telemetry host error. Unexpected hash: "%s"`, hashed)
+	}
+	if hashed != telemetryHost("EXAMPLE.COM", TelemetryHostsHash) {
+		t.Errorf("Telemetry host error. Hash is not stable")
+	}
+
+	bucket := telemetryHost("example.com", TelemetryHostsBucket)
+	if !strings.HasPrefix(bucket, "bucket-") {
+		t.Errorf(`Telemetry host error. Unexpected bucket: "%s"`, bucket)
+	}
+}
+
+func TestValidTelemetryHostsMode(t *testing.T) {
+	for _, mode := range []string{TelemetryHostsNone, TelemetryHostsBucket, TelemetryHostsHash, TelemetryHostsFull} {
+		if !ValidTelemetryHostsMode(mode) {
+			t.Errorf("%s should be valid", mode)
+		}
+	}
+	if ValidTelemetryHostsMode("all") {
+		t.Error("all should be invalid")
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"errors"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Templates are the templates of a Proxy: the injected header, the form fields and the pages
+type Templates struct {
+	BodyExtension *template.Template
+	FormExtension *template.Template
+	MainPage      *template.Template
+	ExitPage      *template.Template
+	BlockedPage   *template.Template
+	DowngradePage *template.Template
+	DownloadPage  *template.Template
+	// ErrorPages are the templates of the error pages by status code ("404") or class ("5xx"),
+	// loaded from the error_404.html and error_5xx.html files. The main page shows the other errors.
+	ErrorPages map[string]*template.Template
+}
+
+// built-in templates, parsed by init
+var builtinTemplates = &Templates{ErrorPages: map[string]*template.Template{}}
+
+var errorPageNameRegexp = regexp.MustCompile(`^([1-5][0-9][0-9]|[1-5]xx)$`)
+
+// DefaultTemplates returns a copy of the built-in templates
+func DefaultTemplates() *Templates {
+	t := *builtinTemplates
+	t.ErrorPages = make(map[string]*template.Template, len(builtinTemplates.ErrorPages))
+	for name, page := range builtinTemplates.ErrorPages {
+		t.ErrorPages[name] = page
+	}
+	return &t
+}
+
+// LoadTemplates returns the built-in templates replaced with the files found in dir.
+// Missing files keep the built-in template. layout.html replaces the layout of the main, exit and blocked pages,
+// the pages replaced by their own file don't use it.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := DefaultTemplates()
+	layoutPath := filepath.Join(dir, "layout.html")
+	if _, err := os.Stat(layoutPath); err == nil {
+		layout, err := template.ParseFiles(layoutPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := parsePages(layout, t); err != nil {
+			return nil, err
+		}
+	}
+
+	templates := []struct {
+		File     string
+		Template **template.Template
+	}{
+		{"body_extension.html", &t.BodyExtension},
+		{"form_extension.html", &t.FormExtension},
+		{"main_page.html", &t.MainPage},
+		{"exit_page.html", &t.ExitPage},
+		{"blocked_page.html", &t.BlockedPage},
+		{"downgrade_page.html", &t.DowngradePage},
+		{"download_page.html", &t.DownloadPage},
+	}
+	for _, file := range templates {
+		path := filepath.Join(dir, file.File)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		parsed, err := template.ParseFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		*file.Template = parsed
+	}
+
+	errorPaths, err := filepath.Glob(filepath.Join(dir, "error_*.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range errorPaths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "error_"), ".html")
+		if !errorPageNameRegexp.MatchString(name) {
+			return nil, errors.New("invalid error page template name: " + filepath.Base(path))
+		}
+		parsed, err := template.ParseFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		t.ErrorPages[name] = parsed
+	}
+	return t, nil
+}
+
+// errorPage returns the template of the error page of a status code, nil if there is none
+func (t *Templates) errorPage(statusCode int) *template.Template {
+	if page, ok := t.ErrorPages[strconv.Itoa(statusCode)]; ok {
+		return page
+	}
+	return t.ErrorPages[strconv.Itoa(statusCode/100)+"xx"]
+}
+
+// requestID identifies a request in the logs and the error pages: the X-Request-Id header set by a reverse proxy,
+// or the ID of the request in the fasthttp server
+func requestID(ctx *fasthttp.RequestCtx) string {
+	if id := ctx.Request.Header.Peek("X-Request-Id"); len(id) > 0 {
+		return string(id)
+	}
+	return strconv.FormatUint(ctx.ID(), 10)
+}
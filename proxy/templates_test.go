@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "morty-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "main_page.html"), []byte(`<p>{{.Error}}</p>`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err := templates.MainPage.Execute(out, HTMLMainPageParam{Error: "<x>"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `<p>&lt;x&gt;</p>` {
+		t.Errorf(`Template error. Expected: "<p>&lt;x&gt;</p>", Got: "%s"`, out.String())
+	}
+	if templates.BodyExtension != builtinTemplates.BodyExtension {
+		t.Error("Template error. The built-in body extension should be kept")
+	}
+	if builtinTemplates.MainPage == templates.MainPage {
+		t.Error("Template error. The built-in templates should not be modified")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "exit_page.html"), []byte(`{{.URL`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("Template error. An invalid template should be reported")
+	}
+}
+
+func TestLoadLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "morty-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := `<h1>{{.InstanceName}} {{.Version}}</h1>{{template "content" .}}<footer>contact</footer>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "layout.html"), []byte(layout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(WithTemplates(templates), func(p *Proxy) { p.InstanceName = "Example" })
+	ctx := &fasthttp.RequestCtx{}
+	p.serveMainPage(ctx, 404, errors.New("not found"))
+	body := string(ctx.Response.Body())
+	if !strings.HasPrefix(body, "<h1>Example "+VERSION+"</h1>") || !strings.Contains(body, "not found") ||
+		!strings.HasSuffix(body, "<footer>contact</footer>") {
+		t.Errorf("Layout error. Got: %q", body)
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	u, _ := url.Parse("https://example.com/")
+	p.serveExitMortyPage(ctx, u)
+	if body := string(ctx.Response.Body()); !strings.HasPrefix(body, "<h1>Example") || !strings.Contains(body, "https://example.com/") {
+		t.Errorf("Layout error. Got: %q", body)
+	}
+}
+
+func TestExitPageSchemes(t *testing.T) {
+	p := New()
+	for _, tc := range []struct {
+		url  string
+		link bool
+	}{
+		{"mailto:someone@example.com", true},
+		{"magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := &fasthttp.RequestCtx{}
+		p.serveExitMortyPage(ctx, u)
+		body := string(ctx.Response.Body())
+		scheme := strings.ToLower(u.Scheme)
+		if link := strings.Contains(body, `href="`+scheme+`:`); link != tc.link {
+			t.Errorf("%s: link %v, expected %v. Got: %q", tc.url, link, tc.link, body)
+		}
+		if qrcode := strings.Contains(body, "data:image/png;base64,"); qrcode != tc.link {
+			t.Errorf("%s: QR code %v, expected %v", tc.url, qrcode, tc.link)
+		}
+		if strings.Contains(body, "<script>") {
+			t.Errorf("%s: unescaped URL. Got: %q", tc.url, body)
+		}
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "morty-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"error_404.html": `<p>{{.Status}} {{.Reason}}: {{.URL}}</p>`,
+		"error_5xx.html": `<p>{{.Status}} {{.Error}} ({{.RequestID}})</p>`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builtinTemplates.ErrorPages) != 0 {
+		t.Error("Template error. The built-in templates should not be modified")
+	}
+
+	p := New(WithTemplates(templates))
+	for _, testCase := range []struct {
+		statusCode int
+		expected   string
+	}{
+		{404, `<p>404 Not Found: https://example.com/</p>`},
+		{502, `<p>502 upstream error (abc)</p>`},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/?mortyurl=https%3A%2F%2Fexample.com%2F")
+		ctx.Request.Header.Set("X-Request-Id", "abc")
+		p.serveMainPage(ctx, testCase.statusCode, errors.New("upstream error"))
+		if body := string(ctx.Response.Body()); body != testCase.expected || ctx.Response.StatusCode() != testCase.statusCode {
+			t.Errorf("Expected %d %q, got %d %q", testCase.statusCode, testCase.expected, ctx.Response.StatusCode(), body)
+		}
+	}
+
+	// the other errors are shown on the main page
+	ctx := &fasthttp.RequestCtx{}
+	p.serveMainPage(ctx, 400, errors.New("invalid URL"))
+	if !strings.Contains(string(ctx.Response.Body()), "invalid URL") || !strings.Contains(string(ctx.Response.Body()), "<html>") {
+		t.Errorf("Expected the main page, got %q", ctx.Response.Body())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "error_page.html"), []byte(`x`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("Template error. An invalid error page name should be reported")
+	}
+}
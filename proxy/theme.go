@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	HeaderPositionTop    = "top"
+	HeaderPositionBottom = "bottom"
+)
+
+// HeaderTheme is the appearance of the header injected into the pages
+type HeaderTheme struct {
+	// top or bottom of the page
+	Position string
+	// single line header with smaller fonts
+	Compact bool
+	// CSS colors
+	Background string
+	Text       string
+	Link       string
+	Border     string
+}
+
+var DefaultHeaderTheme = HeaderTheme{
+	Position:   HeaderPositionTop,
+	Background: "#FFF",
+	Text:       "#444",
+	Link:       "#3498db",
+	Border:     "#AAAAAA",
+}
+
+// hexadecimal or named CSS colors only, the colors are written into a stylesheet
+var headerColorRegexp = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// Height returns the height of the header in pixels
+func (t HeaderTheme) Height() int {
+	if t.Compact {
+		return 24
+	}
+	return 42
+}
+
+// ParseHeaderTheme returns the default theme modified by the position, the compactness and a comma separated list
+// of colors like "background=#222,text=#EEE,link=#8AB4F8,border=#000"
+func ParseHeaderTheme(position string, compact bool, colors string) (HeaderTheme, error) {
+	theme := DefaultHeaderTheme
+	if position != HeaderPositionTop && position != HeaderPositionBottom {
+		return theme, errors.New("invalid position: " + position)
+	}
+	theme.Position = position
+	theme.Compact = compact
+
+	for _, color := range strings.Split(colors, ",") {
+		if color = strings.TrimSpace(color); color == "" {
+			continue
+		}
+		nameValue := strings.SplitN(color, "=", 2)
+		if len(nameValue) != 2 || !headerColorRegexp.MatchString(strings.TrimSpace(nameValue[1])) {
+			return theme, errors.New("invalid color: " + color)
+		}
+		value := strings.TrimSpace(nameValue[1])
+		switch strings.ToLower(strings.TrimSpace(nameValue[0])) {
+		case "background":
+			theme.Background = value
+		case "text":
+			theme.Text = value
+		case "link":
+			theme.Link = value
+		case "border":
+			theme.Border = value
+		default:
+			return theme, errors.New("unknown color: " + nameValue[0])
+		}
+	}
+	return theme, nil
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderTheme(t *testing.T) {
+	theme, err := ParseHeaderTheme("bottom", true, " background=#222, Text=white,,link=#8AB4F8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := HeaderTheme{HeaderPositionBottom, true, "#222", "white", "#8AB4F8", DefaultHeaderTheme.Border}
+	if theme != expected {
+		t.Errorf("Expected %+v, got %+v", expected, theme)
+	}
+
+	for _, colors := range []string{"background", "background=red;}", "background=url(x)", "shadow=#000"} {
+		if _, err := ParseHeaderTheme("top", false, colors); err == nil {
+			t.Errorf("Expected an error for %q", colors)
+		}
+	}
+	if _, err := ParseHeaderTheme("left", false, ""); err == nil {
+		t.Error("Expected an error for an invalid position")
+	}
+}
+
+func TestHeaderThemeTemplate(t *testing.T) {
+	theme, _ := ParseHeaderTheme("bottom", true, "background=#222")
+	out := bytes.NewBuffer(nil)
+	err := builtinTemplates.BodyExtension.Execute(out, HTMLBodyExtParam{BaseURL: "https://example.com/", Theme: theme, Msg: Catalog[DefaultLanguage]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"scroll-padding-bottom: 24px",
+		"bottom: 24px !important",
+		"background: #222;",
+		"border-width: 2px 0px 0px 0;",
+		"white-space: nowrap;",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in %q", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	err = builtinTemplates.BodyExtension.Execute(out, HTMLBodyExtParam{BaseURL: "https://example.com/", Theme: DefaultHeaderTheme, Msg: Catalog[DefaultLanguage]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "top: 42px !important") || strings.Contains(out.String(), "ZgotmplZ") {
+		t.Errorf("Unexpected default header %q", out.String())
+	}
+}
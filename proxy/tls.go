@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	TLSCiphersDefault = "default"
+	TLSCiphersStrong  = "strong"
+)
+
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipher suites of the "strong" policy: forward secrecy and authenticated encryption only,
+// the TLS 1.3 cipher suites are not configurable and are always strong
+var StrongCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// error messages of crypto/tls when the server doesn't support the versions or the cipher suites of the client
+var tlsPolicyErrors = []string{
+	"tls: protocol version not supported",
+	"tls: server selected unsupported protocol version",
+	"tls: handshake failure",
+	"tls: insufficient security",
+	"tls: server chose an unconfigured cipher suite",
+}
+
+// NewTLSConfig returns the TLS configuration of the upstream connections
+func NewTLSConfig(minVersion string, ciphers string) (*tls.Config, error) {
+	version, ok := TLSVersions[minVersion]
+	if !ok {
+		return nil, errors.New("unknown TLS version: " + minVersion)
+	}
+	tlsConfig := &tls.Config{MinVersion: version}
+	switch ciphers {
+	case TLSCiphersDefault:
+	case TLSCiphersStrong:
+		tlsConfig.CipherSuites = StrongCipherSuites
+	default:
+		return nil, errors.New("unknown cipher policy: " + ciphers)
+	}
+	return tlsConfig, nil
+}
+
+// isTLSPolicyError reports whether an upstream connection failed because of the TLS policy
+func isTLSPolicyError(err error) bool {
+	for _, message := range tlsPolicyErrors {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+func tlsPolicyError(err error) error {
+	return fmt.Errorf("the server does not support the required TLS version or cipher suites: %v", err)
+}
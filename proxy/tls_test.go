@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	if _, err := NewTLSConfig("1.4", TLSCiphersDefault); err == nil {
+		t.Error("Expected an error for an unknown TLS version")
+	}
+	if _, err := NewTLSConfig("1.2", "weak"); err == nil {
+		t.Error("Expected an error for an unknown cipher policy")
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	tlsConfig, err := NewTLSConfig("1.3", TLSCiphersStrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the certificate of the test server is self-signed
+	tlsConfig.InsecureSkipVerify = true
+	client := NewClient()
+	client.TLSConfig = tlsConfig
+
+	p := New(WithClient(client))
+	ctx := &fasthttp.RequestCtx{}
+	p.ProcessUri(ctx, server.URL, 0)
+	if ctx.Response.StatusCode() != 502 || !strings.Contains(string(ctx.Response.Body()), "TLS version or cipher suites") {
+		t.Errorf("Expected the TLS policy error, got %d %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+}
+
+func TestStreamClientTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	p := New(WithClient(client))
+	// the TLS configuration is set after New: the self-signed certificate is accepted by both clients
+	client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	resp, err := p.StreamClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const XHTMLNamespace = "http://www.w3.org/1999/xhtml"
+
+// namespaces of the foreign elements, declared on their root element
+var XHTMLForeignNamespaces = map[string]string{
+	"math": "http://www.w3.org/1998/Math/MathML",
+	"svg":  "http://www.w3.org/2000/svg",
+}
+
+var xmlNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+var xmlDeclEncodingRegexp = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([A-Za-z0-9._\-]+)["']`)
+
+// renderXHTML writes a sanitized HTML document as XHTML: the elements are closed,
+// the void elements use the self-closing syntax and the text is escaped as XML.
+func renderXHTML(out io.Writer, htmlDoc []byte) error {
+	doc, err := html.Parse(bytes.NewReader(htmlDoc))
+	if err != nil {
+		return err
+	}
+	_, _ = io.WriteString(out, xml.Header)
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		renderXHTMLNode(out, n, "")
+	}
+	return nil
+}
+
+func renderXHTMLNode(out io.Writer, n *html.Node, parentNamespace string) {
+	switch n.Type {
+	case html.DoctypeNode:
+		_, _ = io.WriteString(out, "<!DOCTYPE html>\n")
+	case html.TextNode:
+		_ = xml.EscapeText(out, []byte(n.Data))
+	case html.ElementNode:
+		_, _ = io.WriteString(out, "<"+n.Data)
+		namespace := XHTMLNamespace
+		if n.Namespace != "" {
+			namespace = XHTMLForeignNamespaces[n.Namespace]
+		}
+		if namespace != parentNamespace {
+			_, _ = io.WriteString(out, ` xmlns="`+namespace+`"`)
+		}
+		for _, attr := range n.Attr {
+			// the namespaces are declared above, attribute names which are invalid in XML are dropped
+			if attr.Key == "xmlns" || attr.Namespace != "" || !xmlNameRegexp.MatchString(attr.Key) {
+				continue
+			}
+			_, _ = io.WriteString(out, " "+attr.Key+`="`)
+			_ = xml.EscapeText(out, []byte(attr.Val))
+			_, _ = io.WriteString(out, `"`)
+		}
+		if n.FirstChild == nil {
+			_, _ = io.WriteString(out, " />")
+			return
+		}
+		_, _ = io.WriteString(out, ">")
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			renderXHTMLNode(out, c, namespace)
+		}
+		_, _ = io.WriteString(out, "</"+n.Data+">")
+	}
+	// comments are removed
+}
+
+// xmlDeclEncoding returns the encoding of the XML declaration of a document, if any
+func xmlDeclEncoding(doc []byte) string {
+	if len(doc) > 1024 {
+		doc = doc[:1024]
+	}
+	if m := xmlDeclEncodingRegexp.FindSubmatch(doc); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+var xhtmlTestData = []*StringTestCase{
+	{
+		`<!DOCTYPE html><html><head><title>a &amp; b</title></head><body><p>x<br>y<img src="./?mortyurl=a&mortyhash=b" alt=""></p></body></html>`,
+		xml.Header + `<!DOCTYPE html>` + "\n" + `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>a &amp; b</title></head><body><p>x<br />y<img src="./?mortyurl=a&amp;mortyhash=b" alt="" /></p></body></html>`,
+	},
+	{
+		`<html xmlns="http://www.w3.org/1999/xhtml"><body><style>a{background:url("./?mortyurl=a&mortyhash=b")}</style><math><mi>x</mi></math><!-- c --></body></html>`,
+		xml.Header + `<html xmlns="http://www.w3.org/1999/xhtml"><head /><body><style>a{background:url(&#34;./?mortyurl=a&amp;mortyhash=b&#34;)}</style><math xmlns="http://www.w3.org/1998/Math/MathML"><mi>x</mi></math></body></html>`,
+	},
+}
+
+func TestRenderXHTML(t *testing.T) {
+	for _, testCase := range xhtmlTestData {
+		out := bytes.NewBuffer(nil)
+		if err := renderXHTML(out, []byte(testCase.Input)); err != nil {
+			t.Errorf(`XHTML error. Input: "%s", Error: %v`, testCase.Input, err)
+			continue
+		}
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`XHTML error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+		decoder := xml.NewDecoder(out)
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf(`XHTML error. Invalid XML: %v, Input: "%s"`, err, testCase.Input)
+				break
+			}
+		}
+	}
+}
+
+func TestXMLDeclEncoding(t *testing.T) {
+	testCases := []*StringTestCase{
+		{`<?xml version="1.0" encoding="ISO-8859-1"?><html/>`, "iso-8859-1"},
+		{"\n<?xml version='1.0' encoding='windows-1252' ?>", "windows-1252"},
+		{`<?xml version="1.0"?><html/>`, ""},
+		{`<html><?xml encoding="latin1"?>`, ""},
+	}
+	for _, testCase := range testCases {
+		if res := xmlDeclEncoding([]byte(testCase.Input)); res != testCase.ExpectedOutput {
+			t.Errorf(`XML encoding error. Input: "%s", Expected: "%s", Got: "%s"`, testCase.Input, testCase.ExpectedOutput, res)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD", version 1, medium error correction level
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if ecc := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(ecc, expected) {
+		t.Errorf("Expected %v, got %v", expected, ecc)
+	}
+}
+
+func TestDataCodewords(t *testing.T) {
+	// capacity of the medium error correction level
+	expected := map[int]int{1: 16, 2: 28, 7: 124, 10: 216, 20: 669, 40: 2334}
+	for version, codewords := range expected {
+		if n := numDataCodewords(version); n != codewords {
+			t.Errorf("Version %d: expected %d data codewords, got %d", version, codewords, n)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, testCase := range []struct {
+		data    string
+		version int
+	}{
+		{"https://example.com", 2},
+		{"https://example.com/" + strings.Repeat("a", 180), 10},
+		{strings.Repeat("a", 2331), 40},
+	} {
+		c, err := Encode([]byte(testCase.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Version != testCase.version || c.Size != testCase.version*4+17 {
+			t.Errorf("Expected version %d, got %d", testCase.version, c.Version)
+		}
+		// finder patterns, timing patterns and dark module
+		for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+			if !c.Dark(corner[0], corner[1]) || c.Dark(corner[0]+1, corner[1]+1) || !c.Dark(corner[0]+3, corner[1]+3) {
+				t.Errorf("Version %d: invalid finder pattern at %v", c.Version, corner)
+			}
+		}
+		if !c.Dark(8, 6) || c.Dark(9, 6) || !c.Dark(6, 10) || !c.Dark(8, c.Size-8) {
+			t.Errorf("Version %d: invalid timing pattern", c.Version)
+		}
+	}
+
+	if _, err := Encode(bytes.Repeat([]byte("a"), 2332)); err != ErrDataTooLong {
+		t.Errorf("Expected ErrDataTooLong, got %v", err)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	c, _ := Encode([]byte("morty"))
+	// the two copies of the format bits are equal
+	for i := 0; i < 8; i++ {
+		first := c.Dark(8, i)
+		if i >= 6 {
+			first = c.Dark(8, i+1)
+		}
+		if i == 7 {
+			first = c.Dark(8, 8)
+		}
+		if first != c.Dark(c.Size-1-i, 8) {
+			t.Errorf("Format bit %d differs", i)
+		}
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c, _ := Encode([]byte("https://example.com/"))
+	data, err := c.PNG(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := (c.Size + 2*QuietZone) * 2; img.Bounds().Dx() != width || img.Bounds().Dy() != width {
+		t.Errorf("Expected a %dx%d image, got %v", width, width, img.Bounds())
+	}
+}
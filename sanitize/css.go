@@ -0,0 +1,480 @@
+package sanitize
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// functions which are removed with their arguments
+var cssUnsafeFunctions = map[string]bool{
+	"expression": true,
+}
+
+// declarations which are removed with their value
+var cssUnsafeProperties = map[string]bool{
+	"-moz-binding": true,
+	"-ms-behavior": true,
+	"behavior":     true,
+}
+
+type cssTokenType int
+
+const (
+	// delimiters, numbers and everything else: copied as is
+	cssTokenOther cssTokenType = iota
+	cssTokenWhitespace
+	cssTokenComment
+	cssTokenString
+	cssTokenIdent
+	cssTokenFunction
+	cssTokenAtKeyword
+	// unquoted url(...)
+	cssTokenURL
+	// url(...) containing whitespace, quotes or parentheses: ignored by browsers
+	cssTokenBadURL
+)
+
+type cssToken struct {
+	Type cssTokenType
+	// source of the token
+	Raw []byte
+	// unescaped name of idents, functions and at-keywords, or unescaped content of strings and URLs
+	Value []byte
+}
+
+// cssTokenizer is a minimal CSS tokenizer following https://www.w3.org/TR/css-syntax-3/#tokenization
+// it only distinguishes the tokens which are needed to find and rewrite URLs.
+type cssTokenizer struct {
+	css []byte
+	pos int
+}
+
+func newCSSTokenizer(css []byte) *cssTokenizer {
+	return &cssTokenizer{css: css}
+}
+
+// Next returns the next token, or false at the end of the input.
+func (z *cssTokenizer) Next() (cssToken, bool) {
+	if z.pos >= len(z.css) {
+		return cssToken{}, false
+	}
+
+	start := z.pos
+	c := z.css[z.pos]
+	token := cssToken{Type: cssTokenOther}
+
+	switch {
+	case isCSSWhitespace(c):
+		for z.pos < len(z.css) && isCSSWhitespace(z.css[z.pos]) {
+			z.pos++
+		}
+		token.Type = cssTokenWhitespace
+	case c == '/' && z.peek(1) == '*':
+		end := bytes.Index(z.css[z.pos+2:], []byte("*/"))
+		if end == -1 {
+			z.pos = len(z.css)
+		} else {
+			z.pos += end + 4
+		}
+		token.Type = cssTokenComment
+	case c == '"' || c == '\'':
+		token.Type = cssTokenString
+		token.Value = z.consumeString(c)
+	case c == '@' && z.startsIdent(1):
+		z.pos++
+		token.Type = cssTokenAtKeyword
+		token.Value = z.consumeName()
+	case z.startsIdent(0):
+		token.Value = z.consumeName()
+		token.Type = cssTokenIdent
+		if z.peek(0) == '(' {
+			z.pos++
+			token.Type = cssTokenFunction
+			if bytes.EqualFold(token.Value, []byte("url")) {
+				token.Type, token.Value = z.consumeURL()
+			}
+		}
+	default:
+		z.pos++
+	}
+
+	token.Raw = z.css[start:z.pos]
+	return token, true
+}
+
+func (z *cssTokenizer) peek(offset int) byte {
+	if z.pos+offset < len(z.css) {
+		return z.css[z.pos+offset]
+	}
+	return 0
+}
+
+func (z *cssTokenizer) validEscape(offset int) bool {
+	return z.peek(offset) == '\\' && z.pos+offset+1 < len(z.css) && z.peek(offset+1) != '\n'
+}
+
+func (z *cssTokenizer) startsIdent(offset int) bool {
+	c := z.peek(offset)
+	switch {
+	case isCSSNameStart(c):
+		return true
+	case c == '-':
+		return isCSSNameStart(z.peek(offset+1)) || z.peek(offset+1) == '-' || z.validEscape(offset+1)
+	case c == '\\':
+		return z.validEscape(offset)
+	}
+	return false
+}
+
+func (z *cssTokenizer) consumeName() []byte {
+	name := make([]byte, 0, 16)
+	for z.pos < len(z.css) {
+		c := z.css[z.pos]
+		if isCSSNameStart(c) || c == '-' || ('0' <= c && c <= '9') {
+			name = append(name, c)
+			z.pos++
+		} else if z.validEscape(0) {
+			name = z.consumeEscape(name)
+		} else {
+			break
+		}
+	}
+	return name
+}
+
+// consumeEscape decodes the escape sequence starting with a backslash at the current position
+func (z *cssTokenizer) consumeEscape(value []byte) []byte {
+	z.pos++
+	hexEnd := z.pos
+	for hexEnd < len(z.css) && hexEnd-z.pos < 6 && isHexDigit(z.css[hexEnd]) {
+		hexEnd++
+	}
+	if hexEnd == z.pos {
+		// not a hexadecimal escape: the next rune is used as is
+		_, size := utf8.DecodeRune(z.css[z.pos:])
+		value = append(value, z.css[z.pos:z.pos+size]...)
+		z.pos += size
+		return value
+	}
+	codePoint, _ := strconv.ParseUint(string(z.css[z.pos:hexEnd]), 16, 32)
+	z.pos = hexEnd
+	if z.pos < len(z.css) && isCSSWhitespace(z.css[z.pos]) {
+		z.pos++
+	}
+	if codePoint == 0 || codePoint > utf8.MaxRune || (0xD800 <= codePoint && codePoint <= 0xDFFF) {
+		codePoint = utf8.RuneError
+	}
+	return append(value, string(rune(codePoint))...)
+}
+
+func (z *cssTokenizer) consumeString(quote byte) []byte {
+	value := make([]byte, 0, 32)
+	z.pos++
+	for z.pos < len(z.css) {
+		c := z.css[z.pos]
+		switch {
+		case c == quote:
+			z.pos++
+			return value
+		case c == '\n':
+			// bad string: the newline is not part of the token
+			return value
+		case c == '\\' && z.peek(1) == '\n':
+			// line continuation
+			z.pos += 2
+		case c == '\\' && z.pos+1 >= len(z.css):
+			z.pos++
+		case c == '\\':
+			value = z.consumeEscape(value)
+		default:
+			value = append(value, c)
+			z.pos++
+		}
+	}
+	return value
+}
+
+// consumeURL consumes the rest of a url( token. If the URL is quoted, only the function is consumed,
+// the string is the next token.
+func (z *cssTokenizer) consumeURL() (cssTokenType, []byte) {
+	ws := z.pos
+	for ws < len(z.css) && isCSSWhitespace(z.css[ws]) {
+		ws++
+	}
+	if ws < len(z.css) && (z.css[ws] == '"' || z.css[ws] == '\'') {
+		return cssTokenFunction, []byte("url")
+	}
+	z.pos = ws
+
+	value := make([]byte, 0, 32)
+	for z.pos < len(z.css) {
+		c := z.css[z.pos]
+		switch {
+		case c == ')':
+			z.pos++
+			return cssTokenURL, value
+		case isCSSWhitespace(c):
+			for z.pos < len(z.css) && isCSSWhitespace(z.css[z.pos]) {
+				z.pos++
+			}
+			if z.pos >= len(z.css) || z.css[z.pos] == ')' {
+				continue
+			}
+			z.consumeBadURL()
+			return cssTokenBadURL, nil
+		case c == '"' || c == '\'' || c == '(' || c < 0x09 || c == 0x0B || (0x0E <= c && c <= 0x1F) || c == 0x7F:
+			z.consumeBadURL()
+			return cssTokenBadURL, nil
+		case c == '\\':
+			if !z.validEscape(0) {
+				z.consumeBadURL()
+				return cssTokenBadURL, nil
+			}
+			value = z.consumeEscape(value)
+		default:
+			value = append(value, c)
+			z.pos++
+		}
+	}
+	return cssTokenURL, value
+}
+
+func (z *cssTokenizer) consumeBadURL() {
+	for z.pos < len(z.css) {
+		if z.validEscape(0) {
+			z.pos += 2
+			continue
+		}
+		z.pos++
+		if z.css[z.pos-1] == ')' {
+			return
+		}
+	}
+}
+
+func isCSSWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isCSSNameStart(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_' || c >= 0x80
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// cssQuote returns s as a double quoted CSS string, "<", ">" and "&" are escaped: the string can't end a <style> element
+func cssQuote(s string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\a `)
+		case '\r':
+			b.WriteString(`\d `)
+		case '\f':
+			b.WriteString(`\c `)
+		case '<':
+			b.WriteString(`\3c `)
+		case '>':
+			b.WriteString(`\3e `)
+		case '&':
+			b.WriteString(`\26 `)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// lastCSSRuleEnd returns the end of the last top-level rule or statement of a part of a stylesheet, 0 if there is none.
+// The tokens before a "}" or ";" delimiter are the same in the whole stylesheet.
+func lastCSSRuleEnd(css []byte) int {
+	z := newCSSTokenizer(css)
+	depth, end := 0, 0
+	for {
+		token, ok := z.Next()
+		if !ok {
+			return end
+		}
+		switch {
+		case token.Type == cssTokenFunction:
+			depth++
+		case token.Type != cssTokenOther:
+		case token.Raw[0] == '{' || token.Raw[0] == '(' || token.Raw[0] == '[':
+			depth++
+		case token.Raw[0] == '}' || token.Raw[0] == ')' || token.Raw[0] == ']':
+			if depth > 0 {
+				depth--
+			}
+			if depth == 0 && token.Raw[0] == '}' {
+				end = z.pos
+			}
+		case token.Raw[0] == ';' && depth == 0:
+			end = z.pos
+		}
+	}
+}
+
+func sanitizeCSS(s *Sanitizer, out io.Writer, css []byte) {
+	z := newCSSTokenizer(css)
+
+	// names of the functions enclosing the current token, "" for a simple block
+	var functions []string
+	// the next string is an @import URL
+	importURL := false
+
+	for {
+		token, ok := z.Next()
+		if !ok {
+			break
+		}
+
+		switch token.Type {
+		case cssTokenURL:
+			if s.TextOnly && !importURL {
+				// images and fonts are removed, stylesheets are kept
+				_, _ = io.WriteString(out, "none")
+				break
+			}
+			_, _ = io.WriteString(out, "url(")
+			writeCSSURL(s, out, token.Value)
+			_, _ = io.WriteString(out, ")")
+			importURL = false
+		case cssTokenBadURL:
+			_, _ = io.WriteString(out, "url()")
+			importURL = false
+		case cssTokenString:
+			enclosing := ""
+			if len(functions) > 0 {
+				enclosing = functions[len(functions)-1]
+			}
+			if importURL || enclosing == "url" || enclosing == "image-set" || enclosing == "-webkit-image-set" {
+				writeCSSURL(s, out, token.Value)
+			} else {
+				_, _ = out.Write(token.Raw)
+			}
+			importURL = false
+		case cssTokenWhitespace, cssTokenComment:
+			_, _ = out.Write(token.Raw)
+		case cssTokenAtKeyword:
+			_, _ = out.Write(token.Raw)
+			importURL = bytes.EqualFold(token.Value, []byte("import"))
+		case cssTokenFunction:
+			name := string(bytes.ToLower(token.Value))
+			if cssUnsafeFunctions[name] {
+				skipCSSFunction(z)
+			} else if s.TextOnly && !importURL && (name == "url" || name == "image-set" || name == "-webkit-image-set") {
+				skipCSSFunction(z)
+				_, _ = io.WriteString(out, "none")
+			} else {
+				_, _ = out.Write(token.Raw)
+				functions = append(functions, name)
+			}
+			importURL = false
+		case cssTokenIdent:
+			if cssUnsafeProperties[string(bytes.ToLower(token.Value))] && isCSSDeclaration(z) {
+				skipCSSDeclaration(z)
+			} else {
+				_, _ = out.Write(token.Raw)
+			}
+			importURL = false
+		default:
+			_, _ = out.Write(token.Raw)
+			if token.Type == cssTokenOther {
+				switch token.Raw[0] {
+				case '(':
+					functions = append(functions, "")
+				case ')':
+					if len(functions) > 0 {
+						functions = functions[:len(functions)-1]
+					}
+				}
+			}
+			importURL = false
+		}
+	}
+}
+
+// isCSSDeclaration reports whether the next token is a colon, the previous token being the property name.
+func isCSSDeclaration(z *cssTokenizer) bool {
+	lookahead := *z
+	for {
+		token, ok := lookahead.Next()
+		if !ok {
+			return false
+		}
+		if token.Type != cssTokenWhitespace && token.Type != cssTokenComment {
+			return token.Type == cssTokenOther && token.Raw[0] == ':'
+		}
+	}
+}
+
+// skipCSSFunction skips the arguments of a function up to the closing parenthesis
+func skipCSSFunction(z *cssTokenizer) {
+	depth := 1
+	for depth > 0 {
+		token, ok := z.Next()
+		if !ok {
+			return
+		}
+		if token.Type == cssTokenFunction || (token.Type == cssTokenOther && token.Raw[0] == '(') {
+			depth++
+		} else if token.Type == cssTokenOther && token.Raw[0] == ')' {
+			depth--
+		}
+	}
+}
+
+// skipCSSDeclaration skips a declaration up to and including the semicolon,
+// the closing brace of the enclosing block is kept.
+func skipCSSDeclaration(z *cssTokenizer) {
+	depth := 0
+	for {
+		token, ok := z.Next()
+		if !ok {
+			return
+		}
+		if token.Type == cssTokenFunction {
+			depth++
+			continue
+		}
+		if token.Type != cssTokenOther {
+			continue
+		}
+		switch token.Raw[0] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']':
+			depth--
+		case '}':
+			if depth == 0 {
+				z.pos -= len(token.Raw)
+				return
+			}
+			depth--
+		case ';':
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// writeCSSURL writes the proxified URL as a CSS string, an empty string is written if the URL can't be proxified
+// or if its host is blocked.
+func writeCSSURL(s *Sanitizer, out io.Writer, uri []byte) {
+	proxifiedURI, err := s.ProxifyURI(uri)
+	if err != nil || s.isBlockedURI(uri) {
+		proxifiedURI = ""
+	}
+	_, _ = io.WriteString(out, cssQuote(proxifiedURI))
+}
@@ -0,0 +1,111 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var cssTestData = []*StringTestCase{
+	{
+		`html { background: url(./a.jpg); }`,
+		`html { background: url("./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa.jpg"); }`,
+	},
+	{
+		`a { background: URL( 'b.png' ) }`,
+		`a { background: URL( "./?mortyurl=http%3A%2F%2F127.0.0.1%2Fb.png" ) }`,
+	},
+	{
+		`@import "c.css"; @import url(d.css) screen;`,
+		`@import "./?mortyurl=http%3A%2F%2F127.0.0.1%2Fc.css"; @import url("./?mortyurl=http%3A%2F%2F127.0.0.1%2Fd.css") screen;`,
+	},
+	{
+		`a { background-image: image-set("e.png" 1x, url(f.png) 2x); content: "url(g.png)" }`,
+		`a { background-image: image-set("./?mortyurl=http%3A%2F%2F127.0.0.1%2Fe.png" 1x, url("./?mortyurl=http%3A%2F%2F127.0.0.1%2Ff.png") 2x); content: "url(g.png)" }`,
+	},
+	{
+		`/* url(h.png) */ a { background: u\72l(\68 .png) }`,
+		`/* url(h.png) */ a { background: url("./?mortyurl=http%3A%2F%2F127.0.0.1%2Fh.png") }`,
+	},
+	{
+		`a { background: url(i j.png) }`,
+		`a { background: url() }`,
+	},
+	{
+		`a { background: url(javascript:alert(1)) }`,
+		`a { background: url()) }`,
+	},
+	{
+		`a { background: url("javascript:alert(1)") }`,
+		`a { background: url("") }`,
+	},
+	{
+		`a { width: expression(alert(document.cookie)); color: red }`,
+		`a { width: ; color: red }`,
+	},
+	{
+		`a { width: e\78pression(alert(1)) }`,
+		`a { width:  }`,
+	},
+	{
+		`a { -moz-binding: url("x.xml#xss"); color: red } b { BEHAVIOR: url(x.htc) }`,
+		`a {  color: red } b { }`,
+	},
+	{
+		`a { transition-behavior: allow-discrete; }`,
+		`a { transition-behavior: allow-discrete; }`,
+	},
+}
+
+func TestCSSSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	for _, testCase := range cssTestData {
+		out := bytes.NewBuffer(nil)
+		sanitizeCSS(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`CSS sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
+
+func TestStyleElementEscape(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	input := `<style>a{background:url(x#\3c /style\3e \3c script\3e alert\28 1\29 \3c /script\3e )}</style>`
+	out := bytes.NewBuffer(nil)
+	sanitizeHTML(s, out, []byte(input))
+	expected := `<style>a{background:url("./?mortyurl=http%3A%2F%2F127.0.0.1%2Fx#%3C/style%3E%3Cscript%3Ealert(1)%3C/script%3E")}</style>`
+	if out.String() != expected {
+		t.Errorf(`Style element error. Input: "%s", Expected: "%s", Got: "%s"`, input, expected, out.String())
+	}
+	if got := cssQuote(`</style>&`); got != `"\3c /style\3e \26 "` {
+		t.Errorf(`CSS quote error. Expected: "\3c /style\3e \26 ", Got: %s`, got)
+	}
+}
+
+func TestCSSChunks(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, css := range []string{
+		strings.Repeat(`a { background: url(./a.png) } /* ;} */ @import "c.css"; b { content: "}\";" } @media print { c { x: expression(1) } }`+"\n", 2000),
+		// a single rule larger than the buffer
+		"a { " + strings.Repeat("background: url(./a.png); ", 5000) + "}",
+	} {
+		expected := bytes.NewBuffer(nil)
+		sanitizeCSS(&Sanitizer{BaseURL: u}, expected, []byte(css))
+		out := bytes.NewBuffer(nil)
+		if err := (&Sanitizer{BaseURL: u}).CSS(out, iotest.HalfReader(strings.NewReader(css))); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("The stylesheet sanitized in chunks differs from the whole stylesheet (%d and %d bytes)", out.Len(), expected.Len())
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// namespaces of the elements rendered by the browsers: the elements are removed, their text is kept
+var feedUnsafeNamespaces = map[string]bool{
+	"http://www.w3.org/1998/Math/MathML":   true,
+	"http://www.w3.org/1999/XSL/Transform": true,
+	"http://www.w3.org/1999/xhtml":         true,
+	"http://www.w3.org/1999/xlink":         true,
+	"http://www.w3.org/2000/svg":           true,
+}
+
+// elements containing an URL: RSS links, images and comments, Atom icons, logos and authors
+var feedURLElements = map[string]bool{
+	"comments": true,
+	"docs":     true,
+	"icon":     true,
+	"link":     true,
+	"logo":     true,
+	"uri":      true,
+	"url":      true,
+}
+
+// attributes containing an URL: Atom links, enclosures and media
+var feedURLAttributes = map[string]bool{
+	"href": true,
+	"src":  true,
+	"url":  true,
+}
+
+// elements containing escaped HTML: RSS descriptions and content:encoded
+var feedHTMLElements = map[string]bool{
+	"description": true,
+	"encoded":     true,
+}
+
+// Atom text constructs: they contain escaped HTML when their type is "html"
+var feedTextConstructs = map[string]bool{
+	"content":  true,
+	"rights":   true,
+	"subtitle": true,
+	"summary":  true,
+	"title":    true,
+}
+
+type feedElement struct {
+	// prefixes declared by the element
+	namespaces map[string]string
+	// the element is not written
+	unsafe bool
+	// the text is not written
+	skipText bool
+	// the text is buffered: it is an URL or HTML
+	urlText  bool
+	htmlText bool
+}
+
+// sanitizeFeed writes a RSS or Atom feed where the links, the enclosures and the images are proxified by morty.
+// The URLs are absolute (resolved against mortyURL) since feed readers don't resolve them like browsers do.
+// The HTML of the descriptions is replaced by its (escaped) text.
+func sanitizeFeed(s *Sanitizer, out io.Writer, mortyURL *url.URL, feed []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(feed))
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	_, _ = io.WriteString(out, xml.Header)
+
+	var stack []*feedElement
+	text := bytes.NewBuffer(nil)
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &feedElement{namespaces: make(map[string]string)}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					e.namespaces[attr.Name.Local] = attr.Value
+				} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					e.namespaces[""] = attr.Value
+				}
+			}
+			stack = append(stack, e)
+
+			var parent *feedElement
+			if len(stack) > 1 {
+				parent = stack[len(stack)-2]
+				e.skipText = parent.skipText
+				e.htmlText = parent.htmlText
+			}
+
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case feedUnsafeNamespaces[feedNamespace(stack, t.Name.Space)]:
+				e.unsafe = true
+				e.skipText = e.skipText || name == "script" || name == "style"
+			case parent != nil && parent.htmlText:
+				// HTML of a RSS description which is not escaped: its text is kept
+				e.unsafe = true
+			case parent != nil && parent.urlText:
+				e.unsafe = true
+				e.skipText = true
+			case feedURLElements[name]:
+				e.urlText = !hasXMLAttr(t.Attr, "href")
+			case feedHTMLElements[name]:
+				e.htmlText = true
+			case feedTextConstructs[name]:
+				e.htmlText = strings.EqualFold(xmlAttr(t.Attr, "type"), "html")
+			}
+			if e.unsafe {
+				break
+			}
+			if e.urlText || e.htmlText {
+				text.Reset()
+			}
+
+			_, _ = io.WriteString(out, "<"+xmlName(t.Name))
+			for _, attr := range t.Attr {
+				sanitizeFeedAttr(s, out, mortyURL, stack, attr)
+			}
+			_, _ = io.WriteString(out, ">")
+		case xml.EndElement:
+			if len(stack) == 0 {
+				break
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if e.unsafe {
+				break
+			}
+			if e.urlText {
+				writeXMLText(out, feedURL(s, mortyURL, text.Bytes()))
+			} else if e.htmlText && (len(stack) == 0 || !stack[len(stack)-1].htmlText) {
+				// feed readers display the content as HTML
+				writeXMLText(out, html.EscapeString(htmlText(text.Bytes())))
+			}
+			_, _ = io.WriteString(out, "</"+xmlName(t.Name)+">")
+		case xml.CharData:
+			if len(stack) == 0 {
+				break
+			}
+			e := stack[len(stack)-1]
+			switch {
+			case e.skipText:
+			case e.urlText || e.htmlText:
+				text.Write(t)
+			default:
+				writeXMLText(out, string(t))
+			}
+		}
+		// comments, processing instructions (stylesheets) and directives (DTD) are removed
+	}
+}
+
+func sanitizeFeedAttr(s *Sanitizer, out io.Writer, mortyURL *url.URL, stack []*feedElement, attr xml.Attr) {
+	if attr.Name.Space == "xml" && attr.Name.Local == "base" {
+		// the URLs are absolute
+		return
+	}
+	if attr.Name.Space != "" && attr.Name.Space != "xmlns" && feedUnsafeNamespaces[feedNamespace(stack, attr.Name.Space)] {
+		return
+	}
+	value := attr.Value
+	if attr.Name.Space != "xmlns" && feedURLAttributes[strings.ToLower(attr.Name.Local)] {
+		value = feedURL(s, mortyURL, []byte(value))
+	}
+	_, _ = io.WriteString(out, " "+xmlName(attr.Name)+`="`)
+	writeXMLText(out, value)
+	_, _ = io.WriteString(out, `"`)
+}
+
+// feedNamespace returns the namespace bound to prefix
+func feedNamespace(stack []*feedElement, prefix string) string {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if ns, ok := stack[i].namespaces[prefix]; ok {
+			return ns
+		}
+	}
+	return ""
+}
+
+// feedURL returns the absolute morty URL of uri, or an empty string if uri is not a HTTP(S) URL
+func feedURL(s *Sanitizer, mortyURL *url.URL, uri []byte) string {
+	uri, scheme := sanitizeURI(bytes.TrimSpace(uri))
+	if len(uri) == 0 || (scheme != "" && scheme != "http:" && scheme != "https:") {
+		return ""
+	}
+	u, err := url.Parse(string(uri))
+	if err != nil {
+		return ""
+	}
+	fragment := ""
+	if u.Fragment != "" {
+		fragment = "#" + u.Fragment
+	}
+	u.Fragment = ""
+	if u = s.runURLHooks(mergeURIs(s.BaseURL, u)); u == nil {
+		return ""
+	}
+	proxified, err := url.Parse(s.ProxifiedURL(u, fragment))
+	if err != nil {
+		return ""
+	}
+	return mortyURL.ResolveReference(proxified).String()
+}
+
+// htmlText returns the text of a HTML fragment
+func htmlText(htmlDoc []byte) string {
+	decoder := html.NewTokenizer(bytes.NewReader(htmlDoc))
+	var out strings.Builder
+	skip := false
+	for {
+		switch decoder.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(out.String())
+		case html.TextToken:
+			if !skip {
+				out.Write(decoder.Text())
+			}
+		case html.StartTagToken:
+			tag, _ := decoder.TagName()
+			skip = bytes.Equal(tag, []byte("script")) || bytes.Equal(tag, []byte("style"))
+		case html.EndTagToken:
+			skip = false
+		}
+	}
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func xmlAttr(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func hasXMLAttr(attrs []xml.Attr, name string) bool {
+	for _, attr := range attrs {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+func writeXMLText(out io.Writer, s string) {
+	_ = xml.EscapeText(out, []byte(s))
+}
@@ -0,0 +1,38 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+var feedTestData = []*StringTestCase{
+	{
+		`<?xml version="1.0" encoding="ISO-8859-1"?><?xml-stylesheet href="s.xsl" type="text/xsl"?><!DOCTYPE rss><rss version="2.0"><channel><title>caf` + "\xe9" + `</title><link>http://example.com/</link><item><link> /a?x=1#p </link><description>&lt;p onclick="x()"&gt;a &amp;amp;lt;b&lt;script&gt;s()&lt;/script&gt;&lt;/p&gt;</description><enclosure url="/e.mp3" length="1" type="audio/mpeg"/></item></channel></rss>`,
+		`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<rss version="2.0"><channel><title>café</title><link>http://morty.local/?mortyurl=http%3A%2F%2Fexample.com%2F</link><item><link>http://morty.local/?mortyurl=http%3A%2F%2Fexample.com%2Fa%3Fx%3D1#p</link><description>a &amp;amp;lt;b</description><enclosure url="http://morty.local/?mortyurl=http%3A%2F%2Fexample.com%2Fe.mp3" length="1" type="audio/mpeg"></enclosure></item></channel></rss>`,
+	},
+	{
+		`<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/"><link rel="self" href="http://example.com/feed"/><entry><link href="javascript:alert(1)"/><summary type="html">&lt;b&gt;x&lt;/b&gt;</summary><content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>y</p><script>z()</script></div></content><media:thumbnail url="t.png"/></entry></feed>`,
+		`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/"><link rel="self" href="http://morty.local/?mortyurl=http%3A%2F%2Fexample.com%2Ffeed"></link><entry><link href=""></link><summary type="html">x</summary><content type="xhtml">y</content><media:thumbnail url="http://morty.local/?mortyurl=http%3A%2F%2Fexample.com%2Ft.png"></media:thumbnail></entry></feed>`,
+	},
+}
+
+func TestFeedSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://example.com/feed")
+	mortyURL, _ := url.Parse("http://morty.local/")
+	for _, testCase := range feedTestData {
+		out := bytes.NewBuffer(nil)
+		if err := sanitizeFeed(&Sanitizer{BaseURL: u}, out, mortyURL, []byte(testCase.Input)); err != nil {
+			t.Errorf(`Feed sanitizer error. Input: "%s", Error: %v`, testCase.Input, err)
+			continue
+		}
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`Feed sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
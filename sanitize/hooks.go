@@ -0,0 +1,93 @@
+package sanitize
+
+import "net/url"
+
+// Attribute is an attribute of a start tag passed to the hooks
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// ElementHook is called for each allowed start tag of a HTML document, before its attributes are sanitized.
+// It returns the attributes of the element, modified or not. The element and its content are removed if it
+// returns false. The unsafe elements, like <script>, are removed before the hooks are called.
+type ElementHook interface {
+	Element(tag string, attrs []Attribute) ([]Attribute, bool)
+}
+
+// AttributeHook is called for each attribute returned by the element hooks, before it is sanitized.
+// It returns the value of the attribute, the attribute is removed if it returns false.
+type AttributeHook interface {
+	Attribute(tag, name, value string) (string, bool)
+}
+
+// URLHook is called for each absolute URL before it is proxified: in HTML documents, stylesheets, SVG images
+// and feeds. It returns the URL to proxify instead, the URL is removed if it returns nil.
+type URLHook interface {
+	URL(u *url.URL) *url.URL
+}
+
+// ElementHookFunc is a function used as an ElementHook
+type ElementHookFunc func(tag string, attrs []Attribute) ([]Attribute, bool)
+
+func (f ElementHookFunc) Element(tag string, attrs []Attribute) ([]Attribute, bool) {
+	return f(tag, attrs)
+}
+
+// AttributeHookFunc is a function used as an AttributeHook
+type AttributeHookFunc func(tag, name, value string) (string, bool)
+
+func (f AttributeHookFunc) Attribute(tag, name, value string) (string, bool) {
+	return f(tag, name, value)
+}
+
+// URLHookFunc is a function used as an URLHook
+type URLHookFunc func(u *url.URL) *url.URL
+
+func (f URLHookFunc) URL(u *url.URL) *url.URL {
+	return f(u)
+}
+
+// runHooks applies the element and attribute hooks to a start tag, it returns false if the element is removed.
+// The attributes are only copied if there are hooks.
+func (s *Sanitizer) runHooks(tag []byte, attrs []htmlAttr) ([]htmlAttr, bool) {
+	if len(s.ElementHooks) == 0 && len(s.AttributeHooks) == 0 {
+		return attrs, true
+	}
+
+	tagName := string(tag)
+	hookAttrs := make([]Attribute, len(attrs))
+	for i, attr := range attrs {
+		hookAttrs[i] = Attribute{string(attr.Name), string(attr.Value)}
+	}
+	for _, hook := range s.ElementHooks {
+		var keep bool
+		if hookAttrs, keep = hook.Element(tagName, hookAttrs); !keep {
+			return attrs, false
+		}
+	}
+
+	attrs = attrs[:0]
+	for _, attr := range hookAttrs {
+		value, keep := attr.Value, true
+		for _, hook := range s.AttributeHooks {
+			if value, keep = hook.Attribute(tagName, attr.Name, value); !keep {
+				break
+			}
+		}
+		if keep {
+			attrs = append(attrs, htmlAttr{[]byte(attr.Name), []byte(value)})
+		}
+	}
+	return attrs, true
+}
+
+// runURLHooks returns the URL to proxify instead of u, or nil if the URL is removed
+func (s *Sanitizer) runURLHooks(u *url.URL) *url.URL {
+	for _, hook := range s.URLHooks {
+		if u = hook.URL(u); u == nil {
+			return nil
+		}
+	}
+	return u
+}
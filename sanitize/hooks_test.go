@@ -0,0 +1,52 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{
+		BaseURL:      u,
+		IframePolicy: IframePolicyAll,
+		ElementHooks: []ElementHook{ElementHookFunc(func(tag string, attrs []Attribute) ([]Attribute, bool) {
+			for _, attr := range attrs {
+				if attr.Name == "src" && strings.Contains(attr.Value, "tracker.example") {
+					return attrs, false
+				}
+			}
+			if tag == "p" {
+				// the unsafe attributes added by the hooks are sanitized
+				attrs = append(attrs, Attribute{"onclick", "alert(1)"}, Attribute{"title", "hooked"})
+			}
+			return attrs, true
+		})},
+		AttributeHooks: []AttributeHook{AttributeHookFunc(func(tag, name, value string) (string, bool) {
+			return value, name != "class"
+		})},
+		URLHooks: []URLHook{URLHookFunc(func(u *url.URL) *url.URL {
+			switch u.Host {
+			case "www.youtube.com":
+				u.Host = "invidious.example"
+			case "ads.example":
+				return nil
+			}
+			return u
+		})},
+	}
+
+	input := `<p class="x">a</p><img src="http://tracker.example/p.gif"><div><span>b</span></div>` +
+		`<iframe src="https://www.youtube.com/embed/x"></iframe><a href="http://ads.example/">c</a>` +
+		`<div style="background: url(https://www.youtube.com/i.png)"></div>`
+	expected := `<p title="hooked">a</p><div><span>b</span></div>` +
+		`<iframe src="./?mortyurl=https%3A%2F%2Finvidious.example%2Fembed%2Fx" sandbox="allow-forms"></iframe><a href="">c</a>` +
+		`<div style="background: url(&#34;./?mortyurl=https%3A%2F%2Finvidious.example%2Fi.png&#34;)"></div>`
+	out := bytes.NewBuffer(nil)
+	sanitizeHTML(s, out, []byte(input))
+	if out.String() != expected {
+		t.Errorf(`Hooks error. Expected: "%s", Got: "%s"`, expected, out.String())
+	}
+}
@@ -0,0 +1,750 @@
+package sanitize
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/friedemannsommer/morty/contenttype"
+	"golang.org/x/net/html"
+)
+
+// formTargetURL returns the URL a form is submitted to.
+// Browsers replace the query of the action URL by the fields of GET forms, so it is removed as well.
+func formTargetURL(s *Sanitizer, attrs []htmlAttr) string {
+	form := parseFormTarget(s, attrs, "action", "method", htmlForm{s.BaseURL, "get"})
+	return form.submissionURL().String()
+}
+
+type htmlForm struct {
+	Action *url.URL
+	Method string
+}
+
+// parseFormTarget overrides the action and the method of form with the given attributes
+func parseFormTarget(s *Sanitizer, attrs []htmlAttr, actionAttr, methodAttr string, form htmlForm) htmlForm {
+	for _, attr := range attrs {
+		switch string(attr.Name) {
+		case actionAttr:
+			if actionURL, err := url.Parse(string(bytes.TrimSpace(attr.Value))); err == nil {
+				form.Action = mergeURIs(s.BaseURL, actionURL)
+			}
+		case methodAttr:
+			form.Method = strings.ToLower(strings.TrimSpace(string(attr.Value)))
+		}
+	}
+	return form
+}
+
+// submissionURL returns the URL requested by the browser, the query of the action is replaced by the fields of GET forms
+func (f htmlForm) submissionURL() *url.URL {
+	formURL := *f.Action
+	formURL.Fragment = ""
+	if f.Method != "post" {
+		formURL.RawQuery = ""
+		formURL.ForceQuery = false
+	}
+	return &formURL
+}
+
+// sanitizeSubmitterAttrs writes the formaction, formmethod and formenctype attributes of a submit button.
+// The browser replaces the query of the formaction URL for GET submissions,
+// so they are sent to morty as POST requests with the "mortyget" parameter.
+func sanitizeSubmitterAttrs(s *Sanitizer, out io.Writer, attrs []htmlAttr, form htmlForm) {
+	submitter := parseFormTarget(s, attrs, "formaction", "formmethod", form)
+	if submitter == form {
+		return
+	}
+	switch submitter.Method {
+	case "post":
+		uri := s.ProxifiedURL(submitter.submissionURL(), "")
+		writeAttr(out, []byte("formaction"), []byte(uri))
+		_, _ = io.WriteString(out, ` formmethod="post"`)
+		for _, attr := range attrs {
+			if bytes.Equal(attr.Name, []byte("formenctype")) && formEncTypes[string(bytes.ToLower(attr.Value))] {
+				writeAttr(out, attr.Name, attr.Value)
+			}
+		}
+	case "dialog":
+		_, _ = io.WriteString(out, ` formmethod="dialog"`)
+	default:
+		uri := s.ProxifiedURL(submitter.submissionURL(), "") + "&mortyget=1"
+		writeAttr(out, []byte("formaction"), []byte(uri))
+		_, _ = io.WriteString(out, ` formmethod="post" formenctype="application/x-www-form-urlencoded"`)
+	}
+}
+
+// sanitizeHTMLDocument sanitizes a whole document, the morty header is injected even if there is no </body>
+func sanitizeHTMLDocument(s *Sanitizer, out io.Writer, r io.Reader) {
+	sanitizeHTMLReader(s, out, r)
+	if !s.bodyInjected {
+		injectBodyExtension(s, out)
+	}
+}
+
+func sanitizeHTML(s *Sanitizer, out io.Writer, htmlDoc []byte) {
+	sanitizeHTMLReader(s, out, bytes.NewReader(htmlDoc))
+}
+
+// sanitizeHTMLReader sanitizes the HTML read from r: the output is written while the input is read
+func sanitizeHTMLReader(s *Sanitizer, out io.Writer, r io.Reader) {
+	decoder := html.NewTokenizer(r)
+	decoder.AllowCDATA(true)
+
+	unsafeElements := make([][]byte, 0, 8)
+	// reused by every start tag: the attributes are only valid until the next token
+	var attrs []htmlAttr
+	mathDepth := 0
+	baseSeen := false
+	var baseTarget []byte
+	form := htmlForm{s.BaseURL, "get"}
+	state := stateDefault
+	for {
+		token := decoder.Next()
+		if token == html.ErrorToken {
+			err := decoder.Err()
+			if err != io.EOF {
+				s.fail(err)
+			}
+			break
+		}
+
+		if len(unsafeElements) == 0 {
+
+			switch token {
+			case html.StartTagToken, html.SelfClosingTagToken:
+				tag, hasAttrs := decoder.TagName()
+				safe := !unsafeHTMLElements[string(tag)] ||
+					(bytes.Equal(tag, []byte("iframe")) && s.IframePolicy != "" && s.IframePolicy != IframePolicyNone)
+				if mathDepth > 0 && mathBreakoutElements[string(tag)] {
+					// like the HTML parsers, these elements close the unclosed <math> elements
+					mathDepth = 0
+				}
+				inMath := mathDepth > 0 || bytes.Equal(tag, []byte("math"))
+				if inMath {
+					// only the presentation MathML subset is allowed inside <math>
+					safe = mathMLSafeElements[string(tag)]
+				}
+				if s.TextOnly && textOnlyUnsafeElements[string(tag)] {
+					safe = false
+				}
+				attrs = attrs[:0]
+				if safe && hasAttrs {
+					for {
+						attrName, attrValue, moreAttr := decoder.TagAttr()
+						attrs = append(attrs, htmlAttr{attrName, attrValue})
+						if !moreAttr {
+							break
+						}
+					}
+				}
+				if safe {
+					attrs, safe = s.runHooks(tag, attrs)
+				}
+				if safe && s.DropTrackingPixels && bytes.Equal(tag, []byte("img")) && s.isTrackingPixel(attrs) {
+					safe = false
+				}
+				if safe && s.BlockedHost != nil && s.isBlockedSubresource(tag, attrs) {
+					safe = false
+				}
+				if !safe {
+					if token != html.SelfClosingTagToken && !voidElements[string(tag)] {
+						var unsafeTag = make([]byte, len(tag))
+						copy(unsafeTag, tag)
+						unsafeElements = append(unsafeElements, unsafeTag)
+					}
+					break
+				}
+				if inMath {
+					writeTag(out, "<", tag)
+					sanitizeMathMLAttrs(s, out, attrs)
+					if token == html.SelfClosingTagToken {
+						_, _ = io.WriteString(out, " />")
+					} else {
+						_, _ = io.WriteString(out, ">")
+						if bytes.Equal(tag, []byte("math")) {
+							mathDepth++
+						}
+					}
+					break
+				}
+				if bytes.Equal(tag, []byte("base")) {
+					baseSeen, baseTarget = sanitizeBaseTag(s, out, attrs, baseSeen, baseTarget)
+					break
+				}
+				if bytes.Equal(tag, []byte("noscript")) {
+					state = stateInNoscript
+					break
+				}
+				if bytes.Equal(tag, []byte("iframe")) {
+					// the iframe content is a fallback which is never displayed: skip it like an unsafe element
+					if token != html.SelfClosingTagToken {
+						unsafeElements = append(unsafeElements, []byte("iframe"))
+					}
+					sanitizeIframeTag(s, out, attrs)
+					break
+				}
+				if bytes.Equal(tag, []byte("link")) {
+					sanitizeLinkTag(s, out, attrs)
+					break
+				}
+
+				if bytes.Equal(tag, []byte("meta")) {
+					sanitizeMetaTag(s, out, attrs)
+					break
+				}
+
+				// <embed> is never written, <object> is written without its data unless it is an image
+				isEmbed := bytes.Equal(tag, []byte("embed"))
+				if isEmbed || bytes.Equal(tag, []byte("object")) {
+					if sanitizeObjectTag(s, out, attrs) && !isEmbed && token != html.SelfClosingTagToken {
+						// the fallback content is replaced by the image
+						unsafeElements = append(unsafeElements, []byte("object"))
+						break
+					}
+					if isEmbed {
+						break
+					}
+				}
+
+				writeTag(out, "<", tag)
+
+				if len(attrs) > 0 {
+					// the links inherit the target of the base element, so the noopener relation is added to them
+					if s.TargetPolicy == TargetPolicyNoopener && baseTarget != nil && targetElements[string(tag)] && !hasAttr(attrs, "target") {
+						attrs = append(attrs, htmlAttr{[]byte("target"), baseTarget})
+					}
+					if bytes.Equal(tag, []byte("button")) || bytes.Equal(tag, []byte("input")) {
+						sanitizeSubmitterAttrs(s, out, attrs, form)
+					}
+					sanitizeAttrs(s, out, attrs)
+				}
+
+				if token == html.SelfClosingTagToken {
+					_, _ = io.WriteString(out, " />")
+				} else {
+					_, _ = io.WriteString(out, ">")
+					if bytes.Equal(tag, []byte("style")) {
+						state = stateInStyle
+					}
+				}
+
+				if bytes.Equal(tag, []byte("head")) {
+					if s.Head != "" {
+						_, _ = io.WriteString(out, s.Head)
+					} else {
+						_, _ = io.WriteString(out, DefaultHead)
+					}
+				}
+
+				if bytes.Equal(tag, []byte("form")) {
+					form = parseFormTarget(s, attrs, "action", "method", htmlForm{s.BaseURL, "get"})
+				}
+
+				if bytes.Equal(tag, []byte("form")) && !s.NoHeader {
+					urlStr := formTargetURL(s, attrs)
+					var key string
+					if s.Key != nil {
+						key = Hash(urlStr, s.Key)
+					}
+					param := FormExtensionParam{urlStr, key, s.TextOnlyParam}
+					if s.FormExtension != nil {
+						s.fail(s.FormExtension(out, param))
+					} else {
+						s.fail(DefaultFormExtension.Execute(out, param))
+					}
+				}
+
+			case html.EndTagToken:
+				tag, _ := decoder.TagName()
+				writeEndTag := true
+				switch string(tag) {
+				case "body":
+					injectBodyExtension(s, out)
+				case "form":
+					form = htmlForm{s.BaseURL, "get"}
+				case "math":
+					if mathDepth > 0 {
+						mathDepth--
+					}
+				case "style":
+					state = stateDefault
+				case "noscript":
+					state = stateDefault
+					writeEndTag = false
+				}
+				// skip noscript tags - only the tag, not the content, because javascript is sanitized
+				if writeEndTag {
+					writeTag(out, "</", tag)
+					_, _ = io.WriteString(out, ">")
+				}
+				if bytes.Equal(tag, []byte("head")) {
+					// the browser can load the stylesheets while the rest of the document is sanitized
+					flushOutput(out)
+				}
+
+			case html.TextToken:
+				switch state {
+				case stateDefault:
+					_, _ = out.Write(decoder.Raw())
+				case stateInStyle:
+					sanitizeCSS(s, out, decoder.Raw())
+				case stateInNoscript:
+					sanitizeHTML(s, out, decoder.Raw())
+				}
+
+			case html.CommentToken:
+				// ignore comment. TODO : parse IE conditional comment
+			case html.DoctypeToken:
+				_, _ = out.Write(decoder.Raw())
+			}
+		} else {
+			switch token {
+			case html.StartTagToken, html.SelfClosingTagToken:
+				tag, _ := decoder.TagName()
+				if unsafeHTMLElements[string(tag)] || bytes.Equal(unsafeElements[len(unsafeElements)-1], tag) {
+					unsafeElements = append(unsafeElements, append([]byte(nil), tag...))
+				}
+
+			case html.EndTagToken:
+				tag, _ := decoder.TagName()
+				if bytes.Equal(unsafeElements[len(unsafeElements)-1], tag) {
+					unsafeElements = unsafeElements[:len(unsafeElements)-1]
+				}
+			}
+		}
+	}
+}
+
+// sanitizeBaseTag updates the base URL of the document and writes a base element pointing at the proxified base URL,
+// so relative and fragment links are resolved by the browser like morty resolves them.
+// Only the first href and the first target of the base elements are used, like browsers do. The target is written
+// with the keep policy, removed with the self policy and returned to be copied to the links with the noopener policy.
+func sanitizeBaseTag(s *Sanitizer, out io.Writer, attrs []htmlAttr, hrefSeen bool, target []byte) (bool, []byte) {
+	var href string
+	var writtenTarget []byte
+	for _, attr := range attrs {
+		switch {
+		case !hrefSeen && href == "" && bytes.Equal(attr.Name, []byte("href")):
+			uri, _ := sanitizeURI(attr.Value)
+			parsedURI, err := url.Parse(string(uri))
+			if err != nil || (parsedURI.Scheme != "" && parsedURI.Scheme != "http" && parsedURI.Scheme != "https") {
+				continue
+			}
+			parsedURI.Fragment = ""
+			s.BaseURL = mergeURIs(s.BaseURL, parsedURI)
+			href = s.ProxifiedURL(s.BaseURL, "")
+		case target == nil && bytes.Equal(attr.Name, []byte("target")) && s.TargetPolicy != TargetPolicySelf:
+			// the attribute value is only valid until the next token
+			target = append([]byte{}, attr.Value...)
+			if s.TargetPolicy != TargetPolicyNoopener {
+				writtenTarget = target
+			}
+		}
+	}
+	if href != "" || writtenTarget != nil {
+		_, _ = io.WriteString(out, "<base")
+		if href != "" {
+			writeAttr(out, []byte("href"), []byte(href))
+		}
+		if writtenTarget != nil {
+			writeAttr(out, []byte("target"), writtenTarget)
+		}
+		_, _ = io.WriteString(out, ">")
+	}
+	return hrefSeen || href != "", target
+}
+
+func injectBodyExtension(s *Sanitizer, out io.Writer) {
+	s.bodyInjected = true
+	if s.NoHeader || s.BodyExtension == nil {
+		return
+	}
+	s.fail(s.BodyExtension(out, s))
+}
+
+func sanitizeLinkTag(s *Sanitizer, out io.Writer, attrs []htmlAttr) {
+	exclude := false
+	preload := false
+	var as []byte
+	for _, attr := range attrs {
+		attrName := attr.Name
+		attrValue := attr.Value
+		if bytes.Equal(attrName, []byte("rel")) {
+			if !linkRelSafeValues[string(attrValue)] && !s.LinkRels[string(attrValue)] {
+				exclude = true
+				break
+			}
+			if s.TextOnly && bytes.Contains(bytes.ToLower(attrValue), []byte("icon")) {
+				exclude = true
+				break
+			}
+			preload = bytes.Equal(attrValue, []byte("preload"))
+		}
+		if bytes.Equal(attrName, []byte("as")) {
+			as = bytes.ToLower(bytes.TrimSpace(attrValue))
+			if bytes.Equal(as, []byte("script")) {
+				exclude = true
+				break
+			}
+		}
+	}
+
+	// only resources which are proxified can be preloaded
+	if preload && !exclude {
+		exclude = !linkPreloadSafeAsValues[string(as)] ||
+			(s.TextOnly && (bytes.Equal(as, []byte("image")) || bytes.Equal(as, []byte("font"))))
+	}
+
+	if !exclude {
+		_, _ = out.Write([]byte("<link"))
+		sanitizeAttrs(s, out, attrs)
+		_, _ = out.Write([]byte(">"))
+	}
+}
+
+func sanitizeMetaTag(s *Sanitizer, out io.Writer, attrs []htmlAttr) {
+	var httpEquiv []byte
+	var content []byte
+	urlContent := false
+
+	for _, attr := range attrs {
+		attrName := attr.Name
+		attrValue := attr.Value
+		if (bytes.Equal(attrName, []byte("property")) || bytes.Equal(attrName, []byte("name"))) &&
+			metaURLProperties[string(bytes.ToLower(bytes.TrimSpace(attrValue)))] {
+			urlContent = true
+		}
+		if bytes.Equal(attrName, []byte("http-equiv")) {
+			httpEquiv = bytes.ToLower(attrValue)
+			// exclude some <meta http-equiv="..." ..>
+			if !linkHttpEquivSafeValues[string(httpEquiv)] {
+				return
+			}
+		}
+		if bytes.Equal(attrName, []byte("content")) {
+			content = attrValue
+		}
+		if bytes.Equal(attrName, []byte("charset")) {
+			// exclude <meta charset="...">
+			return
+		}
+	}
+
+	if urlContent {
+		// Open Graph and Twitter card URLs
+		uri, err := s.ProxifyURI(content)
+		if err != nil || uri == "" {
+			return
+		}
+		_, _ = out.Write([]byte("<meta"))
+		for _, attr := range attrs {
+			if bytes.Equal(attr.Name, []byte("content")) {
+				writeAttr(out, attr.Name, []byte(uri))
+			} else {
+				sanitizeAttr(s, out, attr.Name, attr.Value)
+			}
+		}
+		_, _ = out.Write([]byte(">"))
+		return
+	}
+
+	if bytes.Equal(httpEquiv, []byte("location")) {
+		uri, err := s.ProxifyURI(content)
+		if err != nil || uri == "" {
+			return
+		}
+		_, _ = io.WriteString(out, `<meta http-equiv="location"`)
+		writeAttr(out, []byte("content"), []byte(uri))
+		_, _ = io.WriteString(out, ">")
+		return
+	}
+
+	if bytes.Equal(httpEquiv, []byte("refresh")) {
+		delay, contentURL, ok := parseRefresh(content)
+		if !ok {
+			return
+		}
+		refresh := delay
+		if contentURL != nil {
+			// output proxify result
+			uri, err := s.ProxifyURI(contentURL)
+			if err != nil {
+				return
+			}
+			refresh += "; url=" + uri
+		}
+		_, _ = io.WriteString(out, `<meta http-equiv="refresh"`)
+		writeURIAttr(out, []byte("content"), refresh)
+		_, _ = io.WriteString(out, ">")
+		return
+	}
+
+	_, _ = out.Write([]byte("<meta"))
+	if len(httpEquiv) > 0 {
+		writeURIAttr(out, []byte("http-equiv"), string(httpEquiv))
+	}
+	sanitizeAttrs(s, out, attrs)
+	_, _ = out.Write([]byte(">"))
+}
+
+const asciiWhitespace = "\t\n\f\r "
+
+// parseRefresh parses the content of a <meta http-equiv="refresh"> element like the browsers do:
+// "5", "0; url=/next", "0.5,URL = 'next'" or "3 next". It returns the delay as written, and the URL or nil.
+func parseRefresh(content []byte) (string, []byte, bool) {
+	content = bytes.TrimLeft(content, asciiWhitespace)
+	end := 0
+	for end < len(content) && content[end] >= '0' && content[end] <= '9' {
+		end++
+	}
+	if end == 0 && (len(content) == 0 || content[0] != '.') {
+		return "", nil, false
+	}
+	// the browsers ignore the fractional part, it is kept as written
+	for end < len(content) && (content[end] == '.' || (content[end] >= '0' && content[end] <= '9')) {
+		end++
+	}
+	delay := string(content[:end])
+	rest := bytes.TrimLeft(content[end:], asciiWhitespace)
+	if len(rest) == 0 {
+		return delay, nil, true
+	}
+	if rest[0] == ';' || rest[0] == ',' {
+		rest = bytes.TrimLeft(rest[1:], asciiWhitespace)
+	} else if strings.IndexByte(asciiWhitespace, content[end]) == -1 {
+		// the delay must be followed by a separator
+		return "", nil, false
+	}
+	if len(rest) >= 3 && bytes.EqualFold(rest[:3], []byte("url")) {
+		afterURL := bytes.TrimLeft(rest[3:], asciiWhitespace)
+		if len(afterURL) > 0 && afterURL[0] == '=' {
+			rest = bytes.TrimLeft(afterURL[1:], asciiWhitespace)
+		}
+	}
+	if len(rest) > 0 && (rest[0] == '\'' || rest[0] == '"') {
+		quote := rest[0]
+		rest = rest[1:]
+		if i := bytes.IndexByte(rest, quote); i != -1 {
+			rest = rest[:i]
+		}
+	}
+	rest = bytes.TrimRight(rest, asciiWhitespace)
+	if len(rest) == 0 {
+		return delay, nil, true
+	}
+	return delay, rest, true
+}
+
+// sanitizeObjectTag writes an <img> if the <object> or <embed> element references an image, the image is dropped
+// like an <img> if it is a tracking pixel or a subresource of a blocked host. It returns false if it is not an image.
+func sanitizeObjectTag(s *Sanitizer, out io.Writer, attrs []htmlAttr) bool {
+	var src, mimeType []byte
+	for _, attr := range attrs {
+		switch string(attr.Name) {
+		case "data", "src":
+			src = attr.Value
+		case "type":
+			mimeType = attr.Value
+		}
+	}
+	if len(src) == 0 || s.TextOnly {
+		return false
+	}
+
+	isImage := false
+	if len(mimeType) > 0 {
+		contentType, err := contenttype.ParseContentType(string(mimeType))
+		isImage = err == nil && contentType.TopLevelType == "image" && imageContentTypeFilter(contentType)
+	} else if srcURL, err := url.Parse(string(bytes.TrimSpace(src))); err == nil {
+		isImage = imageExtensions[strings.ToLower(filepath.Ext(srcURL.Path))]
+	}
+	if !isImage {
+		return false
+	}
+
+	imgAttrs := []htmlAttr{{[]byte("src"), src}}
+	for _, attr := range attrs {
+		if s.isSafeAttribute(attr.Name) && !bytes.Equal(attr.Name, []byte("type")) {
+			imgAttrs = append(imgAttrs, attr)
+		}
+	}
+	if (s.DropTrackingPixels && s.isTrackingPixel(imgAttrs)) || s.isBlockedSubresource([]byte("img"), imgAttrs) {
+		return true
+	}
+
+	uri, err := s.ProxifyURI(src)
+	if err != nil || uri == "" {
+		return false
+	}
+	_, _ = io.WriteString(out, "<img")
+	writeURIAttr(out, []byte("src"), uri)
+	for _, attr := range imgAttrs[1:] {
+		writeAttr(out, attr.Name, attr.Value)
+	}
+	_, _ = out.Write([]byte(" />"))
+	return true
+}
+
+// sanitizeIframeTag writes an <iframe> loading its src through morty,
+// nothing is written if the src is missing, unsafe or forbidden by the iframe policy.
+func sanitizeIframeTag(s *Sanitizer, out io.Writer, attrs []htmlAttr) {
+	var safeAttrs []htmlAttr
+	var src []byte
+	for _, attr := range attrs {
+		if bytes.Equal(attr.Name, []byte("src")) {
+			src = attr.Value
+		} else if s.isSafeAttribute(attr.Name) {
+			safeAttrs = append(safeAttrs, attr)
+		}
+	}
+
+	sanitizedSrc, scheme := sanitizeURI(src)
+	if len(sanitizedSrc) == 0 || (scheme != "" && scheme != "http:" && scheme != "https:") {
+		return
+	}
+	srcURL, err := url.Parse(string(sanitizedSrc))
+	if err != nil {
+		return
+	}
+	srcURL = mergeURIs(s.BaseURL, srcURL)
+	if s.IframePolicy == IframePolicySameOrigin && (srcURL.Scheme != s.BaseURL.Scheme || srcURL.Host != s.BaseURL.Host) {
+		return
+	}
+	uri, err := s.ProxifyURI([]byte(srcURL.String()))
+	if err != nil || uri == "" || uri[0] == '#' {
+		return
+	}
+
+	_, _ = io.WriteString(out, "<iframe")
+	writeURIAttr(out, []byte("src"), uri)
+	sanitizeAttrs(s, out, safeAttrs)
+	_, _ = out.Write([]byte(` sandbox="allow-forms"></iframe>`))
+}
+
+// sanitizeMathMLAttrs writes the allowed attributes of a MathML element, links (href) are removed.
+func sanitizeMathMLAttrs(s *Sanitizer, out io.Writer, attrs []htmlAttr) {
+	for _, attr := range attrs {
+		if mathMLSafeAttributes[string(attr.Name)] || s.isSafeAttribute(attr.Name) {
+			writeAttr(out, attr.Name, attr.Value)
+		} else if bytes.Equal(attr.Name, []byte("style")) {
+			sanitizeAttr(s, out, attr.Name, attr.Value)
+		}
+	}
+}
+
+func sanitizeAttrs(s *Sanitizer, out io.Writer, attrs []htmlAttr) {
+	// the direct links replace the rel attribute of the element,
+	// the noopener relation is added to the links opening a new browsing context
+	direct := false
+	opener := false
+	var rel []byte
+	for _, attr := range attrs {
+		switch string(attr.Name) {
+		case "href":
+			_, scheme := sanitizeURI(attr.Value)
+			direct = s.isDirectScheme(scheme)
+		case "target":
+			opener = s.TargetPolicy == TargetPolicyNoopener && opensBrowsingContext(attr.Value)
+		case "rel":
+			rel = attr.Value
+		}
+	}
+	for _, attr := range attrs {
+		if (direct || opener) && bytes.Equal(attr.Name, []byte("rel")) {
+			continue
+		}
+		if s.TargetPolicy == TargetPolicySelf && bytes.Equal(attr.Name, []byte("target")) {
+			continue
+		}
+		sanitizeAttr(s, out, attr.Name, attr.Value)
+	}
+	switch {
+	case direct:
+		writeAttr(out, []byte("rel"), []byte("noreferrer"))
+	case opener:
+		writeAttr(out, []byte("rel"), withNoopener(rel))
+	}
+}
+
+// opensBrowsingContext reports whether a target attribute opens a new tab or window
+func opensBrowsingContext(target []byte) bool {
+	switch string(bytes.ToLower(bytes.Trim(target, asciiWhitespace))) {
+	case "", "_self", "_parent", "_top":
+		return false
+	}
+	return true
+}
+
+// withNoopener adds the noopener keyword to a rel attribute, noreferrer implies it
+func withNoopener(rel []byte) []byte {
+	for _, keyword := range bytes.Fields(bytes.ToLower(rel)) {
+		if bytes.Equal(keyword, []byte("noopener")) || bytes.Equal(keyword, []byte("noreferrer")) {
+			return rel
+		}
+	}
+	if len(bytes.TrimSpace(rel)) == 0 {
+		return []byte("noopener")
+	}
+	return append(append([]byte{}, bytes.TrimSpace(rel)...), " noopener"...)
+}
+
+// hasAttr reports whether an element has the attribute name
+func hasAttr(attrs []htmlAttr, name string) bool {
+	for _, attr := range attrs {
+		if string(attr.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sanitizeAttr(s *Sanitizer, out io.Writer, attrName, attrValue []byte) {
+	if s.isSafeAttribute(attrName) {
+		writeAttr(out, attrName, attrValue)
+		return
+	}
+	if s.StructuredData != "" && s.StructuredData != StructuredDataNone && structuredDataAttributes[string(attrName)] {
+		if s.StructuredData == StructuredDataProxify && structuredDataURLAttributes[string(attrName)] {
+			if uri, err := s.ProxifyURI(attrValue); err == nil {
+				writeURIAttr(out, attrName, uri)
+			}
+		} else {
+			writeAttr(out, attrName, attrValue)
+		}
+		return
+	}
+	if s.TextOnly && (bytes.Equal(attrName, []byte("src")) || bytes.Equal(attrName, []byte("poster"))) {
+		return
+	}
+	switch string(attrName) {
+	case "src", "href", "action", "poster", "cite":
+		// the attribute is removed if the URI can't be proxified
+		if uri, err := s.ProxifyURI(attrValue); err == nil {
+			writeURIAttr(out, attrName, uri)
+		}
+	case "style":
+		cssAttr := attrBufferPool.Get().(*bytes.Buffer)
+		cssAttr.Reset()
+		sanitizeCSS(s, cssAttr, attrValue)
+		writeAttr(out, attrName, cssAttr.Bytes())
+		attrBufferPool.Put(cssAttr)
+	}
+}
+
+func (s *Sanitizer) isSafeAttribute(attrName []byte) bool {
+	if safeAttributes[string(attrName)] {
+		return true
+	}
+	if s.DataAttributes && len(attrName) > len("data-") && bytes.HasPrefix(attrName, []byte("data-")) {
+		return true
+	}
+	for _, prefix := range safeAttributePrefixes {
+		if len(attrName) > len(prefix) && bytes.HasPrefix(attrName, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,649 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type StringTestCase struct {
+	Input          string
+	ExpectedOutput string
+}
+
+type AttrTestCase struct {
+	AttrName       []byte
+	AttrValue      []byte
+	ExpectedOutput []byte
+}
+
+var attrTestData = []*AttrTestCase{
+	{
+		[]byte("href"),
+		[]byte("./x"),
+		[]byte(` href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fx"`),
+	},
+	{
+		[]byte("src"),
+		[]byte("http://x.com/y"),
+		[]byte(` src="./?mortyurl=http%3A%2F%2Fx.com%2Fy"`),
+	},
+	{
+		[]byte("action"),
+		[]byte("/z"),
+		[]byte(` action="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fz"`),
+	},
+	{
+		[]byte("poster"),
+		[]byte("/poster.jpg"),
+		[]byte(` poster="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fposter.jpg"`),
+	},
+	{
+		[]byte("aria-label"),
+		[]byte("close menu"),
+		[]byte(` aria-label="close menu"`),
+	},
+	{
+		[]byte("role"),
+		[]byte("navigation"),
+		[]byte(` role="navigation"`),
+	},
+	{
+		[]byte("aria-"),
+		[]byte("x"),
+		nil,
+	},
+	{
+		[]byte("pattern"),
+		[]byte("[0-9]{3}"),
+		[]byte(` pattern="[0-9]{3}"`),
+	},
+	{
+		[]byte("onclick"),
+		[]byte("console.log(document.cookies)"),
+		nil,
+	},
+}
+
+var htmlTestData = []*StringTestCase{
+	{
+		`<video src="v.webm" controls><track src="s.vtt" kind="subtitles" srclang="en" label="English" default></video>`,
+		`<video src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fv.webm" controls=""><track src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fs.vtt" kind="subtitles" srclang="en" label="English" default=""></video>`,
+	},
+	{
+		`<link rel="preload" href="/font.woff2" as="font" type="font/woff2" crossorigin><link rel="preload" href="/w.js" as="worker"><link rel="preload" href="/x"><link rel="preload" href="/a.js" as="SCRIPT">`,
+		`<link rel="preload" href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Ffont.woff2" as="font" type="font/woff2" crossorigin="">`,
+	},
+	{
+		`<blockquote cite="/source#p2">a</blockquote><q cite="javascript:alert(1)">b</q><del cite="http://example.com/">c</del>`,
+		`<blockquote cite="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fsource#p2">a</blockquote><q cite="">b</q><del cite="./?mortyurl=http%3A%2F%2Fexample.com%2F">c</del>`,
+	},
+	{
+		`<form action="/s"><button formaction="/alt?x=1" formenctype="text/plain">a</button><input type="submit" formmethod="POST" formenctype="multipart/form-data"></form>`,
+		`<form action="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fs"><input type="hidden" name="mortyurl" value="http://127.0.0.1/s" /><button formaction="./?mortyurl=http%3A%2F%2F127.0.0.1%2Falt&amp;mortyget=1" formmethod="post" formenctype="application/x-www-form-urlencoded">a</button><input formaction="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fs" formmethod="post" formenctype="multipart/form-data" type="submit"></form>`,
+	},
+	{
+		`<form method="post" action="/s"><button formaction="/alt?x=1#top" formenctype="application/json">a</button><button formmethod="dialog">b</button></form>`,
+		`<form method="post" action="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fs"><input type="hidden" name="mortyurl" value="http://127.0.0.1/s" /><button formaction="./?mortyurl=http%3A%2F%2F127.0.0.1%2Falt%3Fx%3D1" formmethod="post">a</button><button formmethod="dialog">b</button></form>`,
+	},
+	{
+		`<input type="image" src="go.png" alt="Go" name="b" width="20" height="10">`,
+		`<input type="image" src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fgo.png" alt="Go" name="b" width="20" height="10">`,
+	},
+	{
+		`<input type="IMAGE" src="data:image/png;base64,AA" alt="Go">`,
+		`<input type="IMAGE" src="data:image/png;base64,AA" alt="Go">`,
+	},
+	{
+		`<base href="/docs/" target="_blank"><a href="page.html">x</a><a href="#top">y</a>`,
+		`<base href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fdocs%2F" target="_blank"><a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fdocs%2Fpage.html">x</a><a href="#top">y</a>`,
+	},
+	{
+		`<base href="http://example.com/a/"><base href="http://example.org/"><a href="b">x</a>`,
+		`<base href="./?mortyurl=http%3A%2F%2Fexample.com%2Fa%2F"><a href="./?mortyurl=http%3A%2F%2Fexample.com%2Fa%2Fb">x</a>`,
+	},
+	{
+		`<base href="javascript:alert(1)"><a href="b">x</a>`,
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fb">x</a>`,
+	},
+	{
+		`<math display="block"><mrow><mi href="javascript:alert(1)">x</mi><mo>=</mo><mn>2</mn></mrow></math>`,
+		`<math display="block"><mrow><mi>x</mi><mo>=</mo><mn>2</mn></mrow></math>`,
+	},
+	{
+		`<math><semantics><mfrac><mn>1</mn><mn>2</mn></mfrac><annotation-xml encoding="text/html"><a href="/">x</a></annotation-xml><annotation encoding="application/x-tex">\frac{1}{2}</annotation></semantics></math>`,
+		`<math><semantics><mfrac><mn>1</mn><mn>2</mn></mfrac><annotation encoding="application/x-tex">\frac{1}{2}</annotation></semantics></math>`,
+	},
+	{
+		`<math><mi>x</mi><script>alert(1)</script><mglyph src="/a.png" /></math><p>y</p>`,
+		`<math><mi>x</mi></math><p>y</p>`,
+	},
+	{
+		// an unclosed <math> element ends at the next HTML element
+		`<math><mi>x</mi><mo>+<h1>title</h1><div><b>y</b></div><table><tr><td>z</td></tr></table>`,
+		`<math><mi>x</mi><mo>+<h1>title</h1><div><b>y</b></div><table><tr><td>z</td></tr></table>`,
+	},
+	{
+		`<object data="/logo.png" width="10"><p>fallback</p></object><p>x</p>`,
+		`<img src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Flogo.png" width="10" /><p>x</p>`,
+	},
+	{
+		`<embed src="/logo" type="image/gif"><embed src="/movie.swf"><p>x</p>`,
+		`<img src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Flogo" /><p>x</p>`,
+	},
+	{
+		`<meta property="og:image" content="https://x.com/a.png"><meta name="twitter:url" content="javascript:alert(1)"><meta property="og:title" content="t">`,
+		`<meta property="og:image" content="./?mortyurl=https%3A%2F%2Fx.com%2Fa.png"><meta property="og:title" content="t">`,
+	},
+	{
+		`<a href="/a?x=1&amp;y=2&#38;z=&#x33;&copy=4">a</a><a href="#a&amp;lt;b">b</a>`,
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa%3Fx%3D1%26y%3D2%26z%3D3%26copy%3D4">a</a><a href="#a&amp;lt;b">b</a>`,
+	},
+	{
+		`<meta http-equiv="refresh" content="5;URL = 'http://x.com/next'">`,
+		`<meta http-equiv="refresh" content="5; url=./?mortyurl=http%3A%2F%2Fx.com%2Fnext">`,
+	},
+	{
+		`<meta http-equiv="Refresh" content="30"><meta http-equiv="refresh" content="soon">`,
+		`<meta http-equiv="refresh" content="30">`,
+	},
+	{
+		`<meta http-equiv="Location" content="http://x.com/new">`,
+		`<meta http-equiv="location" content="./?mortyurl=http%3A%2F%2Fx.com%2Fnew">`,
+	},
+	{
+		`<template id="t"><img src="http://x.com/a.png" onerror="alert(1)"><script>alert(1)</script><style>a { background: url(b.png) }</style></template>`,
+		`<template id="t"><img src="./?mortyurl=http%3A%2F%2Fx.com%2Fa.png"><style>a { background: url("./?mortyurl=http%3A%2F%2F127.0.0.1%2Fb.png") }</style></template>`,
+	},
+	{
+		`<div><template shadowrootmode="open"><iframe src="/x"></iframe><slot></slot></template><p>x</p></div>`,
+		`<div><template shadowrootmode="open"><slot></slot></template><p>x</p></div>`,
+	},
+	{
+		`<object data="/movie.swf" type="application/x-shockwave-flash"><p>fallback</p></object>`,
+		`<object type="application/x-shockwave-flash"><p>fallback</p></object>`,
+	},
+}
+
+var iframeTestData = []*StringTestCase{
+	{
+		`<iframe src="/frame" width="100">fallback</iframe><p>x</p>`,
+		`<iframe src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fframe" width="100" sandbox="allow-forms"></iframe><p>x</p>`,
+	},
+	{
+		`<iframe src="http://x.com/frame"></iframe>`,
+		``,
+	},
+	{
+		`<iframe src="javascript:alert(1)"></iframe><iframe srcdoc="<script></script>"></iframe>`,
+		``,
+	},
+}
+
+func TestIframeSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range iframeTestData {
+		s := &Sanitizer{BaseURL: u, IframePolicy: IframePolicySameOrigin}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`HTML sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
+
+func TestHTMLSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range htmlTestData {
+		s := &Sanitizer{BaseURL: u}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`HTML sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
+
+func TestAttrSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	for _, testCase := range attrTestData {
+		out := bytes.NewBuffer(nil)
+		sanitizeAttr(s, out, testCase.AttrName, testCase.AttrValue)
+		res, _ := out.ReadBytes(byte(0))
+		if !bytes.Equal(res, testCase.ExpectedOutput) {
+			t.Errorf(
+				`Attribute parse error. Name: "%s", Value: "%s", Expected: %s, Got: "%s"`,
+				testCase.AttrName,
+				testCase.AttrValue,
+				testCase.ExpectedOutput,
+				res,
+			)
+		}
+	}
+}
+
+var BenchSimpleHtml = []byte(`<!doctype html>
+<html>
+ <head>
+  <title>test</title>
+ </head>
+ <body>
+  <h1>Test heading</h1>
+ </body>
+</html>`)
+
+func BenchmarkSanitizeSimpleHTML(b *testing.B) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, BenchSimpleHtml)
+	}
+}
+
+var BenchComplexHtml = []byte(`<!doctype html>
+<html>
+ <head>
+  <noscript><meta http-equiv="refresh" content="0; URL=./xy"></noscript>
+  <title>test 2</title>
+  <script> alert('xy'); </script>
+  <link rel="stylesheet" href="./core.bundle.css">
+  <style>
+   html { background: url(./a.jpg); }
+  </style
+ </head>
+ <body>
+  <h1>Test heading</h1>
+  <img src="b.png" alt="imgtitle" />
+  <form action="/z">
+  <input type="submit" style="background: url(http://aa.bb/cc)" >
+  </form>
+ </body>
+</html>`)
+
+func BenchmarkSanitizeComplexHTML(b *testing.B) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, BenchComplexHtml)
+	}
+}
+
+var BenchAttributeHeavyHtml = []byte(`<!doctype html>
+<html lang="en" dir="ltr">
+ <head>
+  <meta http-equiv="content-language" content="en">
+  <meta property="og:image" content="/og.png">
+  <link rel="stylesheet" href="/a.css" media="screen" crossorigin="anonymous">
+  <link rel="preload" href="/font.woff2" as="font" type="font/woff2" crossorigin="anonymous">
+ </head>
+ <body class="page" id="top">
+` + strings.Repeat(`  <div class="row" id="r" role="list" aria-label="items" title="t" tabindex="0" hidden itemscope itemtype="https://schema.org/Thing" onclick="x()" data-x="1">
+   <a href="/item?id=1" class="link" title="item" target="_blank" rel="next" hreflang="en" aria-current="page">item</a>
+   <img src="/i.png" alt="image" width="10" height="10" class="icon" loading="lazy" style="border:0">
+   <input type="text" name="q" value="v" placeholder="search" autocomplete="off" spellcheck="false" maxlength="10" required>
+  </div>
+`, 50) + ` </body>
+</html>`)
+
+func BenchmarkSanitizeAttributeHeavyHTML(b *testing.B) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, BenchAttributeHeavyHtml)
+	}
+}
+
+func BenchmarkIsSafeAttribute(b *testing.B) {
+	names := [][]byte{[]byte("class"), []byte("wrap"), []byte("onclick"), []byte("aria-label"), []byte("href")}
+	s := &Sanitizer{}
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			s.isSafeAttribute(name)
+		}
+	}
+}
+
+func TestRepairHTML(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	repaired, err := RepairHTML([]byte(`<p><b>bold<i>both</b>italic</p><table><tr><td>cell<div>x</table><a href="/a">`))
+	if err != nil {
+		t.Fatalf("Failed to repair HTML: %v", err)
+	}
+	out := bytes.NewBuffer(nil)
+	sanitizeHTML(s, out, repaired)
+	if !s.bodyInjected {
+		t.Errorf("Repaired HTML error. The body extension must be injected before </body>")
+	}
+	expected := `<html><head><meta http-equiv="Content-Type" content="text/html; charset=utf-8">`
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf(`Repaired HTML error. Expected prefix: "%s", Got: "%s"`, expected, out.String())
+	}
+	expected = `<p><b>bold<i>both</i></b><i>italic</i></p><table><tbody><tr><td>cell<div>x</div></td></tr></tbody></table><i><a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa"></a></i>`
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf(`Repaired HTML error. Expected: "%s", Got: "%s"`, expected, out.String())
+	}
+}
+
+var textOnlyTestData = []*StringTestCase{
+	{
+		`<link rel="preload" href="/i.png" as="image"><link rel="preload" href="/s.css" as="style">`,
+		`<link rel="preload" href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fs.css&amp;mortytext=1" as="style">`,
+	},
+	{
+		`<input type="image" src="go.png" alt="Go">`,
+		`<input type="image" alt="Go">`,
+	},
+	{
+		`<p>a<img src="x.png" alt="x">b</p>`,
+		`<p>ab</p>`,
+	},
+	{
+		`<video poster="p.png"><source src="v.webm"><p>fallback</p></video><p>c</p>`,
+		`<p>c</p>`,
+	},
+	{
+		`<link rel="icon" href="/favicon.ico"><a href="/x">x</a>`,
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fx&amp;mortytext=1">x</a>`,
+	},
+	{
+		`<div style="background: url(bg.png) red">x</div>`,
+		`<div style="background: none red">x</div>`,
+	},
+}
+
+func TestTextOnlySanitizer(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range textOnlyTestData {
+		s := &Sanitizer{BaseURL: u, TextOnly: true, TextOnlyParam: true}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`Text only sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u, NoHeader: true}
+	out := bytes.NewBuffer(nil)
+	sanitizeHTML(s, out, []byte(`<body><form method="get"><input name="q"></form></body>`))
+	expected := `<body><form method="get"><input name="q"></form></body>`
+	if out.String() != expected {
+		t.Errorf(`No header error. Expected: "%s", Got: "%s"`, expected, out.String())
+	}
+	if !s.bodyInjected {
+		t.Error("No header error. The body extension should be marked as injected")
+	}
+}
+
+var structuredDataTestData = []struct {
+	Policy         string
+	Input          string
+	ExpectedOutput string
+}{
+	{
+		StructuredDataNone,
+		`<div itemscope itemtype="https://schema.org/Book" itemid="urn:isbn:0-330-34032-8"><span itemprop="name">x</span></div>`,
+		`<div><span>x</span></div>`,
+	},
+	{
+		StructuredDataPreserve,
+		`<div itemscope itemtype="https://schema.org/Book" itemid="http://example.com/book"><span itemprop="name">x</span></div>`,
+		`<div itemscope="" itemtype="https://schema.org/Book" itemid="http://example.com/book"><span itemprop="name">x</span></div>`,
+	},
+	{
+		StructuredDataProxify,
+		`<div vocab="https://schema.org/" typeof="Person" resource="/people/alice"><a property="url" href="/a">a</a></div>`,
+		`<div vocab="https://schema.org/" typeof="Person" resource="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fpeople%2Falice"><a property="url" href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa">a</a></div>`,
+	},
+	{
+		StructuredDataProxify,
+		`<div about="javascript:alert(1)">x</div>`,
+		`<div about="">x</div>`,
+	},
+}
+
+func TestStructuredData(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range structuredDataTestData {
+		s := &Sanitizer{BaseURL: u, StructuredData: testCase.Policy}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`Structured data error. Policy: "%s", Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Policy,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
+
+var directSchemesTestData = []*StringTestCase{
+	{
+		`<a href="mailto:a@example.com" rel="author">mail</a>`,
+		`<a href="mailto:a@example.com" rel="noreferrer">mail</a>`,
+	},
+	{
+		`<a href=" TEL:+123">call</a>`,
+		`<a href="tel:+123" rel="noreferrer">call</a>`,
+	},
+	{
+		`<a href="magnet:?xt=urn:btih:x">get</a>`,
+		`<a href="./?mortyurl=magnet%3A%3Fxt%3Durn%3Abtih%3Ax">get</a>`,
+	},
+}
+
+func TestDirectSchemes(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range directSchemesTestData {
+		s := &Sanitizer{BaseURL: u, DirectSchemes: map[string]bool{"mailto": true, "tel": true}}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(`Direct scheme error. Input: "%s", Expected: "%s", Got: "%s"`, testCase.Input, testCase.ExpectedOutput, out.String())
+		}
+	}
+}
+
+func TestParseRefresh(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		delay   string
+		url     string
+		ok      bool
+	}{
+		{"5", "5", "", true},
+		{" 0; url=/next", "0", "/next", true},
+		{"0.5,URL = 'next' ", "0.5", "next", true},
+		{"3 next", "3", "next", true},
+		{`0; url="a b"c`, "0", "a b", true},
+		{"0; urlx", "0", "urlx", true},
+		{"1;", "1", "", true},
+		{"3x", "", "", false},
+		{"x", "", "", false},
+		{"", "", "", false},
+	} {
+		delay, contentURL, ok := parseRefresh([]byte(test.content))
+		if delay != test.delay || string(contentURL) != test.url || ok != test.ok {
+			t.Errorf("%q: expected %q %q %v, got %q %q %v", test.content, test.delay, test.url, test.ok, delay, contentURL, ok)
+		}
+	}
+}
+
+var formTestData = []struct {
+	Attrs          []htmlAttr
+	ExpectedTarget string
+}{
+	{
+		[]htmlAttr{{[]byte("action"), []byte("/search?lang=en#results")}},
+		"http://127.0.0.1/search",
+	},
+	{
+		[]htmlAttr{{[]byte("method"), []byte("POST")}, {[]byte("action"), []byte("/post?lang=en")}},
+		"http://127.0.0.1/post?lang=en",
+	},
+	{
+		nil,
+		"http://127.0.0.1/page",
+	},
+}
+
+var targetPolicyTestData = []struct {
+	Policy         string
+	Input          string
+	ExpectedOutput string
+}{
+	{
+		TargetPolicySelf,
+		`<base target="_blank"><base href="/d/" target="x"><a href="/a" target="_blank">a</a><form target="f"></form>`,
+		`<base href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fd%2F"><a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa">a</a><form></form>`,
+	},
+	{
+		TargetPolicyNoopener,
+		`<a href="/a" target="_blank" rel="next">a</a><a href="/b" target="_self">b</a><a href="/c" target="w" rel="noreferrer">c</a>`,
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa" target="_blank" rel="next noopener">a</a><a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fb" target="_self">b</a><a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fc" target="w" rel="noreferrer">c</a>`,
+	},
+	{
+		TargetPolicyNoopener,
+		`<base target="_blank"><a href="/a">a</a><a href="/b" target="_top">b</a><area href="/c">`,
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fa" target="_blank" rel="noopener">a</a><a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fb" target="_top">b</a><area href="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fc" target="_blank" rel="noopener">`,
+	},
+}
+
+func TestTargetPolicy(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range targetPolicyTestData {
+		s := &Sanitizer{BaseURL: u, TargetPolicy: testCase.Policy, NoHeader: true}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(`Target policy %s error. Input: "%s", Expected: "%s", Got: "%s"`, testCase.Policy, testCase.Input, testCase.ExpectedOutput, out.String())
+		}
+	}
+}
+
+func TestFormTarget(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/page?id=1")
+	s := &Sanitizer{BaseURL: u}
+	for _, testCase := range formTestData {
+		target := formTargetURL(s, testCase.Attrs)
+		if target != testCase.ExpectedTarget {
+			t.Errorf(`Form target error. Expected: "%s", Got: "%s"`, testCase.ExpectedTarget, target)
+		}
+	}
+	if u.String() != "http://127.0.0.1/page?id=1" {
+		t.Errorf("Form target error. The base URL has been modified: %s", u)
+	}
+}
+
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (f *flushRecorder) Flush() error {
+	f.flushed = append(f.flushed, f.String())
+	return nil
+}
+
+func TestSanitizeHTMLFlush(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	out := &flushRecorder{}
+	sanitizeHTML(&Sanitizer{BaseURL: u}, out, []byte("<html><head><title>a</title></head><body>b</body></html>"))
+	if len(out.flushed) != 1 || !strings.HasSuffix(out.flushed[0], "</head>") {
+		t.Errorf("Expected a flush after </head>, got %q", out.flushed)
+	}
+}
+
+func TestTrackingPixels(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range []*StringTestCase{
+		{
+			`<img src="/a.png" width="1" height="1px"><img src="/b.png" width="1" height="20"><p>x</p>`,
+			`<img src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fb.png" width="1" height="20"><p>x</p>`,
+		},
+		{
+			`<img src="https://www.google-analytics.com/collect?v=1"><img src="https://stats.example.com/__utm.gif?x"><img src="https://www.facebook.com/tr?id=1&ev=PageView">`,
+			``,
+		},
+		{
+			`<img src="//b.scorecardresearch.com/p?c1=2"><img src="/pixels/photo.png" alt="p">`,
+			`<img src="./?mortyurl=http%3A%2F%2F127.0.0.1%2Fpixels%2Fphoto.png" alt="p">`,
+		},
+		{
+			`<object data="/t.gif" width="1" height="1"><p>fallback</p></object><embed src="https://www.google-analytics.com/collect.gif"><p>x</p>`,
+			`<p>x</p>`,
+		},
+	} {
+		s := &Sanitizer{BaseURL: u, DropTrackingPixels: true}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(`Tracking pixel error. Input: "%s", Expected: "%s", Got: "%s"`, testCase.Input, testCase.ExpectedOutput, out.String())
+		}
+	}
+}
+
+func TestBlockedHosts(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	blockedHost := func(hostname string) bool {
+		return hostname == "ads.example.com" || strings.HasSuffix(hostname, ".ads.example.com")
+	}
+	for _, testCase := range []*StringTestCase{
+		{
+			`<img src="https://cdn.ads.example.com/banner.png"><img src="https://example.com/a.png">`,
+			`<img src="./?mortyurl=https%3A%2F%2Fexample.com%2Fa.png">`,
+		},
+		{
+			`<video src="https://example.com/v.mp4" poster="https://ads.example.com/p.png"><p>x</p></video><link rel="stylesheet" href="//ads.example.com/a.css">`,
+			``,
+		},
+		{
+			`<object src="https://ads.example.com/banner.png"><p>fallback</p></object><embed data="https://ads.example.com/b.png" type="image/png"><p>x</p>`,
+			`<p>x</p>`,
+		},
+		{
+			`<a href="https://ads.example.com/">ad</a>`,
+			`<a href="./?mortyurl=https%3A%2F%2Fads.example.com%2F">ad</a>`,
+		},
+		{
+			`<div style="background: url(https://ads.example.com/bg.png)">x</div>`,
+			`<div style="background: url(&#34;&#34;)">x</div>`,
+		},
+	} {
+		s := &Sanitizer{BaseURL: u, BlockedHost: blockedHost}
+		out := bytes.NewBuffer(nil)
+		sanitizeHTML(s, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(`Blocked host error. Input: "%s", Expected: "%s", Got: "%s"`, testCase.Input, testCase.ExpectedOutput, out.String())
+		}
+	}
+}
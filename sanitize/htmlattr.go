@@ -0,0 +1,112 @@
+package sanitize
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// attribute of a HTML start tag, the slices point into the tokenizer buffer
+type htmlAttr struct {
+	Name  []byte
+	Value []byte
+}
+
+// buffers of writeAttr: an attribute is written with a single Write call
+var attrBufferPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, 256))
+	},
+}
+
+// writeAttr writes ` name="value"`, the value is escaped like html.EscapeString does
+func writeAttr(out io.Writer, name, value []byte) {
+	buf := attrBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.WriteByte(' ')
+	buf.Write(name)
+	buf.WriteString(`="`)
+	escapeHTML(buf, value)
+	buf.WriteByte('"')
+	_, _ = out.Write(buf.Bytes())
+	attrBufferPool.Put(buf)
+}
+
+// writeURIAttr writes ` name="uri"` where uri is a result of ProxifyURI. The tokenizer decodes the entities of
+// the attribute values, so the URI is escaped again: the query separators of the proxified URLs are written as "&amp;".
+func writeURIAttr(out io.Writer, name []byte, uri string) {
+	buf := attrBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.WriteByte(' ')
+	buf.Write(name)
+	buf.WriteString(`="`)
+	escapeHTMLString(buf, uri)
+	buf.WriteByte('"')
+	_, _ = out.Write(buf.Bytes())
+	attrBufferPool.Put(buf)
+}
+
+// escapeHTML appends s to buf, the characters escaped by html.EscapeString are replaced by the same entities
+func escapeHTML(buf *bytes.Buffer, s []byte) {
+	last := 0
+	for i, c := range s {
+		var entity string
+		switch c {
+		case '&':
+			entity = "&amp;"
+		case '\'':
+			entity = "&#39;"
+		case '<':
+			entity = "&lt;"
+		case '>':
+			entity = "&gt;"
+		case '"':
+			entity = "&#34;"
+		default:
+			continue
+		}
+		buf.Write(s[last:i])
+		buf.WriteString(entity)
+		last = i + 1
+	}
+	buf.Write(s[last:])
+}
+
+// escapeHTMLString is escapeHTML for a string, without converting it to a byte slice
+func escapeHTMLString(buf *bytes.Buffer, s string) {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		var entity string
+		switch s[i] {
+		case '&':
+			entity = "&amp;"
+		case '\'':
+			entity = "&#39;"
+		case '<':
+			entity = "&lt;"
+		case '>':
+			entity = "&gt;"
+		case '"':
+			entity = "&#34;"
+		default:
+			continue
+		}
+		buf.WriteString(s[last:i])
+		buf.WriteString(entity)
+		last = i + 1
+	}
+	buf.WriteString(s[last:])
+}
+
+// flushOutput sends the buffered output to the client if it is streamed
+func flushOutput(out io.Writer) {
+	if f, ok := out.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+}
+
+// writeTag writes prefix followed by the tag name, e.g. "<" or "</"
+func writeTag(out io.Writer, prefix string, tag []byte) {
+	_, _ = io.WriteString(out, prefix)
+	_, _ = out.Write(tag)
+}
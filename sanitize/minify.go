@@ -0,0 +1,112 @@
+package sanitize
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/net/html"
+)
+
+// elements whose text is written as is
+var minifyPreservedElements = map[string]bool{
+	"listing":   true,
+	"plaintext": true,
+	"pre":       true,
+	"style":     true,
+	"textarea":  true,
+	"xmp":       true,
+}
+
+// MinifyHTML writes a sanitized HTML document with collapsed whitespaces and without redundant attribute quotes.
+// Comments are already removed by the sanitizer.
+func MinifyHTML(out io.Writer, htmlDoc []byte) {
+	decoder := html.NewTokenizer(bytes.NewReader(htmlDoc))
+	preserved := 0
+	for {
+		token := decoder.Next()
+		switch token {
+		case html.ErrorToken:
+			return
+		case html.TextToken:
+			if preserved > 0 {
+				_, _ = out.Write(decoder.Raw())
+			} else {
+				_, _ = out.Write(collapseWhitespace(decoder.Raw()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttrs := decoder.TagName()
+			_, _ = out.Write([]byte{'<'})
+			_, _ = out.Write(tag)
+			unquoted := false
+			for hasAttrs {
+				var attrName, attrValue []byte
+				attrName, attrValue, hasAttrs = decoder.TagAttr()
+				unquoted = false
+				switch {
+				case len(attrValue) == 0:
+					// <input disabled> is the same as <input disabled="">
+					_, _ = out.Write([]byte{' '})
+					_, _ = out.Write(attrName)
+				case isUnquotedAttrValue(attrValue):
+					_, _ = out.Write([]byte{' '})
+					_, _ = out.Write(attrName)
+					_, _ = out.Write([]byte{'='})
+					_, _ = out.Write(attrValue)
+					unquoted = true
+				default:
+					writeAttr(out, attrName, attrValue)
+				}
+			}
+			if token == html.SelfClosingTagToken {
+				if unquoted {
+					// <a href=x/> would be parsed as href="x/"
+					_, _ = out.Write([]byte{' '})
+				}
+				_, _ = out.Write([]byte("/>"))
+			} else {
+				_, _ = out.Write([]byte{'>'})
+				if minifyPreservedElements[string(tag)] {
+					preserved++
+				}
+			}
+		case html.EndTagToken:
+			tag, _ := decoder.TagName()
+			if minifyPreservedElements[string(tag)] && preserved > 0 {
+				preserved--
+			}
+			_, _ = out.Write(decoder.Raw())
+		case html.CommentToken:
+		default:
+			_, _ = out.Write(decoder.Raw())
+		}
+	}
+}
+
+// isUnquotedAttrValue reports whether the attribute value can be written without quotes and escaping
+func isUnquotedAttrValue(value []byte) bool {
+	for _, c := range value {
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f', '"', '\'', '=', '<', '>', '`', '&':
+			return false
+		}
+	}
+	return true
+}
+
+// collapseWhitespace replaces each sequence of whitespaces by a single space
+func collapseWhitespace(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	space := false
+	for _, c := range text {
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' {
+			if !space {
+				out = append(out, ' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package sanitize
+
+import (
+	"bytes"
+	"testing"
+)
+
+var minifyTestData = []*StringTestCase{
+	{
+		"<p class=\"a b\" id=\"x\">\n  hello   <b>world</b>\n</p>",
+		"<p class=\"a b\" id=x> hello <b>world</b> </p>",
+	},
+	{
+		"<pre>\n  a\n    b</pre> <textarea>  x  </textarea>",
+		"<pre>\n  a\n    b</pre> <textarea>  x  </textarea>",
+	},
+	{
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2F&amp;mortytext=1" title="">x</a><input disabled="" value="a=b"><svg><path d="M0"/><circle r=1 /></svg>`,
+		`<a href="./?mortyurl=http%3A%2F%2F127.0.0.1%2F&amp;mortytext=1" title>x</a><input disabled value="a=b"><svg><path d=M0 /><circle r=1 /></svg>`,
+	},
+	{
+		"<!DOCTYPE html><!-- c --><style>a  {  }</style>",
+		"<!DOCTYPE html><style>a  {  }</style>",
+	},
+}
+
+func TestMinifyHTML(t *testing.T) {
+	for _, testCase := range minifyTestData {
+		out := bytes.NewBuffer(nil)
+		MinifyHTML(out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`HTML minifier error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package sanitize
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/friedemannsommer/morty/contenttype"
+)
+
+// image types which can be displayed by an <img> element
+var imageContentTypeFilter = contenttype.NewFilterOr([]contenttype.Filter{
+	contenttype.NewFilterEquals("image", "gif", ""),
+	contenttype.NewFilterEquals("image", "png", ""),
+	contenttype.NewFilterEquals("image", "jpeg", ""),
+	contenttype.NewFilterEquals("image", "pjpeg", ""),
+	contenttype.NewFilterEquals("image", "webp", ""),
+	contenttype.NewFilterEquals("image", "tiff", ""),
+	contenttype.NewFilterEquals("image", "vnd.microsoft.icon", ""),
+	contenttype.NewFilterEquals("image", "bmp", ""),
+	contenttype.NewFilterEquals("image", "x-ms-bmp", ""),
+	contenttype.NewFilterEquals("image", "x-icon", ""),
+	contenttype.NewFilterEquals("image", "avif", ""),
+	contenttype.NewFilterEquals("image", "svg", "xml"),
+})
+
+// file extensions of images which can be displayed by an <img> element
+var imageExtensions = map[string]bool{
+	".bmp":  true,
+	".gif":  true,
+	".ico":  true,
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true,
+	".webp": true,
+	".avif": true,
+	".svg":  true,
+}
+
+var unsafeHTMLElements = map[string]bool{
+	"applet": true,
+	"canvas": true,
+	"iframe": true,
+	"script": true,
+	"svg":    true,
+}
+
+// HTML elements which end the MathML content, see "breakout" in the parsing rules of the foreign content of HTML5
+var mathBreakoutElements = map[string]bool{
+	"b":          true,
+	"big":        true,
+	"blockquote": true,
+	"body":       true,
+	"br":         true,
+	"center":     true,
+	"code":       true,
+	"dd":         true,
+	"div":        true,
+	"dl":         true,
+	"dt":         true,
+	"em":         true,
+	"embed":      true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"head":       true,
+	"hr":         true,
+	"i":          true,
+	"img":        true,
+	"li":         true,
+	"listing":    true,
+	"menu":       true,
+	"meta":       true,
+	"nobr":       true,
+	"ol":         true,
+	"p":          true,
+	"pre":        true,
+	"ruby":       true,
+	"s":          true,
+	"small":      true,
+	"span":       true,
+	"strike":     true,
+	"strong":     true,
+	"sub":        true,
+	"sup":        true,
+	"table":      true,
+	"tt":         true,
+	"u":          true,
+	"ul":         true,
+	"var":        true,
+}
+
+// presentation MathML, without interactive and external elements (maction, mglyph)
+var mathMLSafeElements = map[string]bool{
+	"annotation":    true,
+	"math":          true,
+	"menclose":      true,
+	"merror":        true,
+	"mfenced":       true,
+	"mfrac":         true,
+	"mi":            true,
+	"mlabeledtr":    true,
+	"mmultiscripts": true,
+	"mn":            true,
+	"mo":            true,
+	"mover":         true,
+	"mpadded":       true,
+	"mphantom":      true,
+	"mprescripts":   true,
+	"mroot":         true,
+	"mrow":          true,
+	"ms":            true,
+	"mspace":        true,
+	"msqrt":         true,
+	"mstyle":        true,
+	"msub":          true,
+	"msubsup":       true,
+	"msup":          true,
+	"mtable":        true,
+	"mtd":           true,
+	"mtext":         true,
+	"mtr":           true,
+	"munder":        true,
+	"munderover":    true,
+	"none":          true,
+	"semantics":     true,
+}
+
+var mathMLSafeAttributes = map[string]bool{
+	"accent":         true,
+	"accentunder":    true,
+	"align":          true,
+	"close":          true,
+	"columnalign":    true,
+	"columnlines":    true,
+	"columnspacing":  true,
+	"columnspan":     true,
+	"depth":          true,
+	"display":        true,
+	"displaystyle":   true,
+	"encoding":       true,
+	"fence":          true,
+	"frame":          true,
+	"framespacing":   true,
+	"largeop":        true,
+	"linethickness":  true,
+	"lspace":         true,
+	"mathbackground": true,
+	"mathcolor":      true,
+	"mathsize":       true,
+	"mathvariant":    true,
+	"maxsize":        true,
+	"minsize":        true,
+	"movablelimits":  true,
+	"notation":       true,
+	"open":           true,
+	"rowalign":       true,
+	"rowlines":       true,
+	"rowspacing":     true,
+	"rowspan":        true,
+	"rspace":         true,
+	"scriptlevel":    true,
+	"separator":      true,
+	"separators":     true,
+	"stretchy":       true,
+	"symmetric":      true,
+	"voffset":        true,
+	"xmlns":          true,
+}
+
+// elements removed in text only mode, with their content
+var textOnlyUnsafeElements = map[string]bool{
+	"audio":   true,
+	"img":     true,
+	"picture": true,
+	"source":  true,
+	"track":   true,
+	"video":   true,
+}
+
+var formEncTypes = map[string]bool{
+	"application/x-www-form-urlencoded": true,
+	"multipart/form-data":               true,
+	"text/plain":                        true,
+}
+
+// elements navigating to their target attribute, or to the target of the base element
+var targetElements = map[string]bool{
+	"a":    true,
+	"area": true,
+	"form": true,
+}
+
+// elements without end tag
+var voidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+var safeAttributes = map[string]bool{
+	"abbr":            true,
+	"accept":          true,
+	"accesskey":       true,
+	"align":           true,
+	"alt":             true,
+	"as":              true,
+	"autocomplete":    true,
+	"charset":         true,
+	"checked":         true,
+	"class":           true,
+	"cols":            true,
+	"content":         true,
+	"contenteditable": true,
+	"contextmenu":     true,
+	"controls":        true,
+	"crossorigin":     true,
+	"default":         true,
+	"dir":             true,
+	"disabled":        true,
+	"enctype":         true,
+	"for":             true,
+	"form":            true,
+	"height":          true,
+	"hidden":          true,
+	"hreflang":        true,
+	"id":              true,
+	"inputmode":       true,
+	"kind":            true,
+	"label":           true,
+	"lang":            true,
+	"list":            true,
+	"max":             true,
+	"maxlength":       true,
+	"media":           true,
+	"method":          true,
+	"min":             true,
+	"minlength":       true,
+	"multiple":        true,
+	"name":            true,
+	"novalidate":      true,
+	"nowrap":          true,
+	"pattern":         true,
+	"placeholder":     true,
+	"property":        true,
+	"readonly":        true,
+	"rel":             true,
+	"required":        true,
+	"role":            true,
+	"rows":            true,
+	"selected":        true,
+	"shadowrootmode":  true, // declarative shadow DOM: <template> contents are rendered without javascript
+	"size":            true,
+	"spellcheck":      true,
+	"srclang":         true,
+	"step":            true,
+	"tabindex":        true,
+	"target":          true,
+	"title":           true,
+	"translate":       true,
+	"type":            true,
+	"value":           true,
+	"width":           true,
+	"wrap":            true,
+}
+
+// allowed destinations of <link rel="preload">
+var linkPreloadSafeAsValues = map[string]bool{
+	"font":  true,
+	"image": true,
+	"style": true,
+	"track": true,
+}
+
+// microdata and RDFa attributes, kept according to the structured data policy
+var structuredDataAttributes = map[string]bool{
+	"about":     true,
+	"datatype":  true,
+	"inlist":    true,
+	"itemid":    true,
+	"itemprop":  true,
+	"itemref":   true,
+	"itemscope": true,
+	"itemtype":  true,
+	"prefix":    true,
+	"resource":  true,
+	"typeof":    true,
+	"vocab":     true,
+}
+
+// structured data attributes identifying a resource which can be followed: proxified by the "proxify" policy.
+// The other URLs (itemtype, vocab, prefix) identify vocabularies and are always preserved.
+var structuredDataURLAttributes = map[string]bool{
+	"about":    true,
+	"itemid":   true,
+	"resource": true,
+}
+
+// attributes starting with one of these prefixes are safe
+var safeAttributePrefixes = [][]byte{
+	[]byte("aria-"),
+}
+
+var linkRelSafeValues = map[string]bool{
+	"alternate": true,
+	"archives":  true,
+	"author":    true,
+	"copyright": true,
+	"first":     true,
+	"help":      true,
+	"icon":      true,
+	"index":     true,
+	"last":      true,
+	"license":   true,
+	"manifest":  true,
+	"next":      true,
+	// "pingback": true,
+	"preload":       true,
+	"prev":          true,
+	"publisher":     true,
+	"search":        true,
+	"shortcut icon": true,
+	"stylesheet":    true,
+	"up":            true,
+}
+
+var linkHttpEquivSafeValues = map[string]bool{
+	// X-UA-Compatible will be added automatically, so it can be skipped
+	"date":             true,
+	"last-modified":    true,
+	"refresh":          true, // URL rewrite
+	"location":         true, // URL rewrite
+	"content-language": true,
+}
+
+// <meta property="..." content="..."> and <meta name="..." content="..."> with an URL as content
+var metaURLProperties = map[string]bool{
+	"og:audio":              true,
+	"og:audio:secure_url":   true,
+	"og:audio:url":          true,
+	"og:image":              true,
+	"og:image:secure_url":   true,
+	"og:image:url":          true,
+	"og:url":                true,
+	"og:video":              true,
+	"og:video:secure_url":   true,
+	"og:video:url":          true,
+	"twitter:image":         true,
+	"twitter:image:src":     true,
+	"twitter:player":        true,
+	"twitter:player:stream": true,
+	"twitter:url":           true,
+}
+
+// query parameters which are ignored to decide whether a link points to the current document
+var trackingQueryParameters = map[string]bool{
+	"fbclid":       true,
+	"gclid":        true,
+	"mc_cid":       true,
+	"mc_eid":       true,
+	"utm_campaign": true,
+	"utm_content":  true,
+	"utm_medium":   true,
+	"utm_source":   true,
+	"utm_term":     true,
+}
+
+// URL attributes of the elements loading a subresource, the elements are removed if the URL is blocked
+var subresourceAttributes = map[string]map[string]bool{
+	"audio":  {"src": true},
+	"embed":  {"src": true},
+	"iframe": {"src": true},
+	"img":    {"src": true},
+	"input":  {"src": true},
+	"link":   {"href": true},
+	"object": {"data": true},
+	"source": {"src": true},
+	"track":  {"src": true},
+	"video":  {"src": true, "poster": true},
+}
+
+// hosts of the trackers and analytics services, their images are tracking pixels.
+// The subdomains of the hosts are trackers too.
+var trackerHosts = map[string]bool{
+	"analytics.twitter.com":  true,
+	"bat.bing.com":           true,
+	"counter.yadro.ru":       true,
+	"ct.pinterest.com":       true,
+	"doubleclick.net":        true,
+	"google-analytics.com":   true,
+	"googletagmanager.com":   true,
+	"hotjar.com":             true,
+	"mc.yandex.ru":           true,
+	"pixel.facebook.com":     true,
+	"pixel.quora.com":        true,
+	"pixel.wp.com":           true,
+	"px.ads.linkedin.com":    true,
+	"quantserve.com":         true,
+	"scorecardresearch.com":  true,
+	"sp.analytics.yahoo.com": true,
+	"stats.wp.com":           true,
+}
+
+// paths of the images which are beacons of the analytics services, ie: /__utm.gif, /pixel.gif, /tr (Facebook)
+var beaconPathRegexp = regexp.MustCompile(`(?i)/((__utm|1x1|pixel|beacon|track|tracking)\.(gif|png)|(pixel|beacon|tr)/?)$`)
+
+// The policy tables are not exported: they are shared by all the sanitizers and must not be modified.
+// The functions below return copies of them.
+
+// ImageContentTypeFilter reports whether an image type can be displayed by an <img> element
+func ImageContentTypeFilter(contentType contenttype.ContentType) bool {
+	return imageContentTypeFilter(contentType)
+}
+
+// SafeAttributeNames returns the attributes kept as is
+func SafeAttributeNames() []string {
+	return sortedNames(safeAttributes)
+}
+
+// SafeLinkRels returns the <link rel> values allowed by default
+func SafeLinkRels() []string {
+	return sortedNames(linkRelSafeValues)
+}
+
+// URLAttributeNames returns the microdata and RDFa attributes proxified by the StructuredDataProxify policy
+func URLAttributeNames() []string {
+	return sortedNames(structuredDataURLAttributes)
+}
+
+// DefaultTrackingParameters returns the query parameters ignored by default to detect the links to the current document
+func DefaultTrackingParameters() []string {
+	return sortedNames(trackingQueryParameters)
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
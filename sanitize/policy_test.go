@@ -0,0 +1,16 @@
+package sanitize
+
+import "testing"
+
+func TestPolicyCopies(t *testing.T) {
+	rels := SafeLinkRels()
+	if len(rels) == 0 || len(SafeAttributeNames()) == 0 || len(URLAttributeNames()) == 0 || len(DefaultTrackingParameters()) == 0 {
+		t.Fatal("the policy tables should not be empty")
+	}
+	rels[0] = "stylesheet-injected"
+	for _, rel := range SafeLinkRels() {
+		if rel == "stylesheet-injected" {
+			t.Error("the returned values should be a copy of the policy")
+		}
+	}
+}
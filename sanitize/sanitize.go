@@ -0,0 +1,211 @@
+// Package sanitize rewrites HTML documents, stylesheets, SVG images, WebVTT subtitles and feeds so they
+// can be displayed without JavaScript and only load resources through a morty instance.
+//
+// A Sanitizer is created for each document: its BaseURL is updated by the <base> element of the document.
+//
+//	s := &sanitize.Sanitizer{BaseURL: pageURL, Key: key}
+//	err := s.HTML(w, r)
+//
+// The URLs are rewritten to "./?mortyhash=<hash>&mortyurl=<url>", relative to the morty instance.
+package sanitize
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/url"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	stateDefault    int = 0
+	stateInStyle    int = 1
+	stateInNoscript int = 2
+)
+
+const (
+	IframePolicyNone       = "none"
+	IframePolicySameOrigin = "same-origin"
+	IframePolicyAll        = "all"
+)
+
+const (
+	TargetPolicyKeep     = "keep"
+	TargetPolicySelf     = "self"
+	TargetPolicyNoopener = "noopener"
+)
+
+const (
+	StructuredDataNone     = "none"
+	StructuredDataPreserve = "preserve"
+	StructuredDataProxify  = "proxify"
+)
+
+// DefaultHead is written after the <head> tag: the output is encoded in UTF-8 and the pages send no Referer
+const DefaultHead = `<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta http-equiv="X-UA-Compatible" content="IE=edge">
+<meta name="referrer" content="no-referrer">
+`
+
+// DefaultFormExtension writes the hidden fields which route a submitted form through morty
+var DefaultFormExtension = template.Must(template.New("html_form_extension").Parse(
+	`<input type="hidden" name="mortyurl" value="{{.BaseURL}}" />{{if .MortyHash}}<input type="hidden" name="mortyhash" value="{{.MortyHash}}" />{{end}}{{if .TextOnly}}<input type="hidden" name="mortytext" value="1" />{{end}}`))
+
+// FormExtensionParam is the data of the form extension: the URL the form is submitted to and its hash
+type FormExtensionParam struct {
+	BaseURL   string
+	MortyHash string
+	TextOnly  bool
+}
+
+// Sanitizer holds the options of a document and the state shared by its sanitizers.
+// It must not be used by several goroutines.
+type Sanitizer struct {
+	// HMAC key of the proxified URLs, nil if the URLs are not signed
+	Key []byte
+	// URL of the document, the relative URLs are resolved against it
+	BaseURL *url.URL
+	// <iframe> policy: none, same-origin or all
+	IframePolicy string
+	// microdata and RDFa attributes policy: none, preserve or proxify
+	StructuredData string
+	// target attributes policy: keep, self (removed) or noopener (rel="noopener" on the links opening a new tab)
+	TargetPolicy string
+	// remove images, fonts and background images
+	TextOnly bool
+	// keep the data-* attributes
+	DataAttributes bool
+	// <link rel> values allowed in addition to SafeLinkRels()
+	LinkRels map[string]bool
+	// query parameters ignored to detect the links to the current document, DefaultTrackingParameters() if nil
+	TrackingParameters map[string]bool
+	// URI schemes (ie: mailto) kept as direct links with rel="noreferrer" instead of being proxified
+	DirectSchemes map[string]bool
+	// remove the tracking pixels: 1x1 images, images of known trackers and beacon paths
+	DropTrackingPixels bool
+	// reports whether the subresources of a host are blocked: their elements and CSS URLs are removed, nil if none
+	BlockedHost func(hostname string) bool
+	// text only mode has been requested by the "mortytext" parameter: it is added to the proxified URLs
+	TextOnlyParam bool
+	// unix time after which the proxified URLs are rejected, 0 if they don't expire
+	Expires int64
+	// do not inject the body extension and the form extension
+	NoHeader bool
+	// written after the <head> tag, DefaultHead if empty
+	Head string
+	// writes the content injected before </body>, or at the end of the document, nothing if nil
+	BodyExtension func(out io.Writer, s *Sanitizer) error
+	// writes the content injected after <form>, DefaultFormExtension if nil
+	FormExtension func(out io.Writer, param FormExtensionParam) error
+	// custom rules applied in order, their results are sanitized
+	ElementHooks   []ElementHook
+	AttributeHooks []AttributeHook
+	URLHooks       []URLHook
+
+	bodyInjected bool
+	// first error of the HTML parser or of the extensions
+	err error
+}
+
+// HTML sanitizes the HTML read from r, the output is written while the input is read.
+// It returns the first parse or extension error, the output is complete anyway.
+func (s *Sanitizer) HTML(out io.Writer, r io.Reader) error {
+	s.err = nil
+	sanitizeHTMLReader(s, out, r)
+	return s.err
+}
+
+// HTMLDocument sanitizes a whole document like HTML, the body extension is injected even if there is no </body>
+func (s *Sanitizer) HTMLDocument(out io.Writer, r io.Reader) error {
+	s.err = nil
+	sanitizeHTMLDocument(s, out, r)
+	return s.err
+}
+
+// CSS sanitizes a stylesheet while it is read from r, the top-level rules are written once they are complete
+func (s *Sanitizer) CSS(out io.Writer, r io.Reader) error {
+	return sanitizeChunks(r, lastCSSRuleEnd, func(css []byte) {
+		sanitizeCSS(s, out, css)
+	})
+}
+
+// SVG sanitizes a SVG image, the output is a standalone XML document
+func (s *Sanitizer) SVG(out io.Writer, r io.Reader) error {
+	svg, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return sanitizeSVG(s, out, svg)
+}
+
+// VTT sanitizes WebVTT subtitles while they are read from r, the blocks are written once they are complete
+func (s *Sanitizer) VTT(out io.Writer, r io.Reader) error {
+	_, _ = io.WriteString(out, "WEBVTT\n")
+	header := true
+	return sanitizeChunks(r, lastVTTBlockEnd, func(vtt []byte) {
+		sanitizeVTTBlocks(s, out, vtt, header)
+		header = false
+	})
+}
+
+// Feed sanitizes a RSS or Atom feed, the links to the feeds are rewritten to mortyURL
+// so feed readers can subscribe to them
+func (s *Sanitizer) Feed(out io.Writer, r io.Reader, mortyURL *url.URL) error {
+	feed, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return sanitizeFeed(s, out, mortyURL, feed)
+}
+
+// initial size of the buffer of sanitizeChunks
+const chunkSize = 32 * 1024
+
+// sanitizeChunks reads r and passes the input to sanitize in chunks ending where the sanitizer can start again,
+// boundary returns the end of the last complete chunk of its input or 0. The rest of the input is passed at the end
+// of r, it is dropped if r fails.
+func sanitizeChunks(r io.Reader, boundary func([]byte) int, sanitize func([]byte)) error {
+	buf := make([]byte, 0, chunkSize)
+	for {
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			sanitize(buf)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(buf) < cap(buf) {
+			continue
+		}
+		if end := boundary(buf); end > 0 {
+			sanitize(buf[:end])
+			buf = buf[:copy(buf, buf[end:])]
+		}
+		if len(buf) > cap(buf)/2 {
+			// no boundary near the end of the input: the buffer grows
+			buf = append(buf[:cap(buf)], 0)[:len(buf)]
+		}
+	}
+}
+
+// RepairHTML parses the document into a tree and serializes it again: unbalanced and mis-nested tags are fixed
+// the same way browsers fix them, before the document is sanitized.
+func RepairHTML(htmlDoc []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(htmlDoc))
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(htmlDoc)))
+	if err := html.Render(out, doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (s *Sanitizer) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
@@ -0,0 +1,231 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	SVGNamespace   = "http://www.w3.org/2000/svg"
+	XLinkNamespace = "http://www.w3.org/1999/xlink"
+	XMLNamespace   = "http://www.w3.org/XML/1998/namespace"
+)
+
+// SVG elements kept by sanitizeSVG: scripts, foreign objects, animations (they can set any attribute),
+// and the elements of other namespaces (editor metadata) are removed with their content
+var svgSafeElements = map[string]bool{
+	"a":                   true,
+	"circle":              true,
+	"clipPath":            true,
+	"defs":                true,
+	"desc":                true,
+	"ellipse":             true,
+	"feBlend":             true,
+	"feColorMatrix":       true,
+	"feComponentTransfer": true,
+	"feComposite":         true,
+	"feConvolveMatrix":    true,
+	"feDiffuseLighting":   true,
+	"feDisplacementMap":   true,
+	"feDistantLight":      true,
+	"feDropShadow":        true,
+	"feFlood":             true,
+	"feFuncA":             true,
+	"feFuncB":             true,
+	"feFuncG":             true,
+	"feFuncR":             true,
+	"feGaussianBlur":      true,
+	"feImage":             true,
+	"feMerge":             true,
+	"feMergeNode":         true,
+	"feMorphology":        true,
+	"feOffset":            true,
+	"fePointLight":        true,
+	"feSpecularLighting":  true,
+	"feSpotLight":         true,
+	"feTile":              true,
+	"feTurbulence":        true,
+	"filter":              true,
+	"g":                   true,
+	"image":               true,
+	"line":                true,
+	"linearGradient":      true,
+	"marker":              true,
+	"mask":                true,
+	"path":                true,
+	"pattern":             true,
+	"polygon":             true,
+	"polyline":            true,
+	"radialGradient":      true,
+	"rect":                true,
+	"stop":                true,
+	"style":               true,
+	"svg":                 true,
+	"switch":              true,
+	"symbol":              true,
+	"text":                true,
+	"textPath":            true,
+	"title":               true,
+	"tspan":               true,
+	"use":                 true,
+	"view":                true,
+}
+
+type svgElement struct {
+	// prefixes declared by the element
+	namespaces map[string]string
+	// the element and its content are not written
+	unsafe bool
+	// the text is a style sheet
+	style bool
+}
+
+// sanitizeSVG writes a SVG image without scripts, event handlers and external references:
+// the links and the images are proxified, the style sheets are sanitized like CSS.
+func sanitizeSVG(s *Sanitizer, out io.Writer, svg []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	_, _ = io.WriteString(out, xml.Header)
+
+	var stack []*svgElement
+	css := bytes.NewBuffer(nil)
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &svgElement{namespaces: make(map[string]string)}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					e.namespaces[attr.Name.Local] = attr.Value
+				} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					e.namespaces[""] = attr.Value
+				}
+			}
+			stack = append(stack, e)
+
+			if len(stack) > 1 && stack[len(stack)-2].unsafe {
+				e.unsafe = true
+				break
+			}
+			if svgNamespace(stack, t.Name.Space) != SVGNamespace || !svgSafeElements[t.Name.Local] {
+				e.unsafe = true
+				break
+			}
+			e.style = t.Name.Local == "style"
+			if e.style {
+				css.Reset()
+			}
+
+			_, _ = io.WriteString(out, "<"+xmlName(t.Name))
+			for _, attr := range t.Attr {
+				sanitizeSVGAttr(s, out, stack, attr)
+			}
+			_, _ = io.WriteString(out, ">")
+		case xml.EndElement:
+			if len(stack) == 0 {
+				break
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if e.unsafe {
+				break
+			}
+			if e.style {
+				sanitized := bytes.NewBuffer(nil)
+				sanitizeCSS(s, sanitized, css.Bytes())
+				writeXMLText(out, sanitized.String())
+			}
+			_, _ = io.WriteString(out, "</"+xmlName(t.Name)+">")
+		case xml.CharData:
+			if len(stack) == 0 {
+				break
+			}
+			e := stack[len(stack)-1]
+			switch {
+			case e.unsafe:
+			case e.style:
+				css.Write(t)
+			default:
+				writeXMLText(out, string(t))
+			}
+		}
+		// comments, processing instructions (stylesheets) and directives (DTD, entities) are removed
+	}
+}
+
+func sanitizeSVGAttr(s *Sanitizer, out io.Writer, stack []*svgElement, attr xml.Attr) {
+	name := attr.Name.Local
+	value := attr.Value
+	switch {
+	case attr.Name.Space == "xmlns" || (attr.Name.Space == "" && name == "xmlns"):
+		// only the SVG and XLink namespaces are used
+		if value != SVGNamespace && value != XLinkNamespace {
+			return
+		}
+	case attr.Name.Space != "":
+		switch svgNamespace(stack, attr.Name.Space) {
+		case XLinkNamespace:
+			if name != "href" {
+				return
+			}
+			value = sanitizeSVGHref(s, value)
+		case XMLNamespace, "":
+			// xml:space and xml:lang, the xml prefix is always bound
+			if attr.Name.Space != "xml" || (name != "space" && name != "lang") {
+				return
+			}
+		default:
+			return
+		}
+	case strings.HasPrefix(strings.ToLower(name), "on"):
+		return
+	case name == "href":
+		value = sanitizeSVGHref(s, value)
+	case name == "style" || strings.Contains(strings.ToLower(value), "url("):
+		// style and presentation attributes referencing a paint server, a filter or a mask
+		sanitized := bytes.NewBuffer(nil)
+		sanitizeCSS(s, sanitized, []byte(value))
+		value = sanitized.String()
+	}
+	if value == "" {
+		return
+	}
+	_, _ = io.WriteString(out, " "+xmlName(attr.Name)+`="`)
+	writeXMLText(out, value)
+	_, _ = io.WriteString(out, `"`)
+}
+
+// svgNamespace returns the namespace bound to prefix
+func svgNamespace(stack []*svgElement, prefix string) string {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if ns, ok := stack[i].namespaces[prefix]; ok {
+			return ns
+		}
+	}
+	return ""
+}
+
+// sanitizeSVGHref returns the morty URL of a link or an image, the references to the document are kept
+func sanitizeSVGHref(s *Sanitizer, href string) string {
+	if strings.HasPrefix(strings.TrimSpace(href), "#") {
+		return strings.TrimSpace(href)
+	}
+	uri, err := s.ProxifyURI([]byte(href))
+	if err != nil {
+		return ""
+	}
+	return uri
+}
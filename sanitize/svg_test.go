@@ -0,0 +1,37 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+var svgTestData = []*StringTestCase{
+	{
+		`<?xml version="1.0"?><!DOCTYPE svg><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="x()" viewBox="0 0 10 10"><script>alert(1)</script><rect width="10" height="10" fill="url(#g)" onclick="y()"/><image xlink:href="i.png"/><a href="javascript:alert(1)"><text>t</text></a><a href="/p"><text>p</text></a></svg>`,
+		`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10"><rect width="10" height="10" fill="url(&#34;#g&#34;)"></rect><image xlink:href="./?mortyurl=http%3A%2F%2Fexample.com%2Fi.png"></image><a><text>t</text></a><a href="./?mortyurl=http%3A%2F%2Fexample.com%2Fp"><text>p</text></a></svg>`,
+	},
+	{
+		`<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"><inkscape:grid/><foreignObject><div xmlns="http://www.w3.org/1999/xhtml">x</div></foreignObject><set attributeName="href" to="javascript:alert(1)"/><style>rect{fill:url(http://example.com/f.png)}</style><g inkscape:label="l" style="background:url(b.png)"/></svg>`,
+		`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<svg xmlns="http://www.w3.org/2000/svg"><style>rect{fill:url(&#34;./?mortyurl=http%3A%2F%2Fexample.com%2Ff.png&#34;)}</style><g style="background:url(&#34;./?mortyurl=http%3A%2F%2Fexample.com%2Fb.png&#34;)"></g></svg>`,
+	},
+}
+
+func TestSVGSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://example.com/image.svg")
+	for _, testCase := range svgTestData {
+		out := bytes.NewBuffer(nil)
+		if err := sanitizeSVG(&Sanitizer{BaseURL: u}, out, []byte(testCase.Input)); err != nil {
+			t.Errorf(`SVG sanitizer error. Input: "%s", Error: %v`, testCase.Input, err)
+			continue
+		}
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`SVG sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+)
+
+// isTrackingPixel reports whether an <img> element is a tracking pixel: a 1x1 image, an image of a tracker host
+// or an image whose path is a beacon path. Proxifying it would still signal the page view to the tracker.
+func (s *Sanitizer) isTrackingPixel(attrs []htmlAttr) bool {
+	var width, height, src []byte
+	for _, attr := range attrs {
+		switch string(attr.Name) {
+		case "width":
+			width = attr.Value
+		case "height":
+			height = attr.Value
+		case "src":
+			src = attr.Value
+		}
+	}
+	if isPixelSize(width) && isPixelSize(height) {
+		return true
+	}
+	if src == nil {
+		return false
+	}
+	u := s.resolveURI(src)
+	if u == nil {
+		return false
+	}
+	return isTrackerHost(u.Hostname()) || beaconPathRegexp.MatchString(u.Path)
+}
+
+// isPixelSize reports whether the width or height attribute of an image is at most one pixel
+func isPixelSize(value []byte) bool {
+	value = bytes.TrimSuffix(bytes.TrimSpace(value), []byte("px"))
+	return bytes.Equal(value, []byte("0")) || bytes.Equal(value, []byte("1"))
+}
+
+// isTrackerHost reports whether hostname or one of its parent domains is in trackerHosts
+func isTrackerHost(hostname string) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	for hostname != "" {
+		if trackerHosts[hostname] {
+			return true
+		}
+		i := strings.IndexByte(hostname, '.')
+		if i == -1 {
+			break
+		}
+		hostname = hostname[i+1:]
+	}
+	return false
+}
+
+// isBlockedSubresource reports whether an element loads a subresource of a host blocked by BlockedHost
+func (s *Sanitizer) isBlockedSubresource(tag []byte, attrs []htmlAttr) bool {
+	names := subresourceAttributes[string(tag)]
+	for _, attr := range attrs {
+		if names[string(attr.Name)] && s.isBlockedURI(attr.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockedURI reports whether the host of a URI is blocked by BlockedHost
+func (s *Sanitizer) isBlockedURI(uri []byte) bool {
+	if s.BlockedHost == nil {
+		return false
+	}
+	u := s.resolveURI(uri)
+	return u != nil && u.Hostname() != "" && s.BlockedHost(u.Hostname())
+}
+
+// resolveURI parses a URI of the document and resolves it against the BaseURL, nil if it is invalid
+func (s *Sanitizer) resolveURI(uri []byte) *url.URL {
+	sanitized, _ := sanitizeURI(uri)
+	u, err := url.Parse(string(sanitized))
+	if err != nil {
+		return nil
+	}
+	return mergeURIs(s.BaseURL, u)
+}
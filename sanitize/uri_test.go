@@ -0,0 +1,144 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+type SanitizeURITestCase struct {
+	Input          []byte
+	ExpectedOutput []byte
+	ExpectedScheme string
+}
+
+var sanitizeUriTestData = []*SanitizeURITestCase{
+	{
+		[]byte("http://example.com/"),
+		[]byte("http://example.com/"),
+		"http:",
+	},
+	{
+		[]byte("HtTPs://example.com/     \t"),
+		[]byte("https://example.com/"),
+		"https:",
+	},
+	{
+		[]byte("      Ht  TPs://example.com/     \t"),
+		[]byte("https://example.com/"),
+		"https:",
+	},
+	{
+		[]byte("javascript:void(0)"),
+		[]byte("javascript:void(0)"),
+		"javascript:",
+	},
+	{
+		[]byte("      /path/to/a/file/without/protocol     "),
+		[]byte("/path/to/a/file/without/protocol"),
+		"",
+	},
+	{
+		[]byte("      #fragment     "),
+		[]byte("#fragment"),
+		"",
+	},
+	{
+		[]byte("      qwertyuiop     "),
+		[]byte("qwertyuiop"),
+		"",
+	},
+	{
+		[]byte(""),
+		[]byte(""),
+		"",
+	},
+	{
+		[]byte(":"),
+		[]byte(":"),
+		":",
+	},
+	{
+		[]byte("   :"),
+		[]byte(":"),
+		":",
+	},
+	{
+		[]byte("schéma:"),
+		[]byte("schéma:"),
+		"schéma:",
+	},
+}
+
+var urlTestData = []*StringTestCase{
+	{
+		"http://x.com/",
+		"./?mortyurl=http%3A%2F%2Fx.com%2F",
+	},
+	{
+		"http://a@x.com/",
+		"./?mortyurl=http%3A%2F%2Fa%40x.com%2F",
+	},
+	{
+		"#a",
+		"#a",
+	},
+	{
+		"http://127.0.0.1:80/#b",
+		"#b",
+	},
+	{
+		"/?utm_source=x&utm_medium=y#c",
+		"#c",
+	},
+	{
+		"/?page=2#d",
+		"./?mortyurl=http%3A%2F%2F127.0.0.1%2F%3Fpage%3D2#d",
+	},
+	{
+		"http://bücher.example:8080/ä",
+		"./?mortyurl=http%3A%2F%2Fxn--bcher-kva.example%3A8080%2F%25C3%25A4",
+	},
+	{
+		"https://%E4%BE%8B%E3%81%88.JP/",
+		"./?mortyurl=https%3A%2F%2Fxn--r8jz45g.jp%2F",
+	},
+}
+
+func TestSanitizeURI(t *testing.T) {
+	for _, testCase := range sanitizeUriTestData {
+		newUrl, scheme := sanitizeURI(testCase.Input)
+		if !bytes.Equal(newUrl, testCase.ExpectedOutput) {
+			t.Errorf(
+				`URL proxifier error. Expected: "%s", Got: "%s"`,
+				testCase.ExpectedOutput,
+				newUrl,
+			)
+		}
+		if scheme != testCase.ExpectedScheme {
+			t.Errorf(
+				`URL proxifier error. Expected: "%s", Got: "%s"`,
+				testCase.ExpectedScheme,
+				scheme,
+			)
+		}
+	}
+}
+
+func TestURLProxifier(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	s := &Sanitizer{BaseURL: u}
+	for _, testCase := range urlTestData {
+		newUrl, err := s.ProxifyURI([]byte(testCase.Input))
+		if err != nil {
+			t.Errorf("Failed to parse URL: %s", testCase.Input)
+		}
+		if newUrl != testCase.ExpectedOutput {
+			t.Errorf(
+				`URL proxifier error. Expected: "%s", Got: "%s"`,
+				testCase.ExpectedOutput,
+				newUrl,
+			)
+		}
+	}
+}
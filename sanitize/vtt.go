@@ -0,0 +1,174 @@
+package sanitize
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// cue settings defined by the WebVTT specification
+var vttCueSettings = map[string]bool{
+	"align":    true,
+	"line":     true,
+	"position": true,
+	"region":   true,
+	"size":     true,
+	"vertical": true,
+}
+
+// region settings defined by the WebVTT specification
+var vttRegionSettings = map[string]bool{
+	"id":             true,
+	"lines":          true,
+	"regionanchor":   true,
+	"scroll":         true,
+	"viewportanchor": true,
+	"width":          true,
+}
+
+// cue text tags, the timestamp tags are kept too
+var vttCueTags = map[string]bool{
+	"b":    true,
+	"c":    true,
+	"i":    true,
+	"lang": true,
+	"rt":   true,
+	"ruby": true,
+	"u":    true,
+	"v":    true,
+}
+
+var vttBlockSeparator = regexp.MustCompile(`\n{2,}`)
+
+// sanitizeVTT writes a WebVTT file: the style blocks are sanitized like CSS, the comments are removed,
+// the cue settings and the cue text tags are limited to the ones defined by the specification.
+func sanitizeVTT(s *Sanitizer, out io.Writer, vtt []byte) {
+	_, _ = io.WriteString(out, "WEBVTT\n")
+	sanitizeVTTBlocks(s, out, vtt, true)
+}
+
+// sanitizeVTTBlocks writes the blocks of a part of a WebVTT file, header is true if the part is the beginning
+// of the file
+func sanitizeVTTBlocks(s *Sanitizer, out io.Writer, vtt []byte, header bool) {
+	vtt = bytes.ReplaceAll(vtt, []byte("\r\n"), []byte("\n"))
+	vtt = bytes.ReplaceAll(vtt, []byte("\r"), []byte("\n"))
+	if header {
+		vtt = bytes.TrimPrefix(vtt, []byte("\xef\xbb\xbf"))
+	}
+
+	for i, block := range vttBlockSeparator.Split(string(vtt), -1) {
+		lines := bytes.Split(bytes.Trim([]byte(block), "\n"), []byte("\n"))
+		first := lines[0]
+		switch {
+		case header && i == 0 && bytes.HasPrefix(first, []byte("WEBVTT")):
+			// the header and its metadata are replaced
+		case isVTTBlock(first, "NOTE"):
+		case isVTTBlock(first, "STYLE"):
+			_, _ = io.WriteString(out, "\nSTYLE\n")
+			sanitizeCSS(s, out, bytes.Join(lines[1:], []byte("\n")))
+			_, _ = io.WriteString(out, "\n")
+		case isVTTBlock(first, "REGION"):
+			_, _ = io.WriteString(out, "\nREGION\n")
+			for _, line := range lines[1:] {
+				writeVTTSettings(out, line, vttRegionSettings, []byte("\n"))
+			}
+		default:
+			sanitizeVTTCue(out, lines)
+		}
+	}
+}
+
+// lastVTTBlockEnd returns the end of the last blank line of a part of a WebVTT file, 0 if there is none
+func lastVTTBlockEnd(vtt []byte) int {
+	end := bytes.LastIndex(vtt, []byte("\n\n")) + 2
+	if i := bytes.LastIndex(vtt, []byte("\n\r\n")) + 3; i > end {
+		end = i
+	}
+	if end < 3 {
+		return 0
+	}
+	return end
+}
+
+func isVTTBlock(line []byte, name string) bool {
+	return bytes.Equal(line, []byte(name)) ||
+		(bytes.HasPrefix(line, []byte(name)) && (line[len(name)] == ' ' || line[len(name)] == '\t'))
+}
+
+func sanitizeVTTCue(out io.Writer, lines [][]byte) {
+	timing := -1
+	for i, line := range lines {
+		if bytes.Contains(line, []byte("-->")) {
+			timing = i
+			break
+		}
+	}
+	// the identifier is optional, other lines are invalid
+	if timing < 0 || timing > 1 {
+		return
+	}
+
+	// start --> end [settings]
+	fields := bytes.Fields(lines[timing])
+	if len(fields) < 3 || !bytes.Equal(fields[1], []byte("-->")) {
+		return
+	}
+
+	_, _ = io.WriteString(out, "\n")
+	if timing == 1 {
+		_, _ = out.Write(lines[0])
+		_, _ = io.WriteString(out, "\n")
+	}
+	_, _ = out.Write(bytes.Join(fields[:3], []byte(" ")))
+	for _, setting := range fields[3:] {
+		writeVTTSettings(out, setting, vttCueSettings, nil)
+	}
+	_, _ = io.WriteString(out, "\n")
+
+	for _, line := range lines[timing+1:] {
+		sanitizeVTTCueText(out, line)
+		_, _ = io.WriteString(out, "\n")
+	}
+}
+
+// writeVTTSettings writes a "name:value" setting if its name is allowed.
+// Cue settings are separated by spaces, region settings by new lines.
+func writeVTTSettings(out io.Writer, setting []byte, allowed map[string]bool, suffix []byte) {
+	setting = bytes.TrimSpace(setting)
+	colon := bytes.IndexByte(setting, ':')
+	if colon <= 0 || !allowed[string(setting[:colon])] {
+		return
+	}
+	if suffix == nil {
+		_, _ = io.WriteString(out, " ")
+	}
+	_, _ = out.Write(setting)
+	_, _ = out.Write(suffix)
+}
+
+// sanitizeVTTCueText removes the unknown tags of a cue text line
+func sanitizeVTTCueText(out io.Writer, line []byte) {
+	for len(line) > 0 {
+		start := bytes.IndexByte(line, '<')
+		if start < 0 {
+			_, _ = out.Write(line)
+			return
+		}
+		_, _ = out.Write(line[:start])
+		end := bytes.IndexByte(line[start:], '>')
+		if end < 0 {
+			// unterminated tag: ignored by the WebVTT parser
+			return
+		}
+		tag := line[start : start+end+1]
+		line = line[start+end+1:]
+
+		name := bytes.TrimPrefix(tag[1:len(tag)-1], []byte("/"))
+		if i := bytes.IndexAny(name, ". \t"); i >= 0 {
+			name = name[:i]
+		}
+		if vttCueTags[string(name)] || (len(name) > 0 && name[0] >= '0' && name[0] <= '9') {
+			_, _ = out.Write(tag)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var vttTestData = []*StringTestCase{
+	{
+		"WEBVTT - title\r\nKind: captions\r\n\r\nNOTE a comment\r\n\r\n1\r\n00:01.000 --> 00:04.000 align:start onload:x position:10%\r\n<v Bob><b>Hi</b> <script>x</script><00:02.000>there\r\n",
+		"WEBVTT\n\n1\n00:01.000 --> 00:04.000 align:start position:10%\n<v Bob><b>Hi</b> x<00:02.000>there\n",
+	},
+	{
+		"WEBVTT\n\nSTYLE\n::cue { background: url(http://example.com/bg.png) }\n\nREGION\nid:r1\nwidth:40%\nfoo:bar\n\nnot a cue\n",
+		"WEBVTT\n\nSTYLE\n::cue { background: url(\"./?mortyurl=http%3A%2F%2Fexample.com%2Fbg.png\") }\n\nREGION\nid:r1\nwidth:40%\n",
+	},
+}
+
+func TestVTTSanitizer(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	for _, testCase := range vttTestData {
+		out := bytes.NewBuffer(nil)
+		sanitizeVTT(&Sanitizer{BaseURL: u}, out, []byte(testCase.Input))
+		if out.String() != testCase.ExpectedOutput {
+			t.Errorf(
+				`WebVTT sanitizer error. Input: "%s", Expected: "%s", Got: "%s"`,
+				testCase.Input,
+				testCase.ExpectedOutput,
+				out.String(),
+			)
+		}
+	}
+}
+
+func TestVTTChunks(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1/")
+	vtt := "\xef\xbb\xbfWEBVTT\r\n\r\n" + strings.Repeat("NOTE x\r\n\r\n1\r\n00:01.000 --> 00:04.000 onload:x\r\n<b>Hi</b> <script>x</script>\r\n\r\n", 2000)
+	expected := bytes.NewBuffer(nil)
+	sanitizeVTT(&Sanitizer{BaseURL: u}, expected, []byte(vtt))
+	out := bytes.NewBuffer(nil)
+	if err := (&Sanitizer{BaseURL: u}).VTT(out, iotest.HalfReader(strings.NewReader(vtt))); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("The subtitles sanitized in chunks differ from the whole file (%d and %d bytes)", out.Len(), expected.Len())
+	}
+}